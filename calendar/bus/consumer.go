@@ -0,0 +1,84 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/postgres"
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/alexdunne/not-so-smart-cal/pkg/schema"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "calendar",
+	Subsystem: "amqp",
+	Name:      "messages_consumed_total",
+	Help:      "Number of messages consumed, partitioned by routing key and outcome.",
+}, []string{"routing_key", "outcome"})
+
+type WeatherUpdateRecorder interface {
+	RecordWeatherUpdate(ctx context.Context, eventID string, weather json.RawMessage) error
+}
+
+// WeatherUpdatesConsumer records the forecasts published by the weather service in the change log.
+// The queue is shared by every replica, the change log fans the updates out to each of them
+type WeatherUpdatesConsumer struct {
+	subscriber messaging.Subscriber
+	recorder   WeatherUpdateRecorder
+	logger     *zap.Logger
+}
+
+func NewWeatherUpdatesConsumer(
+	subscriber messaging.Subscriber,
+	recorder WeatherUpdateRecorder,
+	logger *zap.Logger,
+) *WeatherUpdatesConsumer {
+	return &WeatherUpdatesConsumer{
+		subscriber: subscriber,
+		recorder:   recorder,
+		logger:     logger,
+	}
+}
+
+// StartConsumer consumes messages until the context is cancelled
+func (c *WeatherUpdatesConsumer) StartConsumer(ctx context.Context, binding messaging.Binding) error {
+	return c.subscriber.Subscribe(ctx, binding, c.handle)
+}
+
+func (c *WeatherUpdatesConsumer) handle(ctx context.Context, msg *messaging.Message) (err error) {
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			c.logger.Error("error recording weather update", zap.Error(err))
+			outcome = "error"
+		}
+		messagesConsumed.WithLabelValues(msg.RoutingKey, outcome).Inc()
+	}()
+
+	envelope, err := schema.Decode(msg)
+	if err != nil {
+		return messaging.Permanent(errors.Wrap(err, "error decoding weather update"))
+	}
+
+	update, err := schema.DecodeWeatherUpdate(envelope)
+	if err != nil {
+		return messaging.Permanent(errors.Wrap(err, "error decoding weather update"))
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("event.id", update.EventID))
+
+	err = c.recorder.RecordWeatherUpdate(ctx, update.EventID, update.Weather)
+	if errors.Is(err, postgres.ErrEventNotFound) {
+		// the event was deleted after the forecast was requested, there's nobody left to tell
+		c.logger.Info("dropping weather update for a deleted event", zap.String("eventId", update.EventID))
+		return nil
+	}
+
+	return err
+}
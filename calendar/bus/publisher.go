@@ -0,0 +1,99 @@
+package bus
+
+import (
+	"context"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/alexdunne/not-so-smart-cal/pkg/schema"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var messagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "calendar",
+	Subsystem: "amqp",
+	Name:      "messages_published_total",
+	Help:      "Number of messages published, partitioned by exchange, routing key and outcome.",
+}, []string{"exchange", "routing_key", "outcome"})
+
+type CalendarPublisher struct {
+	publisher    messaging.Publisher
+	exchangeName string
+	logger       *zap.Logger
+}
+
+func NewCalendarPublisher(
+	publisher messaging.Publisher,
+	exchangeName string,
+	logger *zap.Logger,
+) *CalendarPublisher {
+	return &CalendarPublisher{
+		publisher:    publisher,
+		exchangeName: exchangeName,
+		logger:       logger,
+	}
+}
+
+// eventChangeTypes maps the changes published to their message types, the routing key is the change type
+var eventChangeTypes = map[model.EventChangeType]string{
+	model.EventCreated: schema.EventCreated,
+	model.EventUpdated: schema.EventUpdated,
+	model.EventDeleted: schema.EventDeleted,
+}
+
+// PublishEventChange announces the change to the event to the exchange
+func (p *CalendarPublisher) PublishEventChange(ctx context.Context, changeType model.EventChangeType, event *model.Event) (err error) {
+	routingKey := string(changeType)
+
+	p.logger.Info("publishing message", zap.String("exchange", p.exchangeName), zap.String("routing key", routingKey))
+
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		messagesPublished.WithLabelValues(p.exchangeName, routingKey, outcome).Inc()
+	}()
+
+	data := &schema.CalendarEvent{
+		ID:        event.ID,
+		Title:     event.Title,
+		Location:  event.Location,
+		StartsAt:  event.StartsAt,
+		EndsAt:    event.EndsAt,
+		CreatedAt: event.CreatedAt,
+	}
+
+	if venue := event.Venue; venue != nil {
+		data.Venue = &schema.Venue{
+			Kind:     string(venue.Kind),
+			Address:  venue.Address,
+			URL:      venue.URL,
+			Provider: venue.Provider,
+		}
+
+		if venue.Place != nil {
+			data.Venue.Place = &schema.Place{
+				Name:      venue.Place.Name,
+				Latitude:  venue.Place.Latitude,
+				Longitude: venue.Place.Longitude,
+				Country:   venue.Place.Country,
+				State:     venue.Place.State,
+			}
+		}
+	}
+
+	envelope, err := schema.NewCalendarEvent(eventChangeTypes[changeType], data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := envelope.Message()
+	if err != nil {
+		return err
+	}
+
+	return p.publisher.Publish(ctx, p.exchangeName, routingKey, msg)
+}
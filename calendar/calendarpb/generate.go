@@ -0,0 +1,4 @@
+// Package calendarpb contains the protobuf messages and gRPC service definitions for the calendar service
+package calendarpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative calendar.proto
@@ -0,0 +1,4 @@
+// Package client is a typed Go client for the calendar http api, generated from the OpenAPI document
+package client
+
+//go:generate go run github.com/deepmap/oapi-codegen/cmd/oapi-codegen -generate types,client -package client -o client.gen.go ../openapi/openapi.json
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/calendar/postgres"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// keepAliveInterval is how often a comment is sent on idle streams so proxies don't close them
+const keepAliveInterval = 15 * time.Second
+
+type WatchChangesInput struct {
+	StartsAt time.Time `form:"startsAt"`
+	EndsAt   time.Time `form:"endsAt"`
+	// LastEventID allows resuming from clients that can't set the Last-Event-ID header
+	LastEventID int64 `form:"lastEventId"`
+}
+
+// watchChanges streams changes to events overlapping the requested window as server-sent events.
+// Clients resuming with a Last-Event-ID first receive every change they missed
+func (s *Server) watchChanges(c *gin.Context) {
+	var input WatchChangesInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lastID := input.LastEventID
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be a change id"})
+			return
+		}
+		lastID = id
+	}
+
+	ctx := c.Request.Context()
+
+	// subscribe before replaying so nothing recorded in between is missed, duplicates are skipped by id
+	sub := s.changes.Subscribe()
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	s.logger.Info(
+		"client watching changes",
+		zap.Time("startsAt", input.StartsAt),
+		zap.Time("endsAt", input.EndsAt),
+		zap.Int64("lastEventId", lastID),
+	)
+
+	if lastID > 0 {
+		var err error
+		lastID, err = s.replayChanges(ctx, c.Writer, lastID, input.StartsAt, input.EndsAt)
+		if errors.Is(err, postgres.ErrChangesExpired) {
+			// the client has missed more than the change log holds and must reload what it's showing
+			if err := writeEvent(c.Writer, "", "reset", []byte("{}")); err != nil {
+				return
+			}
+			lastID = 0
+		} else if err != nil {
+			s.logger.Error("error replaying changes", zap.Error(err))
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-keepAlive.C:
+			if err := writeComment(c.Writer, "keep-alive"); err != nil {
+				return
+			}
+
+		case change, ok := <-sub.C:
+			if !ok {
+				// lagging clients reconnect with their last id and catch up from the change log
+				s.logger.Info("change stream ended", zap.Error(sub.Err()))
+				return
+			}
+
+			if change.ID <= lastID || !change.Event.Overlaps(input.StartsAt, input.EndsAt) {
+				continue
+			}
+
+			if err := writeChange(c.Writer, &change); err != nil {
+				return
+			}
+			lastID = change.ID
+		}
+	}
+}
+
+// replayChanges writes every change after lastID in the window, returning the id of the last one written
+func (s *Server) replayChanges(ctx context.Context, w gin.ResponseWriter, lastID int64, startsAt, endsAt time.Time) (int64, error) {
+	for {
+		changes, err := s.eventService.ChangesSince(ctx, lastID, startsAt, endsAt)
+		if err != nil {
+			return lastID, err
+		}
+
+		for _, change := range changes {
+			if err := writeChange(w, change); err != nil {
+				return lastID, err
+			}
+			lastID = change.ID
+		}
+
+		if len(changes) == 0 {
+			return lastID, nil
+		}
+	}
+}
+
+func writeChange(w gin.ResponseWriter, change *model.EventChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	return writeEvent(w, strconv.FormatInt(change.ID, 10), string(change.Type), data)
+}
+
+func writeEvent(w gin.ResponseWriter, id, event string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	w.Flush()
+
+	return nil
+}
+
+func writeComment(w gin.ResponseWriter, comment string) error {
+	if _, err := fmt.Fprintf(w, ": %s\n\n", comment); err != nil {
+		return err
+	}
+	w.Flush()
+
+	return nil
+}
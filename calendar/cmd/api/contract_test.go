@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/client"
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/calendar/openapi"
+	"github.com/alexdunne/not-so-smart-cal/calendar/postgres"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+)
+
+// fakeEventService keeps events in memory and validates them the way the postgres store does
+type fakeEventService struct {
+	mu        sync.Mutex
+	validator *validator.Validate
+	events    map[string]*model.Event
+	nextID    int
+}
+
+func newFakeEventService() *fakeEventService {
+	return &fakeEventService{
+		validator: validator.New(),
+		events:    make(map[string]*model.Event),
+	}
+}
+
+func (f *fakeEventService) FindInTimeRange(ctx context.Context, startsAt, endsAt time.Time) ([]*model.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := []*model.Event{}
+	for _, event := range f.events {
+		if event.Overlaps(startsAt, endsAt) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].StartsAt.Before(events[j].StartsAt) })
+
+	return events, nil
+}
+
+func (f *fakeEventService) FindEventByID(ctx context.Context, id string) (*model.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event, ok := f.events[id]
+	if !ok {
+		return nil, postgres.ErrEventNotFound
+	}
+
+	return event, nil
+}
+
+func (f *fakeEventService) CreateEvent(ctx context.Context, event *model.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	event.ID = fmt.Sprintf("00000000-0000-4000-8000-%012d", f.nextID)
+	event.CreatedAt = time.Date(2021, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	if err := f.validator.Struct(event); err != nil {
+		return err
+	}
+
+	f.events[event.ID] = event
+
+	return nil
+}
+
+func (f *fakeEventService) UpdateEvent(ctx context.Context, event *model.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, ok := f.events[event.ID]
+	if !ok {
+		return postgres.ErrEventNotFound
+	}
+	event.CreatedAt = existing.CreatedAt
+
+	if err := f.validator.Struct(event); err != nil {
+		return err
+	}
+
+	f.events[event.ID] = event
+
+	return nil
+}
+
+func (f *fakeEventService) DeleteEvent(ctx context.Context, id string) (*model.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event, ok := f.events[id]
+	if !ok {
+		return nil, postgres.ErrEventNotFound
+	}
+	delete(f.events, id)
+
+	return event, nil
+}
+
+func (f *fakeEventService) ChangesSince(ctx context.Context, afterID int64, startsAt, endsAt time.Time) ([]*model.EventChange, error) {
+	return nil, nil
+}
+
+// contractDoer serves the client's requests with the router, failing the test when a request or response
+// doesn't match openapi.json
+type contractDoer struct {
+	t       *testing.T
+	handler http.Handler
+	router  routers.Router
+	// validateRequests is turned off to check how the handlers respond to requests the spec doesn't allow
+	validateRequests bool
+}
+
+func (d *contractDoer) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	route, pathParams, err := d.router.FindRoute(req)
+	if err != nil {
+		d.t.Fatalf("%s %s isn't in the spec: %v", req.Method, req.URL.Path, err)
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: pathParams,
+		Route:      route,
+	}
+
+	if d.validateRequests {
+		if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+			d.t.Fatalf("%s %s request doesn't match the spec: %v", req.Method, req.URL.Path, err)
+		}
+	}
+
+	// the body was consumed by validation
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	rec := httptest.NewRecorder()
+	d.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+
+	respBody := rec.Body.Bytes()
+	err = openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Body:                   ioutil.NopCloser(bytes.NewReader(respBody)),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	if err != nil {
+		d.t.Fatalf("%s %s %d response doesn't match the spec: %v\n%s", req.Method, req.URL.Path, resp.StatusCode, err, respBody)
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	return resp, nil
+}
+
+func newContractClient(t *testing.T, eventService EventService, validateRequests bool) *client.ClientWithResponses {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	// ids are checked as well as the shape of the documents
+	openapi3.DefineStringFormat("uuid", openapi3.FormatOfStringForUUIDOfRFC4122)
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openapi.Spec)
+	if err != nil {
+		t.Fatalf("loading the spec: %v", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		t.Fatalf("the spec is invalid: %v", err)
+	}
+
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("routing the spec: %v", err)
+	}
+
+	server := &Server{
+		logger:       zap.NewNop(),
+		eventService: eventService,
+	}
+
+	doer := &contractDoer{
+		t:                t,
+		handler:          newRouter(server),
+		router:           router,
+		validateRequests: validateRequests,
+	}
+
+	// requests are made to the server in the spec and served in-process
+	c, err := client.NewClientWithResponses(doc.Servers[0].URL, client.WithHTTPClient(doer))
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+
+	return c
+}
+
+var (
+	morning   = time.Date(2021, 6, 14, 9, 0, 0, 0, time.UTC)
+	afternoon = time.Date(2021, 6, 14, 14, 0, 0, 0, time.UTC)
+)
+
+func stringPtr(s string) *string { return &s }
+
+func TestEventsContract(t *testing.T) {
+	c := newContractClient(t, newFakeEventService(), true)
+	ctx := context.Background()
+
+	// created events are 201, everything else that returns an event is 200
+	created, err := c.CreateEventWithResponse(ctx, client.CreateEventJSONRequestBody{
+		Title:    "Picnic",
+		StartsAt: morning,
+		EndsAt:   afternoon,
+		Place:    &client.Place{Name: "Hyde Park", Lat: 51.5073, Lon: -0.1657, Country: "GB"},
+	})
+	if err != nil {
+		t.Fatalf("creating event: %v", err)
+	}
+	if created.StatusCode() != http.StatusCreated || created.JSON201 == nil {
+		t.Fatalf("expected 201 with the event, got %d: %s", created.StatusCode(), created.Body)
+	}
+
+	event := created.JSON201.Data.Event
+	if event.Location != "Hyde Park" || event.Venue == nil || event.Venue.Kind != client.VenueKindPhysical || event.Venue.Place.Lat != 51.5073 {
+		t.Fatalf("expected the location to default to the place's name, got %+v", event)
+	}
+
+	found, err := c.FindEventWithResponse(ctx, event.Id)
+	if err != nil {
+		t.Fatalf("finding event: %v", err)
+	}
+	if found.StatusCode() != http.StatusOK || found.JSON200.Data.Event.Id != event.Id {
+		t.Fatalf("expected 200 with the event, got %d: %s", found.StatusCode(), found.Body)
+	}
+
+	listed, err := c.ListEventsWithResponse(ctx, &client.ListEventsParams{StartsAt: &morning, EndsAt: &afternoon})
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if listed.StatusCode() != http.StatusOK || len(listed.JSON200.Data.Events) != 1 {
+		t.Fatalf("expected 200 with the event, got %d: %s", listed.StatusCode(), listed.Body)
+	}
+
+	updated, err := c.UpdateEventWithResponse(ctx, event.Id, client.UpdateEventJSONRequestBody{
+		Title:    "Picnic call",
+		Location: stringPtr("https://zoom.us/j/123456789"),
+		StartsAt: morning,
+		EndsAt:   afternoon,
+	})
+	if err != nil {
+		t.Fatalf("updating event: %v", err)
+	}
+	if updated.StatusCode() != http.StatusOK || updated.JSON200 == nil {
+		t.Fatalf("expected 200 with the event, got %d: %s", updated.StatusCode(), updated.Body)
+	}
+	if venue := updated.JSON200.Data.Event.Venue; venue == nil || venue.Kind != client.VenueKindVirtual || *venue.Provider != "zoom" {
+		t.Fatalf("expected a zoom venue, got %+v", venue)
+	}
+
+	deleted, err := c.DeleteEventWithResponse(ctx, event.Id)
+	if err != nil {
+		t.Fatalf("deleting event: %v", err)
+	}
+	if deleted.StatusCode() != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", deleted.StatusCode(), deleted.Body)
+	}
+
+	listed, err = c.ListEventsWithResponse(ctx, &client.ListEventsParams{})
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if listed.StatusCode() != http.StatusOK || len(listed.JSON200.Data.Events) != 0 {
+		t.Fatalf("expected 200 with no events, got %d: %s", listed.StatusCode(), listed.Body)
+	}
+}
+
+func TestEventNotFoundContract(t *testing.T) {
+	c := newContractClient(t, newFakeEventService(), true)
+	ctx := context.Background()
+
+	missing := "00000000-0000-4000-8000-000000000404"
+
+	found, err := c.FindEventWithResponse(ctx, missing)
+	if err != nil {
+		t.Fatalf("finding event: %v", err)
+	}
+	if found.StatusCode() != http.StatusNotFound || found.JSON404 == nil {
+		t.Fatalf("expected 404, got %d: %s", found.StatusCode(), found.Body)
+	}
+
+	updated, err := c.UpdateEventWithResponse(ctx, missing, client.UpdateEventJSONRequestBody{Title: "Picnic", StartsAt: morning, EndsAt: afternoon})
+	if err != nil {
+		t.Fatalf("updating event: %v", err)
+	}
+	if updated.StatusCode() != http.StatusNotFound || updated.JSON404 == nil {
+		t.Fatalf("expected 404, got %d: %s", updated.StatusCode(), updated.Body)
+	}
+
+	deleted, err := c.DeleteEventWithResponse(ctx, missing)
+	if err != nil {
+		t.Fatalf("deleting event: %v", err)
+	}
+	if deleted.StatusCode() != http.StatusNotFound || deleted.JSON404 == nil {
+		t.Fatalf("expected 404, got %d: %s", deleted.StatusCode(), deleted.Body)
+	}
+}
+
+func TestInvalidEventContract(t *testing.T) {
+	tests := []struct {
+		name string
+		body client.CreateEventJSONRequestBody
+		// whether the spec allows the request, the handler has to reject it either way
+		allowed bool
+	}{
+		{
+			name:    "ending before it starts",
+			body:    client.CreateEventJSONRequestBody{Title: "Picnic", StartsAt: afternoon, EndsAt: morning},
+			allowed: true,
+		},
+		{
+			name: "short title",
+			body: client.CreateEventJSONRequestBody{Title: "P", StartsAt: morning, EndsAt: afternoon},
+		},
+		{
+			name: "place outside the world",
+			body: client.CreateEventJSONRequestBody{Title: "Picnic", StartsAt: morning, EndsAt: afternoon, Place: &client.Place{Name: "Nowhere", Lat: 120, Country: "GB"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newContractClient(t, newFakeEventService(), tt.allowed)
+
+			created, err := c.CreateEventWithResponse(context.Background(), tt.body)
+			if err != nil {
+				t.Fatalf("creating event: %v", err)
+			}
+			if created.StatusCode() != http.StatusBadRequest || created.JSON400 == nil || created.JSON400.Error == "" {
+				t.Fatalf("expected 400 with an error, got %d: %s", created.StatusCode(), created.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/locations"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type SuggestLocationsInput struct {
+	Query string `form:"q"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=5"`
+}
+
+// suggestLocations lists the places matching what the user has typed so far,
+// the one they pick is stored as the event's place so its coordinates don't have to be guessed
+func (s *Server) suggestLocations(c *gin.Context) {
+	var input SuggestLocationsInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := strings.TrimSpace(input.Query)
+	if len(query) < locations.MinQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("q must be at least %d characters", locations.MinQueryLength)})
+		return
+	}
+
+	places, err := s.locations.Suggest(c.Request.Context(), query, input.Limit)
+	if err != nil {
+		s.logger.Error("error suggesting locations", zap.String("query", query), zap.Error(err))
+		ErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"places": places,
+	}})
+}
@@ -2,23 +2,51 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/alexdunne/not-so-smart-cal/calendar/bus"
+	calendarGrpc "github.com/alexdunne/not-so-smart-cal/calendar/grpc"
+	"github.com/alexdunne/not-so-smart-cal/calendar/locations"
 	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/calendar/openapi"
 	"github.com/alexdunne/not-so-smart-cal/calendar/postgres"
-	"github.com/alexdunne/not-so-smart-cal/calendar/rabbitmq"
+	"github.com/alexdunne/not-so-smart-cal/calendar/watch"
+	"github.com/alexdunne/not-so-smart-cal/pkg/config"
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/alexdunne/not-so-smart-cal/pkg/metrics"
+	"github.com/alexdunne/not-so-smart-cal/pkg/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"github.com/streadway/amqp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 var validate *validator.Validate
 
+type Config struct {
+	HTTP     config.HTTP     `yaml:"http"`
+	GRPC     config.GRPC     `yaml:"grpc"`
+	Postgres config.Postgres `yaml:"postgres"`
+	AMQP     config.AMQP     `yaml:"amqp"`
+	Retry    config.Retry    `yaml:"retry"`
+	Shutdown config.Shutdown `yaml:"shutdown"`
+
+	ChangeRetention time.Duration `yaml:"changeRetention" env:"CHANGE_RETENTION" default:"168h" usage:"how long changes are kept for clients to resume from"`
+	WeatherAPIURL   string        `yaml:"weatherApiUrl" env:"WEATHER_API_URL" default:"http://weather-api" usage:"base url of the weather api, used to suggest locations"`
+}
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	logger, err := zap.NewProduction()
 	if err != nil {
 		fmt.Printf("error creating the logger: %v\n", err)
@@ -28,52 +56,48 @@ func main() {
 
 	logger.Info("calendar service booting")
 
-	validate = validator.New()
+	var cfg Config
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		logger.Fatal("error loading config", zap.Error(err))
+		os.Exit(1)
+	}
 
-	dbConnStr := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s",
-		os.Getenv("POSTGRES_USER"),
-		os.Getenv("POSTGRES_PASSWORD"),
-		os.Getenv("POSTGRES_HOST"),
-		os.Getenv("POSTGRES_PORT"),
-		os.Getenv("POSTGRES_DB"),
-	)
+	shutdownTracing, err := tracing.Setup(ctx, "calendar")
+	if err != nil {
+		logger.Fatal("error configuring tracing", zap.Error(err))
+		os.Exit(1)
+	}
 
-	db := postgres.NewDB(dbConnStr)
+	validate = validator.New()
+
+	db := postgres.NewDB(cfg.Postgres.ConnString())
 	if err := db.Open(context.Background()); err != nil {
 		fmt.Printf("cannot open db: %v\n", err)
 		os.Exit(1)
 	}
-	defer db.Close(context.Background())
 
-	amqpConnStr := fmt.Sprintf(
-		"amqp://%s:%s@%s:%s",
-		os.Getenv("AMQP_USER"),
-		os.Getenv("AMQP_PASSWORD"),
-		os.Getenv("AMQP_HOST"),
-		os.Getenv("AMQP_PORT"),
-	)
-	amqpConn, err := amqp.Dial(amqpConnStr)
+	publisherOpts, err := messaging.PublisherOptions(cfg.AMQP)
 	if err != nil {
-		logger.Fatal("error opening rabbitmq connection", zap.Error(err))
+		logger.Fatal("error configuring publisher", zap.Error(err))
 		os.Exit(1)
 	}
-	defer amqpConn.Close()
-	logger.Info("opened rabbitmq connection")
 
-	calendarPublisher, err := rabbitmq.NewCalendarPublisher(amqpConn, "calendar", logger)
+	// reconnects in the background if the broker goes away later on
+	amqpConn, err := messaging.DialAMQP(ctx, cfg.AMQP.URL(), logger)
 	if err != nil {
-		logger.Fatal("error creating event created publisher", zap.Error(err))
+		logger.Fatal("error opening rabbitmq connection", zap.Error(err))
 		os.Exit(1)
 	}
-	defer calendarPublisher.Close()
-	logger.Info("created event created publisher")
+	logger.Info("opened rabbitmq connection")
 
-	err = calendarPublisher.Setup()
-	if err != nil {
-		logger.Fatal("error setting up calendar publisher", zap.Error(err))
-		os.Exit(1)
-	}
+	publisher := messaging.NewAMQPPublisher(amqpConn, publisherOpts, logger)
+
+	subscriber := messaging.NewAMQPSubscriber(amqpConn, "calendar", logger)
+
+	calendarPublisher := bus.NewCalendarPublisher(publisher, "calendar", logger)
+
+	// changes are fanned out to clients watching events over grpc and server-sent events
+	changes := watch.NewHub(64)
 
 	eventService := &postgres.EventService{
 		DB:                db,
@@ -82,23 +106,173 @@ func main() {
 		Logger:            logger,
 	}
 
+	changeListener := &postgres.ChangeListener{
+		DB:        db,
+		Changes:   changes,
+		Logger:    logger,
+		Retention: cfg.ChangeRetention,
+	}
+
+	listenerDone := make(chan struct{})
+	go func() {
+		defer close(listenerDone)
+
+		logger.Info("starting change listener")
+		if err := changeListener.Run(ctx); err != nil {
+			logger.Error("error whilst running change listener", zap.Error(err))
+			stop()
+		}
+	}()
+
+	weatherUpdatesConsumer := bus.NewWeatherUpdatesConsumer(subscriber, eventService, logger)
+
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+
+		logger.Info("starting weather updates consumer")
+		err := weatherUpdatesConsumer.StartConsumer(ctx, messaging.Binding{
+			Exchange:    "weather",
+			Queue:       "calendar_weather_updates",
+			RoutingKeys: []string{"weather.updated"},
+			Retry:       messaging.RetryPolicyFromConfig(cfg.Retry),
+		})
+		if err != nil {
+			logger.Error("error whilst running weather updates consumer", zap.Error(err))
+			stop()
+		}
+	}()
+
 	server := &Server{
 		logger:       logger,
 		eventService: eventService,
+		changes:      changes,
+		locations:    locations.NewClient(cfg.WeatherAPIURL),
 	}
 
-	r := gin.Default()
+	srv := &http.Server{
+		Addr:    cfg.HTTP.Addr(),
+		Handler: newRouter(server),
+	}
 
-	r.GET("/event", server.listEvents)
-	r.GET("/event/:eventId", server.findEvent)
-	r.POST("/event", server.createEvent)
+	go func() {
+		logger.Info("starting http server", zap.String("addr", srv.Addr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("error whilst running http server", zap.Error(err))
+			stop()
+		}
+	}()
+
+	grpcServer := calendarGrpc.NewServer(eventService, changes, logger).Register()
+
+	go func() {
+		lis, err := net.Listen("tcp", cfg.GRPC.Addr())
+		if err != nil {
+			logger.Error("error listening for grpc", zap.Error(err))
+			stop()
+			return
+		}
+
+		logger.Info("starting grpc server", zap.String("addr", cfg.GRPC.Addr()))
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Error("error whilst running grpc server", zap.Error(err))
+			stop()
+		}
+	}()
+
+	// wait for termination
+	<-ctx.Done()
+
+	logger.Info("calendar service shutting down", zap.Duration("timeout", cfg.Shutdown.Timeout))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	// change streams never finish on their own, end them so the servers can drain
+	changes.Close()
+
+	// stop accepting new requests and wait for the in-flight ones to finish
+	// before tearing down the connections they depend on
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error shutting down http server", zap.Error(err))
+	}
+
+	stopGrpcServer(shutdownCtx, grpcServer)
 
-	r.Run()
+	// both stop once the context is cancelled, the listener must release its connection before the pool closes
+	<-consumerDone
+	<-listenerDone
+
+	if err := db.Close(shutdownCtx); err != nil {
+		logger.Error("error closing db", zap.Error(err))
+	}
+
+	if err := publisher.Close(); err != nil {
+		logger.Error("error closing publisher", zap.Error(err))
+	}
+
+	if err := amqpConn.Close(); err != nil {
+		logger.Error("error closing rabbitmq connection", zap.Error(err))
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("error flushing traces", zap.Error(err))
+	}
+
+	logger.Info("calendar service stopped")
+}
+
+// stopGrpcServer waits for in-flight rpcs to finish, forcibly closing them once the context is done
+func stopGrpcServer(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// EventService is implemented by postgres.EventService
+type EventService interface {
+	FindInTimeRange(ctx context.Context, startsAt, endsAt time.Time) ([]*model.Event, error)
+	FindEventByID(ctx context.Context, id string) (*model.Event, error)
+	CreateEvent(ctx context.Context, event *model.Event) error
+	UpdateEvent(ctx context.Context, event *model.Event) error
+	DeleteEvent(ctx context.Context, id string) (*model.Event, error)
+	ChangesSince(ctx context.Context, afterID int64, startsAt, endsAt time.Time) ([]*model.EventChange, error)
 }
 
 type Server struct {
 	logger       *zap.Logger
-	eventService *postgres.EventService
+	eventService EventService
+	changes      *watch.Hub
+	locations    *locations.Client
+}
+
+// newRouter serves the http api described by openapi.json
+func newRouter(server *Server) *gin.Engine {
+	r := gin.Default()
+	r.Use(metrics.GinMiddleware())
+	r.Use(otelgin.Middleware("calendar"))
+
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openapi.Spec)
+	})
+	r.GET("/event", server.listEvents)
+	r.GET("/event/:eventId", server.findEvent)
+	r.POST("/event", server.createEvent)
+	r.PUT("/event/:eventId", server.updateEvent)
+	r.DELETE("/event/:eventId", server.deleteEvent)
+	r.GET("/changes", server.watchChanges)
+	r.GET("/location/suggestions", server.suggestLocations)
+
+	return r
 }
 
 type ListEventsInput struct {
@@ -122,7 +296,7 @@ func (s *Server) listEvents(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
 		"events": events,
 	}})
 }
@@ -136,7 +310,7 @@ func (s *Server) findEvent(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
 		"event": event,
 	}})
 }
@@ -146,6 +320,8 @@ type CreateEventInput struct {
 	Location string    `json:"location"`
 	StartsAt time.Time `json:"startsAt" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
 	EndsAt   time.Time `json:"endsAt" binding:"required,gtfield=StartsAt" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Place is the suggestion the user picked for the location, if they picked one
+	Place *model.Place `json:"place"`
 }
 
 func (s *Server) createEvent(c *gin.Context) {
@@ -157,7 +333,8 @@ func (s *Server) createEvent(c *gin.Context) {
 
 	event := &model.Event{
 		Title:    input.Title,
-		Location: input.Location,
+		Location: eventLocation(input.Location, input.Place),
+		Venue:    model.NewVenue(input.Location, input.Place),
 		StartsAt: input.StartsAt,
 		EndsAt:   input.EndsAt,
 	}
@@ -174,10 +351,79 @@ func (s *Server) createEvent(c *gin.Context) {
 	}})
 }
 
+type UpdateEventInput struct {
+	Title    string    `json:"title" binding:"required,min=2"`
+	Location string    `json:"location"`
+	StartsAt time.Time `json:"startsAt" binding:"required" time_format:"2006-01-02T15:04:05Z07:00"`
+	EndsAt   time.Time `json:"endsAt" binding:"required,gtfield=StartsAt" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Place is the suggestion the user picked for the location, if they picked one
+	Place *model.Place `json:"place"`
+}
+
+func (s *Server) updateEvent(c *gin.Context) {
+	var input UpdateEventInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event := &model.Event{
+		ID:       c.Param("eventId"),
+		Title:    input.Title,
+		Location: eventLocation(input.Location, input.Place),
+		Venue:    model.NewVenue(input.Location, input.Place),
+		StartsAt: input.StartsAt,
+		EndsAt:   input.EndsAt,
+	}
+
+	err := s.eventService.UpdateEvent(c.Request.Context(), event)
+	if err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"event": event,
+	}})
+}
+
+func (s *Server) deleteEvent(c *gin.Context) {
+	eventId := c.Param("eventId")
+
+	if _, err := s.eventService.DeleteEvent(c.Request.Context(), eventId); err != nil {
+		ErrorResponse(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// eventLocation names the place when the user picked one without typing a location of their own
+func eventLocation(location string, place *model.Place) string {
+	if location == "" && place != nil {
+		return place.Name
+	}
+
+	return location
+}
+
 func ErrorResponse(c *gin.Context, err error) {
 	// Log this error
 	fmt.Printf("error response: %v\n", err)
 
-	// Provide a better way for the application to return a appropriate statis code and message
-	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var validationErrs validator.ValidationErrors
+
+	switch {
+	case errors.Is(err, postgres.ErrEventNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+
+	case errors.As(err, &validationErrs):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+	case errors.Is(err, locations.ErrUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
 }
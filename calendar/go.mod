@@ -3,22 +3,27 @@ module github.com/alexdunne/not-so-smart-cal/calendar
 go 1.16
 
 require (
-	github.com/gin-gonic/gin v1.7.1
+	github.com/alexdunne/not-so-smart-cal/pkg v0.0.0
+	github.com/deepmap/oapi-codegen v1.8.2
+	github.com/getkin/kin-openapi v0.61.0
+	github.com/gin-gonic/gin v1.7.4
 	github.com/go-playground/validator/v10 v10.6.0
-	github.com/go-redis/redis v6.15.6+incompatible // indirect
-	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/google/uuid v1.2.0 // indirect
+	github.com/jackc/pgconn v1.8.1
 	github.com/jackc/pgproto3/v2 v2.0.7 // indirect
 	github.com/jackc/pgx/v4 v4.11.0
-	github.com/json-iterator/go v1.1.11 // indirect
 	github.com/leodido/go-urn v1.2.1 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
-	github.com/streadway/amqp v1.0.0 // indirect
-	github.com/thoas/bokchoy v0.2.1 // indirect
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
 	github.com/ugorji/go v1.2.5 // indirect
-	go.uber.org/zap v1.16.0 // indirect
+	go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin v0.24.0
+	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.24.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	go.uber.org/zap v1.16.0
 	golang.org/x/crypto v0.0.0-20210506145944-38f3c27a63bf // indirect
-	golang.org/x/sys v0.0.0-20210510120138-977fb7262007 // indirect
 	golang.org/x/text v0.3.6 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
+
+replace github.com/alexdunne/not-so-smart-cal/pkg => ../pkg
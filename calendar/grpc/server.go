@@ -0,0 +1,349 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/calendarpb"
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/calendar/postgres"
+	"github.com/alexdunne/not-so-smart-cal/calendar/watch"
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type EventService interface {
+	FindInTimeRange(ctx context.Context, startsAt, endsAt time.Time) ([]*model.Event, error)
+	FindEventByID(ctx context.Context, id string) (*model.Event, error)
+	CreateEvent(ctx context.Context, event *model.Event) error
+	UpdateEvent(ctx context.Context, event *model.Event) error
+	DeleteEvent(ctx context.Context, id string) (*model.Event, error)
+	ChangesSince(ctx context.Context, afterID int64, startsAt, endsAt time.Time) ([]*model.EventChange, error)
+}
+
+// Server implements the CalendarService gRPC API on top of the same EventService as the http api
+type Server struct {
+	calendarpb.UnimplementedCalendarServiceServer
+
+	eventService EventService
+	changes      *watch.Hub
+	logger       *zap.Logger
+}
+
+func NewServer(eventService EventService, changes *watch.Hub, logger *zap.Logger) *Server {
+	return &Server{
+		eventService: eventService,
+		changes:      changes,
+		logger:       logger,
+	}
+}
+
+// Register creates a grpc server with tracing, health checks and reflection, and the calendar service registered on it
+func (s *Server) Register(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(
+		opts,
+		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+	)
+
+	srv := grpc.NewServer(opts...)
+
+	calendarpb.RegisterCalendarServiceServer(srv, s)
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	reflection.Register(srv)
+
+	return srv
+}
+
+func (s *Server) ListEvents(ctx context.Context, req *calendarpb.ListEventsRequest) (*calendarpb.ListEventsResponse, error) {
+	events, err := s.eventService.FindInTimeRange(ctx, asTime(req.StartsAt), asTime(req.EndsAt))
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &calendarpb.ListEventsResponse{
+		Events: make([]*calendarpb.Event, 0, len(events)),
+	}
+	for _, event := range events {
+		resp.Events = append(resp.Events, toProto(event))
+	}
+
+	return resp, nil
+}
+
+func (s *Server) GetEvent(ctx context.Context, req *calendarpb.GetEventRequest) (*calendarpb.GetEventResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	event, err := s.eventService.FindEventByID(ctx, req.Id)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	return &calendarpb.GetEventResponse{Event: toProto(event)}, nil
+}
+
+func (s *Server) CreateEvent(ctx context.Context, req *calendarpb.CreateEventRequest) (*calendarpb.CreateEventResponse, error) {
+	// mirror the binding rules of the http api's CreateEventInput
+	switch {
+	case len(req.Title) < 2:
+		return nil, status.Error(codes.InvalidArgument, "title must be at least 2 characters")
+	case req.StartsAt == nil:
+		return nil, status.Error(codes.InvalidArgument, "starts_at is required")
+	case req.EndsAt == nil:
+		return nil, status.Error(codes.InvalidArgument, "ends_at is required")
+	case !req.EndsAt.AsTime().After(req.StartsAt.AsTime()):
+		return nil, status.Error(codes.InvalidArgument, "ends_at must be after starts_at")
+	}
+
+	event := &model.Event{
+		Title:    req.Title,
+		Location: req.Location,
+		StartsAt: req.StartsAt.AsTime(),
+		EndsAt:   req.EndsAt.AsTime(),
+		Venue:    model.NewVenue(req.Location, placeFromProto(req.Place)),
+	}
+
+	if err := s.eventService.CreateEvent(ctx, event); err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	return &calendarpb.CreateEventResponse{Event: toProto(event)}, nil
+}
+
+func (s *Server) UpdateEvent(ctx context.Context, req *calendarpb.UpdateEventRequest) (*calendarpb.UpdateEventResponse, error) {
+	switch {
+	case req.Id == "":
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	case len(req.Title) < 2:
+		return nil, status.Error(codes.InvalidArgument, "title must be at least 2 characters")
+	case req.StartsAt == nil:
+		return nil, status.Error(codes.InvalidArgument, "starts_at is required")
+	case req.EndsAt == nil:
+		return nil, status.Error(codes.InvalidArgument, "ends_at is required")
+	case !req.EndsAt.AsTime().After(req.StartsAt.AsTime()):
+		return nil, status.Error(codes.InvalidArgument, "ends_at must be after starts_at")
+	}
+
+	event := &model.Event{
+		ID:       req.Id,
+		Title:    req.Title,
+		Location: req.Location,
+		StartsAt: req.StartsAt.AsTime(),
+		EndsAt:   req.EndsAt.AsTime(),
+		Venue:    model.NewVenue(req.Location, placeFromProto(req.Place)),
+	}
+
+	if err := s.eventService.UpdateEvent(ctx, event); err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	return &calendarpb.UpdateEventResponse{Event: toProto(event)}, nil
+}
+
+func (s *Server) DeleteEvent(ctx context.Context, req *calendarpb.DeleteEventRequest) (*calendarpb.DeleteEventResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	event, err := s.eventService.DeleteEvent(ctx, req.Id)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	return &calendarpb.DeleteEventResponse{Event: toProto(event)}, nil
+}
+
+func (s *Server) WatchEvents(req *calendarpb.WatchEventsRequest, stream calendarpb.CalendarService_WatchEventsServer) error {
+	// subscribe before replaying so nothing recorded in between is missed, duplicates are skipped by id
+	sub := s.changes.Subscribe()
+	defer sub.Close()
+
+	startsAt, endsAt := asTime(req.StartsAt), asTime(req.EndsAt)
+	lastID := req.AfterId
+
+	s.logger.Info(
+		"client watching events",
+		zap.Time("startsAt", startsAt),
+		zap.Time("endsAt", endsAt),
+		zap.Int64("afterId", lastID),
+	)
+
+	for lastID > 0 {
+		changes, err := s.eventService.ChangesSince(stream.Context(), lastID, startsAt, endsAt)
+		if err != nil {
+			return s.toStatus(err)
+		}
+
+		if len(changes) == 0 {
+			break
+		}
+
+		for _, change := range changes {
+			if err := s.sendChange(stream, change); err != nil {
+				return err
+			}
+			lastID = change.ID
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case change, ok := <-sub.C:
+			if !ok {
+				return s.toStatus(sub.Err())
+			}
+
+			if change.ID <= lastID || !change.Event.Overlaps(startsAt, endsAt) {
+				continue
+			}
+
+			if err := s.sendChange(stream, &change); err != nil {
+				return err
+			}
+			lastID = change.ID
+		}
+	}
+}
+
+func (s *Server) sendChange(stream calendarpb.CalendarService_WatchEventsServer, change *model.EventChange) error {
+	msg := &calendarpb.EventChange{
+		Id:        change.ID,
+		Type:      changeTypeToProto(change.Type),
+		Event:     toProto(change.Event),
+		CreatedAt: timestamppb.New(change.CreatedAt),
+	}
+
+	if len(change.Weather) > 0 {
+		var weather map[string]interface{}
+		if err := json.Unmarshal(change.Weather, &weather); err != nil {
+			return s.toStatus(err)
+		}
+
+		var err error
+		if msg.Weather, err = structpb.NewStruct(weather); err != nil {
+			return s.toStatus(err)
+		}
+	}
+
+	return stream.Send(msg)
+}
+
+func (s *Server) toStatus(err error) error {
+	var validationErrs validator.ValidationErrors
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, postgres.ErrEventNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &validationErrs):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, postgres.ErrChangesExpired):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, watch.ErrLagged):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, watch.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	s.logger.Error("error handling grpc request", zap.Error(err))
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func asTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return ts.AsTime()
+}
+
+func toProto(event *model.Event) *calendarpb.Event {
+	return &calendarpb.Event{
+		Id:        event.ID,
+		Title:     event.Title,
+		Location:  event.Location,
+		StartsAt:  timestamppb.New(event.StartsAt),
+		EndsAt:    timestamppb.New(event.EndsAt),
+		CreatedAt: timestamppb.New(event.CreatedAt),
+		Venue:     venueToProto(event.Venue),
+	}
+}
+
+func venueToProto(venue *model.Venue) *calendarpb.Venue {
+	if venue == nil {
+		return nil
+	}
+
+	return &calendarpb.Venue{
+		Kind:     string(venue.Kind),
+		Address:  venue.Address,
+		Place:    placeToProto(venue.Place),
+		Url:      venue.URL,
+		Provider: venue.Provider,
+	}
+}
+
+func placeToProto(place *model.Place) *calendarpb.Place {
+	if place == nil {
+		return nil
+	}
+
+	return &calendarpb.Place{
+		Name:      place.Name,
+		Latitude:  place.Latitude,
+		Longitude: place.Longitude,
+		Country:   place.Country,
+		State:     place.State,
+	}
+}
+
+func placeFromProto(place *calendarpb.Place) *model.Place {
+	if place == nil {
+		return nil
+	}
+
+	return &model.Place{
+		Name:      place.Name,
+		Latitude:  place.Latitude,
+		Longitude: place.Longitude,
+		Country:   place.Country,
+		State:     place.State,
+	}
+}
+
+func changeTypeToProto(changeType model.EventChangeType) calendarpb.EventChange_Type {
+	switch changeType {
+	case model.EventCreated:
+		return calendarpb.EventChange_TYPE_CREATED
+	case model.EventUpdated:
+		return calendarpb.EventChange_TYPE_UPDATED
+	case model.EventDeleted:
+		return calendarpb.EventChange_TYPE_DELETED
+	case model.WeatherUpdated:
+		return calendarpb.EventChange_TYPE_WEATHER_UPDATED
+	}
+
+	return calendarpb.EventChange_TYPE_UNSPECIFIED
+}
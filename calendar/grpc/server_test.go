@@ -0,0 +1,512 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/calendarpb"
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/calendar/postgres"
+	"github.com/alexdunne/not-so-smart-cal/calendar/watch"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeEventService keeps events in memory and validates them the way the postgres store does
+type fakeEventService struct {
+	mu        sync.Mutex
+	validator *validator.Validate
+	events    map[string]*model.Event
+	changes   []*model.EventChange
+	nextID    int
+}
+
+func newFakeEventService() *fakeEventService {
+	return &fakeEventService{
+		validator: validator.New(),
+		events:    make(map[string]*model.Event),
+	}
+}
+
+func (f *fakeEventService) FindInTimeRange(ctx context.Context, startsAt, endsAt time.Time) ([]*model.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := []*model.Event{}
+	for _, event := range f.events {
+		if event.Overlaps(startsAt, endsAt) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].StartsAt.Before(events[j].StartsAt) })
+
+	return events, nil
+}
+
+func (f *fakeEventService) FindEventByID(ctx context.Context, id string) (*model.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event, ok := f.events[id]
+	if !ok {
+		return nil, postgres.ErrEventNotFound
+	}
+
+	return event, nil
+}
+
+func (f *fakeEventService) CreateEvent(ctx context.Context, event *model.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	event.ID = fmt.Sprintf("event-%d", f.nextID)
+	event.CreatedAt = time.Date(2021, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	if err := f.validator.Struct(event); err != nil {
+		return err
+	}
+
+	f.events[event.ID] = event
+
+	return nil
+}
+
+func (f *fakeEventService) UpdateEvent(ctx context.Context, event *model.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, ok := f.events[event.ID]
+	if !ok {
+		return postgres.ErrEventNotFound
+	}
+	event.CreatedAt = existing.CreatedAt
+
+	if err := f.validator.Struct(event); err != nil {
+		return err
+	}
+
+	f.events[event.ID] = event
+
+	return nil
+}
+
+func (f *fakeEventService) DeleteEvent(ctx context.Context, id string) (*model.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event, ok := f.events[id]
+	if !ok {
+		return nil, postgres.ErrEventNotFound
+	}
+	delete(f.events, id)
+
+	return event, nil
+}
+
+func (f *fakeEventService) ChangesSince(ctx context.Context, afterID int64, startsAt, endsAt time.Time) ([]*model.EventChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.changes) > 0 && afterID < f.changes[0].ID-1 {
+		return nil, postgres.ErrChangesExpired
+	}
+
+	// return a page at a time so the server has to keep asking
+	changes := []*model.EventChange{}
+	for _, change := range f.changes {
+		if change.ID > afterID && change.Event.Overlaps(startsAt, endsAt) && len(changes) < 2 {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+func newTestClient(t *testing.T, eventService EventService, hub *watch.Hub) calendarpb.CalendarServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := NewServer(eventService, hub, zap.NewNop()).Register()
+
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("dialing bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return calendarpb.NewCalendarServiceClient(conn)
+}
+
+func requireCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+
+	if got := status.Code(err); got != want {
+		t.Fatalf("expected status %s, got %s (%v)", want, got, err)
+	}
+}
+
+var (
+	morning   = time.Date(2021, 6, 14, 9, 0, 0, 0, time.UTC)
+	afternoon = time.Date(2021, 6, 14, 14, 0, 0, 0, time.UTC)
+)
+
+func TestCreateAndGetEvent(t *testing.T) {
+	client := newTestClient(t, newFakeEventService(), watch.NewHub(8))
+	ctx := context.Background()
+
+	created, err := client.CreateEvent(ctx, &calendarpb.CreateEventRequest{
+		Title:    "Picnic",
+		Location: "Hyde Park",
+		StartsAt: timestamppb.New(morning),
+		EndsAt:   timestamppb.New(afternoon),
+		Place:    &calendarpb.Place{Name: "Hyde Park", Latitude: 51.5073, Longitude: -0.1657, Country: "GB", State: "England"},
+	})
+	if err != nil {
+		t.Fatalf("creating event: %v", err)
+	}
+
+	if created.Event.Id == "" {
+		t.Fatal("expected the created event to have an id")
+	}
+	if got := created.Event.Venue; got.GetKind() != string(model.VenuePhysical) || got.GetAddress() != "Hyde Park" || got.GetPlace().GetLatitude() != 51.5073 {
+		t.Fatalf("unexpected venue %v", got)
+	}
+
+	got, err := client.GetEvent(ctx, &calendarpb.GetEventRequest{Id: created.Event.Id})
+	if err != nil {
+		t.Fatalf("getting event: %v", err)
+	}
+
+	if got.Event.Title != "Picnic" || !got.Event.StartsAt.AsTime().Equal(morning) || !got.Event.EndsAt.AsTime().Equal(afternoon) {
+		t.Fatalf("unexpected event %v", got.Event)
+	}
+	if got.Event.Venue.GetPlace().GetCountry() != "GB" {
+		t.Fatalf("expected the place to be returned, got %v", got.Event.Venue)
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	service := newFakeEventService()
+	client := newTestClient(t, service, watch.NewHub(8))
+	ctx := context.Background()
+
+	for i, title := range []string{"Breakfast", "Lunch", "Dinner"} {
+		startsAt := morning.Add(time.Duration(i*5) * time.Hour)
+		_, err := client.CreateEvent(ctx, &calendarpb.CreateEventRequest{
+			Title:    title,
+			StartsAt: timestamppb.New(startsAt),
+			EndsAt:   timestamppb.New(startsAt.Add(time.Hour)),
+		})
+		if err != nil {
+			t.Fatalf("creating %s: %v", title, err)
+		}
+	}
+
+	resp, err := client.ListEvents(ctx, &calendarpb.ListEventsRequest{
+		StartsAt: timestamppb.New(morning.Add(2 * time.Hour)),
+		EndsAt:   timestamppb.New(morning.Add(12 * time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+
+	if len(resp.Events) != 2 || resp.Events[0].Title != "Lunch" || resp.Events[1].Title != "Dinner" {
+		t.Fatalf("expected lunch and dinner, got %v", resp.Events)
+	}
+	if resp.Events[0].Venue != nil {
+		t.Fatalf("expected no venue for an event without a location, got %v", resp.Events[0].Venue)
+	}
+}
+
+func TestUpdateEvent(t *testing.T) {
+	service := newFakeEventService()
+	client := newTestClient(t, service, watch.NewHub(8))
+	ctx := context.Background()
+
+	created, err := client.CreateEvent(ctx, &calendarpb.CreateEventRequest{
+		Title:    "Picnic",
+		Location: "Hyde Park",
+		StartsAt: timestamppb.New(morning),
+		EndsAt:   timestamppb.New(afternoon),
+		Place:    &calendarpb.Place{Name: "Hyde Park", Latitude: 51.5073, Longitude: -0.1657, Country: "GB"},
+	})
+	if err != nil {
+		t.Fatalf("creating event: %v", err)
+	}
+
+	t.Run("keeps the place sent with the update", func(t *testing.T) {
+		resp, err := client.UpdateEvent(ctx, &calendarpb.UpdateEventRequest{
+			Id:       created.Event.Id,
+			Title:    "Long picnic",
+			Location: "Hyde Park",
+			StartsAt: timestamppb.New(morning),
+			EndsAt:   timestamppb.New(afternoon.Add(2 * time.Hour)),
+			Place:    created.Event.Venue.Place,
+		})
+		if err != nil {
+			t.Fatalf("updating event: %v", err)
+		}
+
+		if resp.Event.Title != "Long picnic" || resp.Event.Venue.GetPlace().GetLatitude() != 51.5073 {
+			t.Fatalf("unexpected event %v", resp.Event)
+		}
+
+		stored, _ := service.FindEventByID(ctx, created.Event.Id)
+		if stored.Venue == nil || stored.Venue.Place == nil || stored.Venue.Place.Name != "Hyde Park" {
+			t.Fatalf("expected the stored event to keep its place, got %+v", stored.Venue)
+		}
+	})
+
+	t.Run("recognises a virtual venue", func(t *testing.T) {
+		resp, err := client.UpdateEvent(ctx, &calendarpb.UpdateEventRequest{
+			Id:       created.Event.Id,
+			Title:    "Picnic call",
+			Location: "https://zoom.us/j/123456789",
+			StartsAt: timestamppb.New(morning),
+			EndsAt:   timestamppb.New(afternoon),
+		})
+		if err != nil {
+			t.Fatalf("updating event: %v", err)
+		}
+
+		if resp.Event.Venue.GetKind() != string(model.VenueVirtual) || resp.Event.Venue.GetProvider() != "zoom" {
+			t.Fatalf("expected a zoom venue, got %v", resp.Event.Venue)
+		}
+	})
+
+	t.Run("unknown event", func(t *testing.T) {
+		_, err := client.UpdateEvent(ctx, &calendarpb.UpdateEventRequest{
+			Id:       "missing",
+			Title:    "Picnic",
+			StartsAt: timestamppb.New(morning),
+			EndsAt:   timestamppb.New(afternoon),
+		})
+		requireCode(t, err, codes.NotFound)
+	})
+}
+
+func TestDeleteEvent(t *testing.T) {
+	client := newTestClient(t, newFakeEventService(), watch.NewHub(8))
+	ctx := context.Background()
+
+	created, err := client.CreateEvent(ctx, &calendarpb.CreateEventRequest{
+		Title:    "Picnic",
+		StartsAt: timestamppb.New(morning),
+		EndsAt:   timestamppb.New(afternoon),
+	})
+	if err != nil {
+		t.Fatalf("creating event: %v", err)
+	}
+
+	deleted, err := client.DeleteEvent(ctx, &calendarpb.DeleteEventRequest{Id: created.Event.Id})
+	if err != nil {
+		t.Fatalf("deleting event: %v", err)
+	}
+	if deleted.Event.Title != "Picnic" {
+		t.Fatalf("expected the deleted event to be returned, got %v", deleted.Event)
+	}
+
+	_, err = client.GetEvent(ctx, &calendarpb.GetEventRequest{Id: created.Event.Id})
+	requireCode(t, err, codes.NotFound)
+
+	_, err = client.DeleteEvent(ctx, &calendarpb.DeleteEventRequest{Id: created.Event.Id})
+	requireCode(t, err, codes.NotFound)
+}
+
+func TestInvalidRequests(t *testing.T) {
+	client := newTestClient(t, newFakeEventService(), watch.NewHub(8))
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{
+			name: "get without an id",
+			call: func() error {
+				_, err := client.GetEvent(ctx, &calendarpb.GetEventRequest{})
+				return err
+			},
+		},
+		{
+			name: "create with a short title",
+			call: func() error {
+				_, err := client.CreateEvent(ctx, &calendarpb.CreateEventRequest{
+					Title:    "P",
+					StartsAt: timestamppb.New(morning),
+					EndsAt:   timestamppb.New(afternoon),
+				})
+				return err
+			},
+		},
+		{
+			name: "create ending before it starts",
+			call: func() error {
+				_, err := client.CreateEvent(ctx, &calendarpb.CreateEventRequest{
+					Title:    "Picnic",
+					StartsAt: timestamppb.New(afternoon),
+					EndsAt:   timestamppb.New(morning),
+				})
+				return err
+			},
+		},
+		{
+			// rejected by the event service's validator rather than the handler, mapped by toStatus
+			name: "create with a place outside the world",
+			call: func() error {
+				_, err := client.CreateEvent(ctx, &calendarpb.CreateEventRequest{
+					Title:    "Picnic",
+					StartsAt: timestamppb.New(morning),
+					EndsAt:   timestamppb.New(afternoon),
+					Place:    &calendarpb.Place{Name: "Nowhere", Latitude: 120, Country: "GB"},
+				})
+				return err
+			},
+		},
+		{
+			name: "update without an id",
+			call: func() error {
+				_, err := client.UpdateEvent(ctx, &calendarpb.UpdateEventRequest{
+					Title:    "Picnic",
+					StartsAt: timestamppb.New(morning),
+					EndsAt:   timestamppb.New(afternoon),
+				})
+				return err
+			},
+		},
+		{
+			name: "update without a start",
+			call: func() error {
+				_, err := client.UpdateEvent(ctx, &calendarpb.UpdateEventRequest{
+					Id:     "event-1",
+					Title:  "Picnic",
+					EndsAt: timestamppb.New(afternoon),
+				})
+				return err
+			},
+		},
+		{
+			name: "delete without an id",
+			call: func() error {
+				_, err := client.DeleteEvent(ctx, &calendarpb.DeleteEventRequest{})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireCode(t, tt.call(), codes.InvalidArgument)
+		})
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	service := newFakeEventService()
+	hub := watch.NewHub(8)
+	client := newTestClient(t, service, hub)
+
+	event := func(title string, startsAt time.Time) *model.Event {
+		return &model.Event{ID: title, Title: title, StartsAt: startsAt, EndsAt: startsAt.Add(time.Hour)}
+	}
+	change := func(id int64, changeType model.EventChangeType, event *model.Event) *model.EventChange {
+		return &model.EventChange{ID: id, Type: changeType, Event: event, CreatedAt: morning}
+	}
+
+	outside := morning.AddDate(0, 1, 0)
+	service.changes = []*model.EventChange{
+		change(1, model.EventCreated, event("Breakfast", morning)),
+		change(2, model.EventCreated, event("Lunch", morning.Add(3*time.Hour))),
+		change(3, model.EventCreated, event("Holiday", outside)),
+		change(4, model.EventUpdated, event("Lunch", morning.Add(4*time.Hour))),
+		change(5, model.EventDeleted, event("Breakfast", morning)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchEvents(ctx, &calendarpb.WatchEventsRequest{
+		StartsAt: timestamppb.New(morning),
+		EndsAt:   timestamppb.New(afternoon.Add(6 * time.Hour)),
+		AfterId:  1,
+	})
+	if err != nil {
+		t.Fatalf("watching events: %v", err)
+	}
+
+	expect := func(id int64, changeType calendarpb.EventChange_Type, title string) *calendarpb.EventChange {
+		t.Helper()
+
+		got, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("receiving change %d: %v", id, err)
+		}
+		if got.Id != id || got.Type != changeType || got.Event.Title != title {
+			t.Fatalf("expected change %d %s of %s, got %d %s of %s", id, changeType, title, got.Id, got.Type, got.Event.Title)
+		}
+
+		return got
+	}
+
+	// the missed changes are replayed, skipping the one outside the window
+	expect(2, calendarpb.EventChange_TYPE_CREATED, "Lunch")
+	expect(4, calendarpb.EventChange_TYPE_UPDATED, "Lunch")
+	expect(5, calendarpb.EventChange_TYPE_DELETED, "Breakfast")
+
+	// already replayed, outside the window, then new
+	weather, _ := json.Marshal(map[string]interface{}{"summary": "sunny", "temperature": 21.5})
+	hub.Publish(*change(5, model.EventDeleted, event("Breakfast", morning)))
+	hub.Publish(*change(6, model.EventCreated, event("Holiday", outside)))
+	hub.Publish(model.EventChange{ID: 7, Type: model.WeatherUpdated, Event: event("Lunch", morning.Add(4*time.Hour)), Weather: weather, CreatedAt: morning})
+
+	got := expect(7, calendarpb.EventChange_TYPE_WEATHER_UPDATED, "Lunch")
+	if got.Weather.AsMap()["summary"] != "sunny" || got.Weather.AsMap()["temperature"] != 21.5 {
+		t.Fatalf("unexpected weather %v", got.Weather.AsMap())
+	}
+
+	hub.Close()
+
+	_, err = stream.Recv()
+	requireCode(t, err, codes.Unavailable)
+}
+
+func TestWatchEventsExpired(t *testing.T) {
+	service := newFakeEventService()
+	service.changes = []*model.EventChange{
+		{ID: 10, Type: model.EventCreated, Event: &model.Event{Title: "Lunch", StartsAt: morning, EndsAt: afternoon}},
+	}
+	client := newTestClient(t, service, watch.NewHub(8))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchEvents(ctx, &calendarpb.WatchEventsRequest{AfterId: 3})
+	if err != nil {
+		t.Fatalf("watching events: %v", err)
+	}
+
+	_, err = stream.Recv()
+	requireCode(t, err, codes.OutOfRange)
+}
@@ -0,0 +1,79 @@
+// Package locations suggests places for events using the weather service's geocoder
+package locations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ErrUnavailable is returned when the weather service can't search for places
+var ErrUnavailable = errors.New("location suggestions are unavailable")
+
+// MinQueryLength is the shortest search the weather service accepts
+const MinQueryLength = 3
+
+// requestTimeout is kept short as someone is waiting on the suggestions whilst they type
+const requestTimeout = 3 * time.Second
+
+// Client searches for places through the weather api
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: requestTimeout},
+		baseURL:    baseURL,
+	}
+}
+
+type searchResponse struct {
+	Data struct {
+		Locations []*model.Place `json:"locations"`
+	} `json:"data"`
+}
+
+// Suggest returns up to limit places matching the query, best match first
+func (c *Client) Suggest(ctx context.Context, query string, limit int) ([]*model.Place, error) {
+	params := url.Values{"q": {query}}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/locations?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// continue the trace in the weather api
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: weather api responded with %d", ErrUnavailable, resp.StatusCode)
+	}
+
+	var response searchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("%w: error decoding response: %v", ErrUnavailable, err)
+	}
+
+	return response.Data.Locations, nil
+}
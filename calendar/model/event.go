@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Event struct {
 	ID        string    `json:"id"`
@@ -9,4 +12,49 @@ type Event struct {
 	StartsAt  time.Time `json:"startsAt" validate:"required"`
 	EndsAt    time.Time `json:"endsAt" validate:"required"`
 	CreatedAt time.Time `json:"createdAt" validate:"required"`
+	// Venue is the location in a structured form, it's nil when the event has no location
+	Venue *Venue `json:"venue,omitempty"`
+}
+
+// Place is a geocoded location, its coordinates are used rather than guessing them from the event's location
+type Place struct {
+	Name      string  `json:"name" validate:"required"`
+	Latitude  float64 `json:"lat" validate:"min=-90,max=90"`
+	Longitude float64 `json:"lon" validate:"min=-180,max=180"`
+	Country   string  `json:"country" validate:"required"`
+	State     string  `json:"state,omitempty"`
+}
+
+// Overlaps matches the rule used when finding events in a time range, zero bounds are treated as open
+func (e *Event) Overlaps(startsAt, endsAt time.Time) bool {
+	if !startsAt.IsZero() && e.EndsAt.Before(startsAt) {
+		return false
+	}
+
+	if !endsAt.IsZero() && e.StartsAt.After(endsAt) {
+		return false
+	}
+
+	return true
+}
+
+// EventChangeType describes what happened to an event, the values match the routing keys used when publishing
+type EventChangeType string
+
+const (
+	EventCreated   EventChangeType = "event.created"
+	EventUpdated   EventChangeType = "event.updated"
+	EventDeleted   EventChangeType = "event.deleted"
+	WeatherUpdated EventChangeType = "weather.updated"
+)
+
+// EventChange is an entry in the change log, the ID increases with every change so clients can resume from it
+type EventChange struct {
+	ID   int64           `json:"id"`
+	Type EventChangeType `json:"type"`
+	// Event is the event after the change, or as it was before being deleted
+	Event *Event `json:"event"`
+	// Weather is the forecast as published by the weather service, only set for weather.updated changes
+	Weather   json.RawMessage `json:"weather,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
 }
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/meeting"
+)
+
+type VenueKind string
+
+const (
+	VenuePhysical VenueKind = "physical"
+	VenueVirtual  VenueKind = "virtual"
+)
+
+// Venue is the structured form of an event's location, either somewhere physical or a virtual meeting
+type Venue struct {
+	Kind VenueKind `json:"kind"`
+	// Address is a physical location as it was typed, Place its coordinates when the user picked one of the suggestions
+	Address string `json:"address,omitempty"`
+	Place   *Place `json:"place,omitempty"`
+	// URL and Provider describe a virtual meeting, the URL is empty when the location only names the provider
+	URL      string `json:"url,omitempty"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// NewVenue works out the venue from the location the user typed and the place they picked, it's nil when there's neither.
+// A picked place is always physical, otherwise links and the names of meeting providers are virtual
+func NewVenue(location string, place *Place) *Venue {
+	location = strings.TrimSpace(location)
+
+	if place != nil {
+		if location == "" {
+			location = place.Name
+		}
+		return &Venue{Kind: VenuePhysical, Address: location, Place: place}
+	}
+
+	if location == "" {
+		return nil
+	}
+
+	if m, ok := meeting.Detect(location); ok {
+		return &Venue{Kind: VenueVirtual, URL: m.URL, Provider: m.Provider}
+	}
+
+	return &Venue{Kind: VenuePhysical, Address: location}
+}
@@ -0,0 +1,73 @@
+package model
+
+import "testing"
+
+func TestNewVenue(t *testing.T) {
+	hydePark := &Place{Name: "Hyde Park", Latitude: 51.5073, Longitude: -0.1657, Country: "GB"}
+
+	tests := []struct {
+		name     string
+		location string
+		place    *Place
+		want     *Venue
+	}{
+		{
+			name: "nothing",
+		},
+		{
+			name:     "blank location",
+			location: "   ",
+		},
+		{
+			name:     "address",
+			location: " Hyde Park ",
+			want:     &Venue{Kind: VenuePhysical, Address: "Hyde Park"},
+		},
+		{
+			name:     "address with a ticket link",
+			location: "Hyde Park, London - tickets https://eventbrite.com/e/123",
+			want:     &Venue{Kind: VenuePhysical, Address: "Hyde Park, London - tickets https://eventbrite.com/e/123"},
+		},
+		{
+			name:     "meeting link",
+			location: "https://zoom.us/j/123456789",
+			want:     &Venue{Kind: VenueVirtual, URL: "https://zoom.us/j/123456789", Provider: "zoom"},
+		},
+		{
+			name:     "meeting name",
+			location: "Video call",
+			want:     &Venue{Kind: VenueVirtual, Provider: "other"},
+		},
+		{
+			name:  "place without a location",
+			place: hydePark,
+			want:  &Venue{Kind: VenuePhysical, Address: "Hyde Park", Place: hydePark},
+		},
+		{
+			name:     "place named differently",
+			location: "Picnic spot by the Serpentine",
+			place:    hydePark,
+			want:     &Venue{Kind: VenuePhysical, Address: "Picnic spot by the Serpentine", Place: hydePark},
+		},
+		{
+			// the user picked somewhere, so it's physical however the location reads
+			name:     "place with a virtual location",
+			location: "Zoom",
+			place:    hydePark,
+			want:     &Venue{Kind: VenuePhysical, Address: "Zoom", Place: hydePark},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewVenue(tt.location, tt.place)
+
+			switch {
+			case tt.want == nil && got != nil:
+				t.Fatalf("expected no venue, got %+v", got)
+			case tt.want != nil && (got == nil || *got != *tt.want):
+				t.Fatalf("expected venue %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package openapi
+
+import _ "embed"
+
+// Spec is the OpenAPI 3 document describing the calendar http api
+//
+//go:embed openapi.json
+var Spec []byte
@@ -0,0 +1,293 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/calendar/watch"
+	"go.uber.org/zap"
+)
+
+// ErrChangesExpired is returned when resuming from a change that has already been pruned from the change log
+var ErrChangesExpired = errors.New("changes have expired from the change log")
+
+// changesChannel is the postgres notification channel used to signal that a change has been recorded
+const changesChannel = "event_changes"
+
+// changesLock is the advisory lock key held whilst recording a change.
+// Holding it until commit means change ids become visible in order, so tailing by id never skips a change
+const changesLock = 7413826
+
+// changesPageSize limits how many changes are read from the change log at once
+const changesPageSize = 500
+
+// maxTime is used in place of an open upper bound when filtering by window
+var maxTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// recordChange appends a change to the change log and notifies listeners once the transaction commits
+func recordChange(ctx context.Context, tx *Tx, changeType model.EventChangeType, event *model.Event, weather json.RawMessage) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, changesLock); err != nil {
+		return fmt.Errorf("error locking the change log: %w", err)
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+			INSERT INTO event_changes (type, event_id, starts_at, ends_at, event, weather, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING "id"
+		`,
+		string(changeType),
+		event.ID,
+		event.StartsAt,
+		event.EndsAt,
+		eventJSON,
+		nullableJSON(weather),
+		tx.now,
+	).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("error recording change: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `SELECT pg_notify($1, $2)`, changesChannel, strconv.FormatInt(id, 10)); err != nil {
+		return fmt.Errorf("error notifying change: %w", err)
+	}
+
+	return nil
+}
+
+// ChangesSince returns up to changesPageSize changes recorded after the given id to events overlapping the window,
+// zero bounds are treated as open
+func (s *EventService) ChangesSince(ctx context.Context, afterID int64, startsAt, endsAt time.Time) (_ []*model.EventChange, err error) {
+	defer observeQuery("changes_since", time.Now(), &err)
+
+	ctx, span := startSpan(ctx, "ChangesSince")
+	defer endSpan(span, &err)
+
+	return s.DB.changesSince(ctx, afterID, startsAt, endsAt)
+}
+
+func (db *DB) changesSince(ctx context.Context, afterID int64, startsAt, endsAt time.Time) ([]*model.EventChange, error) {
+	if endsAt.IsZero() {
+		endsAt = maxTime
+	}
+
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if afterID > 0 {
+		// ids have gaps from rolled back inserts, so a missing id doesn't mean it was pruned, only the pruned ids are known
+		var prunedID int64
+		if err := tx.QueryRow(ctx, `SELECT through_id FROM event_changes_pruned`).Scan(&prunedID); err != nil {
+			return nil, err
+		}
+
+		if afterID < prunedID {
+			return nil, ErrChangesExpired
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, type, event, weather, created_at
+		FROM event_changes
+		WHERE id > $1 AND ends_at >= $2 AND starts_at <= $3
+		ORDER BY id
+		LIMIT $4
+	`, afterID, startsAt, endsAt, changesPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]*model.EventChange, 0)
+	for rows.Next() {
+		var (
+			change     model.EventChange
+			changeType string
+			eventJSON  []byte
+			weather    []byte
+		)
+
+		if err := rows.Scan(&change.ID, &changeType, &eventJSON, &weather, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(eventJSON, &change.Event); err != nil {
+			return nil, err
+		}
+
+		change.Type = model.EventChangeType(changeType)
+		change.Weather = weather
+
+		changes = append(changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// latestChangeID returns the id of the most recent change, or 0 when the change log is empty
+func (db *DB) latestChangeID(ctx context.Context) (int64, error) {
+	var id int64
+	err := db.db.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM event_changes`).Scan(&id)
+
+	return id, err
+}
+
+// prunedThrough returns the id of the newest change that has been pruned, clients that have seen it have missed nothing
+func (db *DB) prunedThrough(ctx context.Context) (int64, error) {
+	var id int64
+	err := db.db.QueryRow(ctx, `SELECT through_id FROM event_changes_pruned`).Scan(&id)
+
+	return id, err
+}
+
+// pruneChanges deletes the changes recorded before olderThan, remembering the newest id deleted
+func (db *DB) pruneChanges(ctx context.Context, olderThan time.Time) (int64, error) {
+	var pruned int64
+	err := db.db.QueryRow(ctx, `
+		WITH pruned AS (
+			DELETE FROM event_changes WHERE created_at < $1 RETURNING id
+		)
+		UPDATE event_changes_pruned
+		SET through_id = GREATEST(through_id, (SELECT MAX(id) FROM pruned))
+		RETURNING (SELECT COUNT(*) FROM pruned)
+	`, olderThan).Scan(&pruned)
+
+	return pruned, err
+}
+
+func nullableJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return []byte(data)
+}
+
+// ChangeListener tails the change log and publishes each new change to the hub.
+// Every replica runs one so that clients receive changes made through any replica
+type ChangeListener struct {
+	DB      *DB
+	Changes *watch.Hub
+	Logger  *zap.Logger
+
+	// Retention is how long changes are kept for clients to resume from
+	Retention time.Duration
+}
+
+// Run listens for changes until the context is done, reconnecting whenever the listening connection fails
+func (l *ChangeListener) Run(ctx context.Context) error {
+	lastID, err := l.DB.latestChangeID(ctx)
+	if err != nil {
+		return fmt.Errorf("error finding the latest change: %w", err)
+	}
+
+	go l.prune(ctx)
+
+	for {
+		lastID, err = l.listen(ctx, lastID)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		l.Logger.Error("error listening for changes, reconnecting", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// listen publishes changes after lastID as they're recorded, returning the last published id when it stops
+func (l *ChangeListener) listen(ctx context.Context, lastID int64) (int64, error) {
+	conn, err := l.DB.db.Acquire(ctx)
+	if err != nil {
+		return lastID, err
+	}
+	defer func() {
+		// the connection goes back to the pool so it mustn't stay subscribed
+		conn.Exec(context.Background(), `UNLISTEN *`)
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, `LISTEN `+changesChannel); err != nil {
+		return lastID, err
+	}
+
+	for {
+		// catch up first so changes recorded whilst disconnected aren't missed
+		if lastID, err = l.publishSince(ctx, lastID); err != nil {
+			return lastID, err
+		}
+
+		// the payload only signals that there's something new, the change log is the source of truth
+		if _, err := conn.Conn().WaitForNotification(ctx); err != nil {
+			return lastID, err
+		}
+	}
+}
+
+func (l *ChangeListener) publishSince(ctx context.Context, lastID int64) (int64, error) {
+	for {
+		changes, err := l.DB.changesSince(ctx, lastID, time.Time{}, time.Time{})
+		if errors.Is(err, ErrChangesExpired) {
+			// pruning has overtaken the listener, the pruned changes are gone so carry on from the newest of them
+			prunedID, err := l.DB.prunedThrough(ctx)
+			if err != nil {
+				return lastID, err
+			}
+
+			l.Logger.Warn("changes expired before being published", zap.Int64("lastId", lastID), zap.Int64("prunedThrough", prunedID))
+			lastID = prunedID
+			continue
+		} else if err != nil {
+			return lastID, err
+		}
+
+		for _, change := range changes {
+			l.Changes.Publish(*change)
+			lastID = change.ID
+		}
+
+		if len(changes) < changesPageSize {
+			return lastID, nil
+		}
+	}
+}
+
+func (l *ChangeListener) prune(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		pruned, err := l.DB.pruneChanges(ctx, time.Now().Add(-l.Retention))
+		if err != nil && ctx.Err() == nil {
+			l.Logger.Error("error pruning the change log", zap.Error(err))
+		} else if pruned > 0 {
+			l.Logger.Info("pruned the change log", zap.Int64("count", pruned))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
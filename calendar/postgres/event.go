@@ -2,22 +2,42 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/alexdunne/not-so-smart-cal/calendar/model"
-	"github.com/alexdunne/not-so-smart-cal/calendar/rabbitmq"
 	"github.com/go-playground/validator/v10"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// ErrEventNotFound is returned when an event with the requested id doesn't exist
+var ErrEventNotFound = errors.New("event not found")
+
+// invalidTextRepresentation is the postgres error code returned when an id isn't a valid uuid
+const invalidTextRepresentation = "22P02"
+
+// EventPublisher lets other services know about changes to events
+type EventPublisher interface {
+	PublishEventChange(ctx context.Context, changeType model.EventChangeType, event *model.Event) error
+}
+
 type EventService struct {
 	DB                *DB
-	CalendarPublisher *rabbitmq.CalendarPublisher
+	CalendarPublisher EventPublisher
 	Validator         *validator.Validate
 	Logger            *zap.Logger
 }
 
-func (s *EventService) FindInTimeRange(ctx context.Context, startsAt, endsAt time.Time) ([]*model.Event, error) {
+func (s *EventService) FindInTimeRange(ctx context.Context, startsAt, endsAt time.Time) (_ []*model.Event, err error) {
+	defer observeQuery("find_in_time_range", time.Now(), &err)
+
+	ctx, span := startSpan(ctx, "FindInTimeRange")
+	defer endSpan(span, &err)
+
 	s.Logger.Info(
 		"Finding events in time range",
 		zap.String("startsAt", startsAt.Format(time.RFC3339)),
@@ -31,7 +51,7 @@ func (s *EventService) FindInTimeRange(ctx context.Context, startsAt, endsAt tim
 	defer tx.Rollback(ctx)
 
 	rows, err := tx.Query(ctx, `
-		SELECT id, title, location, starts_at, ends_at, created_at 
+		SELECT id, title, location, starts_at, ends_at, created_at, place
 		FROM events
 		WHERE ends_at >= $1 AND starts_at <= $2
 	`, startsAt.Format(time.RFC3339), endsAt.Format(time.RFC3339))
@@ -43,14 +63,7 @@ func (s *EventService) FindInTimeRange(ctx context.Context, startsAt, endsAt tim
 	events := make([]*model.Event, 0)
 	for rows.Next() {
 		var event model.Event
-		if err := rows.Scan(
-			&event.ID,
-			&event.Title,
-			&event.Location,
-			&event.StartsAt,
-			&event.EndsAt,
-			&event.CreatedAt,
-		); err != nil {
+		if err := scanEvent(rows, &event); err != nil {
 			return nil, err
 		}
 
@@ -63,7 +76,12 @@ func (s *EventService) FindInTimeRange(ctx context.Context, startsAt, endsAt tim
 	return events, nil
 }
 
-func (s *EventService) FindEventByID(ctx context.Context, id string) (*model.Event, error) {
+func (s *EventService) FindEventByID(ctx context.Context, id string) (_ *model.Event, err error) {
+	defer observeQuery("find_event_by_id", time.Now(), &err)
+
+	ctx, span := startSpan(ctx, "FindEventByID")
+	defer endSpan(span, &err)
+
 	tx, err := s.DB.BeginTx(ctx)
 	if err != nil {
 		return nil, err
@@ -72,26 +90,24 @@ func (s *EventService) FindEventByID(ctx context.Context, id string) (*model.Eve
 
 	event := &model.Event{}
 
-	err = tx.QueryRow(ctx, `
-		SELECT id, title, location, starts_at, ends_at, created_at
+	err = scanEvent(tx.QueryRow(ctx, `
+		SELECT id, title, location, starts_at, ends_at, created_at, place
 		FROM events
 		WHERE id = $1
-	`, id).Scan(
-		&event.ID,
-		&event.Title,
-		&event.Location,
-		&event.StartsAt,
-		&event.EndsAt,
-		&event.CreatedAt,
-	)
+	`, id), event)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	return event, nil
 }
 
-func (s *EventService) CreateEvent(ctx context.Context, event *model.Event) error {
+func (s *EventService) CreateEvent(ctx context.Context, event *model.Event) (err error) {
+	defer observeQuery("create_event", time.Now(), &err)
+
+	ctx, span := startSpan(ctx, "CreateEvent")
+	defer endSpan(span, &err)
+
 	tx, err := s.DB.BeginTx(ctx)
 	if err != nil {
 		return err
@@ -107,8 +123,8 @@ func (s *EventService) CreateEvent(ctx context.Context, event *model.Event) erro
 
 	var id string
 	err = tx.QueryRow(ctx, `
-			INSERT INTO events (title, location, starts_at, ends_at, created_at)
-			VALUES ($1, $2, $3, $4, $5)
+			INSERT INTO events (title, location, starts_at, ends_at, created_at, place)
+			VALUES ($1, $2, $3, $4, $5, $6)
 			RETURNING "id"
 		`,
 		event.Title,
@@ -116,6 +132,7 @@ func (s *EventService) CreateEvent(ctx context.Context, event *model.Event) erro
 		event.StartsAt,
 		event.EndsAt,
 		event.CreatedAt,
+		placeValue(event.Venue),
 	).Scan(&id)
 	if err != nil {
 		return err
@@ -123,11 +140,191 @@ func (s *EventService) CreateEvent(ctx context.Context, event *model.Event) erro
 
 	event.ID = id
 
+	if err := recordChange(ctx, tx, model.EventCreated, event, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	s.publish(ctx, model.EventCreated, event)
+
+	return nil
+}
+
+func (s *EventService) UpdateEvent(ctx context.Context, event *model.Event) (err error) {
+	defer observeQuery("update_event", time.Now(), &err)
+
+	ctx, span := startSpan(ctx, "UpdateEvent")
+	defer endSpan(span, &err)
+
+	tx, err := s.DB.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// the creation time isn't known until the row is updated, use a placeholder to satisfy the validator
+	event.CreatedAt = s.DB.now()
+
+	err = s.Validator.Struct(event)
+	if err != nil {
+		return err.(validator.ValidationErrors)
+	}
+
+	err = tx.QueryRow(ctx, `
+			UPDATE events
+			SET title = $2, location = $3, starts_at = $4, ends_at = $5, place = $6
+			WHERE id = $1
+			RETURNING created_at
+		`,
+		event.ID,
+		event.Title,
+		event.Location,
+		event.StartsAt,
+		event.EndsAt,
+		placeValue(event.Venue),
+	).Scan(&event.CreatedAt)
+	if err != nil {
+		return notFound(err)
+	}
+
+	if err := recordChange(ctx, tx, model.EventUpdated, event, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	s.publish(ctx, model.EventUpdated, event)
+
+	return nil
+}
+
+// DeleteEvent removes the event, returning it as it was before being deleted
+func (s *EventService) DeleteEvent(ctx context.Context, id string) (_ *model.Event, err error) {
+	defer observeQuery("delete_event", time.Now(), &err)
+
+	ctx, span := startSpan(ctx, "DeleteEvent")
+	defer endSpan(span, &err)
+
+	tx, err := s.DB.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	event := &model.Event{}
+
+	err = scanEvent(tx.QueryRow(ctx, `
+		DELETE FROM events
+		WHERE id = $1
+		RETURNING id, title, location, starts_at, ends_at, created_at, place
+	`, id), event)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if err := recordChange(ctx, tx, model.EventDeleted, event, nil); err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.publish(ctx, model.EventDeleted, event)
+
+	return event, nil
+}
+
+// RecordWeatherUpdate adds the forecast published by the weather service to the change log so it reaches watching clients
+func (s *EventService) RecordWeatherUpdate(ctx context.Context, eventID string, weather json.RawMessage) (err error) {
+	defer observeQuery("record_weather_update", time.Now(), &err)
+
+	ctx, span := startSpan(ctx, "RecordWeatherUpdate")
+	defer endSpan(span, &err)
+
+	tx, err := s.DB.BeginTx(ctx)
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	s.CalendarPublisher.Publish("event.created", event)
+	event := &model.Event{}
+
+	err = scanEvent(tx.QueryRow(ctx, `
+		SELECT id, title, location, starts_at, ends_at, created_at, place
+		FROM events
+		WHERE id = $1
+	`, eventID), event)
+	if err != nil {
+		return notFound(err)
+	}
+
+	if err := recordChange(ctx, tx, model.WeatherUpdated, event, weather); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// publish lets other services know about the change. The change is already stored so a failure isn't returned to the caller,
+// the publisher retries whilst the broker is unavailable and anything it gives up on is logged
+func (s *EventService) publish(ctx context.Context, changeType model.EventChangeType, event *model.Event) {
+	// keep the trace but not the cancellation, the publish shouldn't be abandoned because the client went away
+	ctx = trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+
+	if err := s.CalendarPublisher.PublishEventChange(ctx, changeType, event); err != nil {
+		s.Logger.Error(
+			"error publishing event change",
+			zap.String("type", string(changeType)),
+			zap.String("eventId", event.ID),
+			zap.Error(err),
+		)
+	}
+}
+
+// scanEvent reads the columns selected for every event, in the order id, title, location, starts_at, ends_at, created_at, place
+func scanEvent(row pgx.Row, event *model.Event) error {
+	var place *model.Place
+
+	err := row.Scan(
+		&event.ID,
+		&event.Title,
+		&event.Location,
+		&event.StartsAt,
+		&event.EndsAt,
+		&event.CreatedAt,
+		&place,
+	)
+	if err != nil {
+		return err
+	}
+
+	event.Venue = model.NewVenue(event.Location, place)
 
 	return nil
 }
+
+// placeValue stores the coordinates of venues the user picked from the suggestions, null otherwise rather than a json null
+func placeValue(venue *model.Venue) interface{} {
+	if venue == nil || venue.Place == nil {
+		return nil
+	}
+
+	return venue.Place
+}
+
+// notFound maps the errors returned when no event matches an id to ErrEventNotFound
+func notFound(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == invalidTextRepresentation) {
+		// a malformed id can't match any event either
+		return ErrEventNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "calendar",
+	Subsystem: "db",
+	Name:      "query_duration_seconds",
+	Help:      "Time taken by EventService database operations, partitioned by operation and outcome.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"operation", "outcome"})
+
+// observeQuery records how long an operation took, it's intended to be deferred with a pointer to the named error result
+func observeQuery(operation string, start time.Time, err *error) {
+	outcome := "success"
+	if *err != nil {
+		outcome = "error"
+	}
+
+	queryDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
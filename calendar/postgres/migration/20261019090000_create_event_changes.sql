@@ -0,0 +1,12 @@
+CREATE TABLE event_changes(
+  id BIGSERIAL PRIMARY KEY,
+  type TEXT NOT NULL,
+  event_id uuid NOT NULL,
+  starts_at TIMESTAMPTZ NOT NULL,
+  ends_at TIMESTAMPTZ NOT NULL,
+  event JSONB NOT NULL,
+  weather JSONB DEFAULT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX event_changes_created_at_idx ON event_changes (created_at);
@@ -0,0 +1 @@
+ALTER TABLE events ADD COLUMN place JSONB DEFAULT NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE event_changes_pruned(
+  only_row BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (only_row),
+  through_id BIGINT NOT NULL
+);
+
+-- ids have gaps, so the changes already pruned are taken to be everything before the oldest one left
+INSERT INTO event_changes_pruned (through_id) SELECT COALESCE(MIN(id) - 1, 0) FROM event_changes;
@@ -9,13 +9,14 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 //go:embed migration/*.sql
 var migrationFS embed.FS
 
 type DB struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 
 	// Datasource name.
 	connStr string
@@ -43,7 +44,7 @@ func (db *DB) Open(ctx context.Context) (err error) {
 	}
 
 	// Connect to the database.
-	if db.db, err = pgx.Connect(ctx, db.connStr); err != nil {
+	if db.db, err = pgxpool.Connect(ctx, db.connStr); err != nil {
 		return err
 	}
 
@@ -108,7 +109,7 @@ func (db *DB) migrateFile(ctx context.Context, name string) error {
 
 func (db *DB) Close(ctx context.Context) error {
 	if db.db != nil {
-		return db.db.Close(ctx)
+		db.db.Close()
 	}
 	return nil
 }
@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/alexdunne/not-so-smart-cal/calendar/postgres")
+
+// startSpan starts a client span for a database operation
+func startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		"EventService."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationKey.String(operation),
+		),
+	)
+}
+
+// endSpan records the error, if any, and ends the span. It's intended to be deferred with a pointer to the named error result
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+
+	span.End()
+}
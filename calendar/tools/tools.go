@@ -0,0 +1,9 @@
+//go:build tools
+// +build tools
+
+// Package tools pins the versions of the code generators used by go:generate
+package tools
+
+import (
+	_ "github.com/deepmap/oapi-codegen/cmd/oapi-codegen"
+)
@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+)
+
+var (
+	// ErrLagged is reported when a subscriber fell too far behind and was disconnected
+	ErrLagged = errors.New("subscriber fell behind and was disconnected")
+	// ErrClosed is reported when the hub has been closed, usually because the service is shutting down
+	ErrClosed = errors.New("hub closed")
+)
+
+// Hub fans out event changes to every subscriber within the process.
+// Publishing never blocks, a subscriber whose buffer is full is disconnected instead.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	bufferSize  int
+	closed      bool
+}
+
+func NewHub(bufferSize int) *Hub {
+	return &Hub{
+		subscribers: make(map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+type Subscription struct {
+	// C receives the changes, it's closed when the subscription ends
+	C <-chan model.EventChange
+
+	c   chan model.EventChange
+	hub *Hub
+	err error
+}
+
+// Subscribe registers a new subscriber, Close must be called once it's no longer needed
+func (h *Hub) Subscribe() *Subscription {
+	c := make(chan model.EventChange, h.bufferSize)
+	sub := &Subscription{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		sub.err = ErrClosed
+		close(c)
+		return sub
+	}
+
+	h.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+// Publish sends the change to every subscriber
+func (h *Hub) Publish(change model.EventChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		select {
+		case sub.c <- change:
+		default:
+			h.remove(sub, ErrLagged)
+		}
+	}
+}
+
+// Close disconnects every subscriber and rejects new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		h.remove(sub, ErrClosed)
+	}
+}
+
+// remove must be called with the lock held
+func (h *Hub) remove(sub *Subscription, err error) {
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+
+	delete(h.subscribers, sub)
+	sub.err = err
+	close(sub.c)
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s, nil)
+}
+
+// Err returns why the subscription ended once C has been closed
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	return s.err
+}
@@ -7,13 +7,13 @@ interface ListEventsRequestData {
 
 interface ListEventsResponse {
   data: {
-    event: {
+    events: {
       id: string;
       title: string;
       location: string | null;
       startsAt: string;
       endsAt: string;
-    };
+    }[];
   };
 }
 
@@ -2,15 +2,43 @@ import axios from "axios";
 
 interface GetEventWeatherRequestData {
   id: string;
+  units?: string;
+  lang?: string;
+}
+
+interface WeatherAlert {
+  event: string;
+  sender: string;
+  description: string;
+  start: string;
+  end: string;
+  tags?: string[];
 }
 
 interface GetEventWeatherResponse {
   data: {
     weather: {
+      source: "forecast" | "historical" | "climate";
+      confidence: "high" | "medium" | "low";
       type: string;
       description: string;
-      temp: string;
+      condition: string;
+      temp: number;
+      feelsLike: number;
+      minTemp: number;
+      maxTemp: number;
+      precipitationProbability: number;
+      precipitationVolume: number;
+      windSpeed: number;
+      windGust: number;
+      uvIndex: number;
+      humidity: number;
+      sunrise?: string;
+      sunset?: string;
+      alerts?: WeatherAlert[];
     };
+    units: string;
+    lang: string;
   };
 }
 
@@ -19,7 +47,9 @@ export const makeWeatherClient = () => {
 
   return {
     fetchEventWeather: async (data: GetEventWeatherRequestData) => {
-      const response = await client.get<GetEventWeatherResponse>(`/event/${data.id}`);
+      const response = await client.get<GetEventWeatherResponse>(`/event/${data.id}`, {
+        params: { units: data.units, lang: data.lang },
+      });
 
       return response;
     },
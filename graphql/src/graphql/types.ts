@@ -4,12 +4,48 @@ import { DateTimeResolver } from "graphql-scalars";
 
 export const DateTime = DateTimeResolver;
 
+export const WeatherAlertType = objectType({
+  name: "WeatherAlert",
+  definition(t) {
+    t.string("event");
+    t.string("sender");
+    t.string("description");
+    t.field("start", {
+      type: "DateTime",
+    });
+    t.field("end", {
+      type: "DateTime",
+    });
+  },
+});
+
 export const EventWeatherType = objectType({
   name: "EventWeather",
   definition(t) {
+    t.string("source");
+    t.string("confidence");
     t.string("type");
     t.string("description");
-    t.string("temp");
+    t.string("condition");
+    t.float("temp");
+    t.float("feelsLike");
+    t.float("minTemp");
+    t.float("maxTemp");
+    t.float("precipitationProbability");
+    t.float("precipitationVolume");
+    t.float("windSpeed");
+    t.float("windGust");
+    t.float("uvIndex");
+    t.int("humidity");
+    t.field("sunrise", {
+      type: "DateTime",
+    });
+    t.field("sunset", {
+      type: "DateTime",
+    });
+    t.list.field("alerts", {
+      type: "WeatherAlert",
+    });
   },
 });
 
@@ -28,9 +64,17 @@ export const EventType = objectType({
 
     t.field("weather", {
       type: "EventWeather",
-      resolve: async (parent, _, ctx) => {
+      args: {
+        units: stringArg({ description: "metric (default), imperial or standard" }),
+        lang: stringArg({ description: "The language for descriptions: en (default), de, es or fr" }),
+      },
+      resolve: async (parent, args, ctx) => {
         try {
-          const response = await ctx.weatherServiceClient.fetchEventWeather({ id: parent.id });
+          const response = await ctx.weatherServiceClient.fetchEventWeather({
+            id: parent.id,
+            units: args.units ?? undefined,
+            lang: args.lang ?? undefined,
+          });
           return response.data.data.weather;
         } catch (e) {
           return null;
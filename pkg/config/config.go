@@ -0,0 +1,294 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Load populates cfg, which must be a pointer to a struct, from the following sources in increasing order of precedence:
+//
+//   - the `default` tag of each field
+//   - an optional YAML file, given by the -config flag or the CONFIG_FILE environment variable
+//   - the environment variable named by the `env` tag
+//   - a file named by the same environment variable with a _FILE suffix, as used for secrets mounted by kubernetes
+//   - a command line flag, named by the `flag` tag or the dotted YAML path of the field
+//
+// Once loaded, every field tagged `required:"true"` is checked and all missing values are reported together.
+func Load(cfg interface{}, args []string) error {
+	root := reflect.ValueOf(cfg)
+	if root.Kind() != reflect.Ptr || root.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+
+	fields, err := collectFields(root.Elem(), "")
+	if err != nil {
+		return err
+	}
+
+	flagSet := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configFile := flagSet.String("config", os.Getenv("CONFIG_FILE"), "path to an optional YAML config file")
+
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		flagValues[f.flag] = flagSet.String(f.flag, "", f.usage())
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		if f.def == "" {
+			continue
+		}
+
+		if err := f.set(f.def); err != nil {
+			return fmt.Errorf("invalid default for %s: %w", f.path, err)
+		}
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, cfg); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range fields {
+		if err := f.loadEnv(); err != nil {
+			return err
+		}
+	}
+
+	var flagErr error
+	flagSet.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if f.flag != fl.Name || flagErr != nil {
+				continue
+			}
+
+			if err := f.set(*flagValues[f.flag]); err != nil {
+				flagErr = fmt.Errorf("invalid value for -%s: %w", f.flag, err)
+			}
+		}
+	})
+	if flagErr != nil {
+		return flagErr
+	}
+
+	return validate(fields)
+}
+
+type field struct {
+	// path is the dotted YAML path of the field, e.g. postgres.host
+	path     string
+	env      string
+	flag     string
+	def      string
+	help     string
+	required bool
+	value    reflect.Value
+}
+
+func (f field) usage() string {
+	usage := f.help
+	if f.env != "" {
+		usage = strings.TrimSpace(fmt.Sprintf("%s (env %s)", usage, f.env))
+	}
+
+	return usage
+}
+
+// describe names the field the way an operator would set it, for use in error messages
+func (f field) describe() string {
+	if f.env != "" {
+		return fmt.Sprintf("%s (-%s or $%s)", f.path, f.flag, f.env)
+	}
+
+	return fmt.Sprintf("%s (-%s)", f.path, f.flag)
+}
+
+func (f field) loadEnv() error {
+	if f.env == "" {
+		return nil
+	}
+
+	val, hasVal := os.LookupEnv(f.env)
+	file, hasFile := os.LookupEnv(f.env + "_FILE")
+
+	if hasVal && hasFile {
+		return fmt.Errorf("only one of %s and %s_FILE may be set", f.env, f.env)
+	}
+
+	if hasFile {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s_FILE: %w", f.env, err)
+		}
+
+		// secret files are frequently written with a trailing newline
+		val, hasVal = strings.TrimSpace(string(contents)), true
+	}
+
+	if !hasVal {
+		return nil
+	}
+
+	if err := f.set(val); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", f.env, err)
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+
+	case f.value.Kind() >= reflect.Int && f.value.Kind() <= reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(i)
+
+	case f.value.Kind() >= reflect.Uint && f.value.Kind() <= reflect.Uint64:
+		i, err := strconv.ParseUint(raw, 10, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetUint(i)
+
+	case f.value.Kind() == reflect.Float32 || f.value.Kind() == reflect.Float64:
+		fl, err := strconv.ParseFloat(raw, f.value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(fl)
+
+	default:
+		return fmt.Errorf("unsupported config type %s", f.value.Type())
+	}
+
+	return nil
+}
+
+// collectFields walks the struct, descending into nested structs, and returns every settable leaf field
+func collectFields(v reflect.Value, prefix string) ([]field, error) {
+	fields := make([]field, 0)
+
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		if structField.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name := yamlName(structField)
+		if name == "-" {
+			continue
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct && value.Type() != durationType {
+			nested, err := collectFields(value, path)
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, nested...)
+			continue
+		}
+
+		f := field{
+			path:     path,
+			env:      structField.Tag.Get("env"),
+			flag:     structField.Tag.Get("flag"),
+			def:      structField.Tag.Get("default"),
+			help:     structField.Tag.Get("usage"),
+			required: structField.Tag.Get("required") == "true",
+			value:    value,
+		}
+
+		if f.flag == "" {
+			f.flag = path
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// yamlName matches the key yaml.v2 uses for the field so flags and errors line up with the config file
+func yamlName(structField reflect.StructField) string {
+	if tag := structField.Tag.Get("yaml"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+
+	return strings.ToLower(structField.Name)
+}
+
+func loadFile(path string, cfg interface{}) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := yaml.UnmarshalStrict(contents, cfg); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// ErrMissingRequired is returned, wrapped with the names of the missing fields, when required configuration is not set
+var ErrMissingRequired = errors.New("missing required configuration")
+
+func validate(fields []field) error {
+	missing := make([]string, 0)
+	for _, f := range fields {
+		if f.required && f.value.IsZero() {
+			missing = append(missing, f.describe())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingRequired, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
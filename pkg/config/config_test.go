@@ -0,0 +1,390 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDatabase struct {
+	Host     string `yaml:"host" env:"CONFIG_TEST_DB_HOST" required:"true"`
+	Port     int    `yaml:"port" env:"CONFIG_TEST_DB_PORT" default:"5432"`
+	Password string `yaml:"password" env:"CONFIG_TEST_DB_PASSWORD" required:"true"`
+}
+
+type testConfig struct {
+	Database testDatabase  `yaml:"database"`
+	Timeout  time.Duration `yaml:"timeout" env:"CONFIG_TEST_TIMEOUT" flag:"timeout" default:"20s"`
+	Workers  int           `yaml:"workers" default:"3"`
+	Verbose  bool          `yaml:"verbose" env:"CONFIG_TEST_VERBOSE"`
+	Ratio    float64       `yaml:"ratio" env:"CONFIG_TEST_RATIO" default:"0.5"`
+	Name     string        `yaml:"name" env:"CONFIG_TEST_NAME" default:"calendar"`
+	// internal is skipped as it's unexported
+	internal string
+}
+
+// setenv sets the variables for the rest of the test, and unsets CONFIG_FILE so the environment running the tests
+// can't supply a config file
+func setenv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	env["CONFIG_FILE"] = ""
+	for name, value := range env {
+		previous, had := os.LookupEnv(name)
+		if name == "CONFIG_FILE" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+
+		name := name
+		t.Cleanup(func() {
+			if had {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+// writeFile writes contents to a file in the test's temporary directory and returns its path
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+
+	return path
+}
+
+// required are the settings every test sets unless it's checking them
+var required = map[string]string{
+	"CONFIG_TEST_DB_HOST":     "localhost",
+	"CONFIG_TEST_DB_PASSWORD": "secret",
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := `
+database:
+  host: yaml-host
+  port: 6432
+timeout: 1m
+workers: 5
+name: from-yaml
+`
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want testConfig
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"CONFIG_TEST_DB_HOST": "env-host", "CONFIG_TEST_DB_PASSWORD": "secret"},
+			want: testConfig{
+				Database: testDatabase{Host: "env-host", Port: 5432, Password: "secret"},
+				Timeout:  20 * time.Second,
+				Workers:  3,
+				Ratio:    0.5,
+				Name:     "calendar",
+			},
+		},
+		{
+			name: "yaml over defaults",
+			env:  map[string]string{"CONFIG_TEST_DB_PASSWORD": "secret"},
+			args: []string{"-config", "{file}"},
+			want: testConfig{
+				Database: testDatabase{Host: "yaml-host", Port: 6432, Password: "secret"},
+				Timeout:  time.Minute,
+				Workers:  5,
+				Ratio:    0.5,
+				Name:     "from-yaml",
+			},
+		},
+		{
+			name: "env over yaml",
+			env:  map[string]string{"CONFIG_TEST_DB_HOST": "env-host", "CONFIG_TEST_DB_PASSWORD": "secret", "CONFIG_TEST_TIMEOUT": "2m", "CONFIG_TEST_VERBOSE": "true"},
+			args: []string{"-config", "{file}"},
+			want: testConfig{
+				Database: testDatabase{Host: "env-host", Port: 6432, Password: "secret"},
+				Timeout:  2 * time.Minute,
+				Workers:  5,
+				Verbose:  true,
+				Ratio:    0.5,
+				Name:     "from-yaml",
+			},
+		},
+		{
+			name: "flags over env",
+			env:  map[string]string{"CONFIG_TEST_DB_HOST": "env-host", "CONFIG_TEST_DB_PASSWORD": "secret", "CONFIG_TEST_TIMEOUT": "2m"},
+			args: []string{"-config", "{file}", "-timeout", "3m", "-database.host", "flag-host", "-workers=7", "-ratio", "0.25"},
+			want: testConfig{
+				Database: testDatabase{Host: "flag-host", Port: 6432, Password: "secret"},
+				Timeout:  3 * time.Minute,
+				Workers:  7,
+				Ratio:    0.25,
+				Name:     "from-yaml",
+			},
+		},
+		{
+			name: "flags set to empty",
+			env:  map[string]string{"CONFIG_TEST_DB_HOST": "env-host", "CONFIG_TEST_DB_PASSWORD": "secret"},
+			args: []string{"-name="},
+			want: testConfig{
+				Database: testDatabase{Host: "env-host", Port: 5432, Password: "secret"},
+				Timeout:  20 * time.Second,
+				Workers:  3,
+				Ratio:    0.5,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, tt.env)
+			path := writeFile(t, "config.yaml", file)
+
+			args := make([]string, len(tt.args))
+			for i, arg := range tt.args {
+				args[i] = strings.Replace(arg, "{file}", path, 1)
+			}
+
+			var cfg testConfig
+			if err := Load(&cfg, args); err != nil {
+				t.Fatalf("loading config: %v", err)
+			}
+
+			if cfg != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	setenv(t, map[string]string{"CONFIG_TEST_DB_PASSWORD": "secret"})
+	os.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", "database:\n  host: yaml-host\n"))
+
+	var cfg testConfig
+	if err := Load(&cfg, nil); err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+
+	if cfg.Database.Host != "yaml-host" {
+		t.Fatalf("expected the host from CONFIG_FILE, got %q", cfg.Database.Host)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "trailing newline",
+			env:  map[string]string{"CONFIG_TEST_DB_HOST": "localhost", "CONFIG_TEST_DB_PASSWORD_FILE": "{secret}"},
+			want: "from-file",
+		},
+		{
+			name:    "with the variable too",
+			env:     map[string]string{"CONFIG_TEST_DB_HOST": "localhost", "CONFIG_TEST_DB_PASSWORD": "secret", "CONFIG_TEST_DB_PASSWORD_FILE": "{secret}"},
+			wantErr: "only one of CONFIG_TEST_DB_PASSWORD and CONFIG_TEST_DB_PASSWORD_FILE may be set",
+		},
+		{
+			name:    "missing file",
+			env:     map[string]string{"CONFIG_TEST_DB_HOST": "localhost", "CONFIG_TEST_DB_PASSWORD_FILE": "{missing}"},
+			wantErr: "error reading CONFIG_TEST_DB_PASSWORD_FILE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := writeFile(t, "password", "from-file\n")
+			missing := filepath.Join(t.TempDir(), "missing")
+
+			env := make(map[string]string, len(tt.env))
+			for name, value := range tt.env {
+				value = strings.Replace(value, "{secret}", secret, 1)
+				env[name] = strings.Replace(value, "{missing}", missing, 1)
+			}
+			setenv(t, env)
+
+			var cfg testConfig
+			err := Load(&cfg, nil)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("loading config: %v", err)
+			}
+			if cfg.Database.Password != tt.want {
+				t.Fatalf("expected the password %q, got %q", tt.want, cfg.Database.Password)
+			}
+		})
+	}
+}
+
+func TestLoadRequired(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		missing []string
+	}{
+		{
+			name:    "all missing",
+			env:     map[string]string{},
+			missing: []string{"database.host (-database.host or $CONFIG_TEST_DB_HOST)", "database.password (-database.password or $CONFIG_TEST_DB_PASSWORD)"},
+		},
+		{
+			name:    "one missing",
+			env:     map[string]string{"CONFIG_TEST_DB_PASSWORD": "secret"},
+			missing: []string{"database.host (-database.host or $CONFIG_TEST_DB_HOST)"},
+		},
+		{
+			name:    "set empty",
+			env:     map[string]string{"CONFIG_TEST_DB_HOST": "", "CONFIG_TEST_DB_PASSWORD": "secret"},
+			missing: []string{"database.host"},
+		},
+		{
+			name: "set by flag",
+			env:  map[string]string{"CONFIG_TEST_DB_PASSWORD": "secret"},
+			args: []string{"-database.host", "localhost"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, tt.env)
+
+			var cfg testConfig
+			err := Load(&cfg, tt.args)
+
+			if len(tt.missing) == 0 {
+				if err != nil {
+					t.Fatalf("loading config: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrMissingRequired) {
+				t.Fatalf("expected ErrMissingRequired, got %v", err)
+			}
+			for _, missing := range tt.missing {
+				if !strings.Contains(err.Error(), missing) {
+					t.Fatalf("expected %q to be reported as missing, got %v", missing, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		file    string
+		wantErr string
+	}{
+		{
+			name:    "duration from env",
+			env:     map[string]string{"CONFIG_TEST_TIMEOUT": "20"},
+			wantErr: "invalid value for CONFIG_TEST_TIMEOUT",
+		},
+		{
+			name:    "duration from flag",
+			args:    []string{"-timeout", "soon"},
+			wantErr: "invalid value for -timeout",
+		},
+		{
+			name:    "int from env",
+			env:     map[string]string{"CONFIG_TEST_DB_PORT": "5432a"},
+			wantErr: "invalid value for CONFIG_TEST_DB_PORT",
+		},
+		{
+			name:    "int from flag",
+			args:    []string{"-workers", "3.5"},
+			wantErr: "invalid value for -workers",
+		},
+		{
+			name:    "bool from env",
+			env:     map[string]string{"CONFIG_TEST_VERBOSE": "yes please"},
+			wantErr: "invalid value for CONFIG_TEST_VERBOSE",
+		},
+		{
+			name:    "int from yaml",
+			file:    "workers: lots\n",
+			wantErr: "error parsing config file",
+		},
+		{
+			name:    "unknown yaml key",
+			file:    "wrokers: 3\n",
+			wantErr: "error parsing config file",
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-wrokers", "3"},
+			wantErr: "flag provided but not defined: -wrokers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			for name, value := range required {
+				env[name] = value
+			}
+			for name, value := range tt.env {
+				env[name] = value
+			}
+			setenv(t, env)
+
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, "config.yaml", tt.file)}, args...)
+			}
+
+			var cfg testConfig
+			err := Load(&cfg, args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadInvalidDefault(t *testing.T) {
+	setenv(t, map[string]string{})
+
+	var cfg struct {
+		Timeout time.Duration `yaml:"timeout" default:"20"`
+	}
+
+	err := Load(&cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid default for timeout") {
+		t.Fatalf("expected the default to be rejected, got %v", err)
+	}
+}
+
+func TestLoadRequiresStructPointer(t *testing.T) {
+	var cfg testConfig
+	if err := Load(cfg, nil); err == nil {
+		t.Fatal("expected a struct that isn't a pointer to be rejected")
+	}
+}
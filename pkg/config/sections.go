@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// HTTP configures a service's http server
+type HTTP struct {
+	Port string `yaml:"port" env:"PORT" default:"8080" usage:"port the http server listens on"`
+}
+
+// Addr returns the address the http server should listen on
+func (h HTTP) Addr() string {
+	return ":" + h.Port
+}
+
+// GRPC configures a service's grpc server
+type GRPC struct {
+	Port string `yaml:"port" env:"GRPC_PORT" default:"9000" usage:"port the grpc server listens on"`
+}
+
+// Addr returns the address the grpc server should listen on
+func (g GRPC) Addr() string {
+	return ":" + g.Port
+}
+
+// Shutdown configures graceful shutdown
+type Shutdown struct {
+	Timeout time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"20s" usage:"how long in-flight work is given to finish on shutdown"`
+}
+
+// Postgres configures the connection to a postgres database
+type Postgres struct {
+	Host     string `yaml:"host" env:"POSTGRES_HOST" required:"true"`
+	Port     string `yaml:"port" env:"POSTGRES_PORT" default:"5432"`
+	User     string `yaml:"user" env:"POSTGRES_USER" required:"true"`
+	Password string `yaml:"password" env:"POSTGRES_PASSWORD" required:"true"`
+	Database string `yaml:"database" env:"POSTGRES_DB" required:"true"`
+}
+
+// ConnString returns the postgres connection url, escaping the credentials
+func (p Postgres) ConnString() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(p.User, p.Password),
+		Host:   net.JoinHostPort(p.Host, p.Port),
+		Path:   "/" + p.Database,
+	}
+
+	return u.String()
+}
+
+// String hides the password should the config ever be logged
+func (p Postgres) String() string {
+	return fmt.Sprintf("postgres://%s@%s/%s", p.User, net.JoinHostPort(p.Host, p.Port), p.Database)
+}
+
+// AMQP configures the connection to a rabbitmq broker
+type AMQP struct {
+	Host     string `yaml:"host" env:"AMQP_HOST" required:"true"`
+	Port     string `yaml:"port" env:"AMQP_PORT" default:"5672"`
+	User     string `yaml:"user" env:"AMQP_USER" required:"true"`
+	Password string `yaml:"password" env:"AMQP_PASSWORD" required:"true"`
+
+	PublishPolicy  string        `yaml:"publishPolicy" env:"AMQP_PUBLISH_POLICY" default:"buffer" usage:"what to do with messages published whilst the broker is unavailable, buffer or fail-fast"`
+	PublishBuffer  int           `yaml:"publishBuffer" env:"AMQP_PUBLISH_BUFFER" default:"1000" usage:"how many publishes may wait for the broker at once"`
+	PublishTimeout time.Duration `yaml:"publishTimeout" env:"AMQP_PUBLISH_TIMEOUT" default:"10s" usage:"how long a publish may wait for the broker to confirm it"`
+}
+
+// URL returns the amqp connection url, escaping the credentials
+func (a AMQP) URL() string {
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(a.User, a.Password),
+		Host:   net.JoinHostPort(a.Host, a.Port),
+	}
+
+	return u.String()
+}
+
+// Redis configures the connection to a redis server
+type Redis struct {
+	Host     string `yaml:"host" env:"REDIS_HOST" required:"true"`
+	Port     string `yaml:"port" env:"REDIS_PORT" default:"6379"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+}
+
+// Addr returns the host:port of the redis server
+func (r Redis) Addr() string {
+	return net.JoinHostPort(r.Host, r.Port)
+}
+
+// Metrics configures the standalone metrics server used by processes that don't otherwise serve http
+type Metrics struct {
+	Port string `yaml:"port" env:"METRICS_PORT" default:"9090" usage:"port the metrics endpoint is served on"`
+}
+
+// Addr returns the address the metrics server should listen on
+func (m Metrics) Addr() string {
+	return ":" + m.Port
+}
+
+// Retry configures how consumers retry messages they fail to handle before dead-lettering them
+type Retry struct {
+	MaxAttempts  int           `yaml:"maxAttempts" env:"RETRY_MAX_ATTEMPTS" default:"5" usage:"how many times a message is handled before it's dead-lettered"`
+	InitialDelay time.Duration `yaml:"initialDelay" env:"RETRY_INITIAL_DELAY" default:"10s" usage:"how long to wait before the first retry, doubling for each retry after"`
+	MaxDelay     time.Duration `yaml:"maxDelay" env:"RETRY_MAX_DELAY" default:"10m" usage:"the longest wait between retries"`
+}
+
+// Consumer configures how many messages a consumer handles at once and for how long
+type Consumer struct {
+	Concurrency int           `yaml:"concurrency" env:"CONSUMER_CONCURRENCY" default:"10" usage:"how many messages are handled at once, also used as the prefetch"`
+	Timeout     time.Duration `yaml:"timeout" env:"CONSUMER_TIMEOUT" default:"30s" usage:"how long handling a single message may take"`
+}
@@ -0,0 +1,17 @@
+module github.com/alexdunne/not-so-smart-cal/pkg
+
+go 1.16
+
+require (
+	github.com/gin-gonic/gin v1.7.4
+	github.com/google/uuid v1.2.0
+	github.com/prometheus/client_golang v1.11.0
+	github.com/streadway/amqp v1.0.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	go.uber.org/zap v1.16.0
+	gopkg.in/yaml.v2 v2.4.0
+)
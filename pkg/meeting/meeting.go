@@ -0,0 +1,99 @@
+// Package meeting recognises event locations that are virtual meetings rather than somewhere physical
+package meeting
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ProviderOther is the provider of meeting links from hosts that aren't recognised
+const ProviderOther = "other"
+
+// Meeting is a virtual meeting found in a location
+type Meeting struct {
+	// URL is the link to join, empty when the location only names the provider such as "Zoom"
+	URL      string
+	Provider string
+}
+
+type provider struct {
+	name  string
+	hosts []string
+	// names are what people type instead of a link, matched against the whole location
+	names []string
+}
+
+var providers = []provider{
+	{name: "zoom", hosts: []string{"zoom.us", "zoom.com"}, names: []string{"zoom"}},
+	{name: "google-meet", hosts: []string{"meet.google.com"}, names: []string{"google meet", "hangouts"}},
+	{name: "teams", hosts: []string{"teams.microsoft.com", "teams.live.com"}, names: []string{"teams", "microsoft teams", "ms teams"}},
+	{name: "webex", hosts: []string{"webex.com"}, names: []string{"webex"}},
+	{name: "skype", hosts: []string{"skype.com"}, names: []string{"skype"}},
+	{name: "whereby", hosts: []string{"whereby.com"}, names: []string{"whereby"}},
+}
+
+// virtualNames are locations that say the event is online without naming a provider
+var virtualNames = []string{"online", "virtual", "remote", "video call", "phone", "phone call"}
+
+// Detect reports whether the location is a virtual meeting.
+// A link to a known provider anywhere in the location counts, as does a location that's only a link or only a name
+// such as "Zoom call". Other links are taken to be about somewhere physical, like a booking for a park
+func Detect(location string) (*Meeting, bool) {
+	words := strings.Fields(location)
+	for _, word := range words {
+		link, err := url.Parse(strings.TrimLeft(strings.TrimRight(word, ".,;)>"), "(<"))
+		if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+			continue
+		}
+
+		provider := providerForHost(link.Hostname())
+		if provider != ProviderOther || len(words) == 1 {
+			return &Meeting{URL: link.String(), Provider: provider}, true
+		}
+	}
+
+	name := strings.Join(strings.Fields(strings.ToLower(location)), " ")
+	if provider, ok := providerForName(name); ok {
+		return &Meeting{Provider: provider}, true
+	}
+
+	// "Zoom call" and "Teams meeting" name the provider too
+	name = strings.TrimSuffix(strings.TrimSuffix(name, " call"), " meeting")
+	if provider, ok := providerForName(name); ok {
+		return &Meeting{Provider: provider}, true
+	}
+
+	return nil, false
+}
+
+func providerForName(name string) (string, bool) {
+	for _, p := range providers {
+		for _, n := range p.names {
+			if name == n {
+				return p.name, true
+			}
+		}
+	}
+
+	for _, n := range virtualNames {
+		if name == n {
+			return ProviderOther, true
+		}
+	}
+
+	return "", false
+}
+
+func providerForHost(host string) string {
+	host = strings.ToLower(host)
+
+	for _, p := range providers {
+		for _, h := range p.hosts {
+			if host == h || strings.HasSuffix(host, "."+h) {
+				return p.name
+			}
+		}
+	}
+
+	return ProviderOther
+}
@@ -0,0 +1,56 @@
+package meeting
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		location string
+		want     *Meeting
+	}{
+		// links to known providers, anywhere in the location
+		{"https://zoom.us/j/123456789", &Meeting{URL: "https://zoom.us/j/123456789", Provider: "zoom"}},
+		{"https://acme.zoom.us/j/123456789?pwd=abc", &Meeting{URL: "https://acme.zoom.us/j/123456789?pwd=abc", Provider: "zoom"}},
+		{"Standup (https://meet.google.com/abc-defg-hij).", &Meeting{URL: "https://meet.google.com/abc-defg-hij", Provider: "google-meet"}},
+		{"Join at https://teams.microsoft.com/l/meetup-join/1, dial-in 0800 123", &Meeting{URL: "https://teams.microsoft.com/l/meetup-join/1", Provider: "teams"}},
+		{"Tickets https://eventbrite.com/e/123 or https://WEBEX.com/meet/jo", &Meeting{URL: "https://WEBEX.com/meet/jo", Provider: "webex"}},
+
+		// a link to an unknown host is only a meeting when it's the whole location
+		{"https://jitsi.example.org/standup", &Meeting{URL: "https://jitsi.example.org/standup", Provider: ProviderOther}},
+		{"  https://jitsi.example.org/standup  ", &Meeting{URL: "https://jitsi.example.org/standup", Provider: ProviderOther}},
+		{"Hyde Park, London - tickets https://eventbrite.com/e/123", nil},
+		{"The Ivy, https://the-ivy.co.uk", nil},
+
+		// names of providers, with and without a suffix
+		{"Zoom", &Meeting{Provider: "zoom"}},
+		{"  microsoft   TEAMS ", &Meeting{Provider: "teams"}},
+		{"Zoom call", &Meeting{Provider: "zoom"}},
+		{"Teams meeting", &Meeting{Provider: "teams"}},
+		{"Google Meet call", &Meeting{Provider: "google-meet"}},
+
+		// names that don't say which provider
+		{"Online", &Meeting{Provider: ProviderOther}},
+		{"Video call", &Meeting{Provider: ProviderOther}},
+		{"Phone call", &Meeting{Provider: ProviderOther}},
+		{"Phone", &Meeting{Provider: ProviderOther}},
+		{"Virtual meeting", &Meeting{Provider: ProviderOther}},
+
+		// somewhere physical
+		{"", nil},
+		{"Hyde Park", nil},
+		{"Zoom HQ, San Jose", nil},
+		{"Call centre", nil},
+		{"ftp://files.example.com", nil},
+		{"zoom.us", nil},
+	}
+
+	for _, tt := range tests {
+		got, ok := Detect(tt.location)
+
+		switch {
+		case tt.want == nil && ok:
+			t.Errorf("Detect(%q) = %+v, want no meeting", tt.location, got)
+		case tt.want != nil && (!ok || *got != *tt.want):
+			t.Errorf("Detect(%q) = %+v, %v, want %+v", tt.location, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,687 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/config"
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+const amqpSystem = "rabbitmq"
+
+var (
+	// ErrUnavailable is returned by fail-fast publishers whilst the broker can't be reached
+	ErrUnavailable = errors.New("messaging: broker unavailable")
+	// ErrBufferFull is returned when too many publishes are already waiting for the broker
+	ErrBufferFull = errors.New("messaging: publish buffer full")
+	// ErrNacked is returned when the broker refuses responsibility for a message
+	ErrNacked = errors.New("messaging: message rejected by the broker")
+
+	errChannelClosed = errors.New("messaging: channel closed before the publish was confirmed")
+)
+
+// PublishPolicy decides what happens to messages published whilst the broker is unavailable
+type PublishPolicy int
+
+const (
+	// PublishBuffer holds publishes until the broker is available again, up to the buffer size and timeout
+	PublishBuffer PublishPolicy = iota
+	// PublishFailFast returns ErrUnavailable straight away
+	PublishFailFast
+)
+
+func ParsePublishPolicy(policy string) (PublishPolicy, error) {
+	switch policy {
+	case "buffer":
+		return PublishBuffer, nil
+	case "fail-fast":
+		return PublishFailFast, nil
+	}
+
+	return 0, fmt.Errorf("unknown publish policy %q, expected buffer or fail-fast", policy)
+}
+
+type AMQPPublisherOptions struct {
+	Policy PublishPolicy
+	// BufferSize limits how many publishes may wait for the broker at once
+	BufferSize int
+	// Timeout limits how long a publish may take, including waiting for the broker and its confirmation
+	Timeout time.Duration
+}
+
+// PublisherOptions builds the publisher options from the service's amqp configuration
+func PublisherOptions(cfg config.AMQP) (AMQPPublisherOptions, error) {
+	policy, err := ParsePublishPolicy(cfg.PublishPolicy)
+	if err != nil {
+		return AMQPPublisherOptions{}, err
+	}
+
+	return AMQPPublisherOptions{
+		Policy:     policy,
+		BufferSize: cfg.PublishBuffer,
+		Timeout:    cfg.PublishTimeout,
+	}, nil
+}
+
+// AMQPPublisher publishes persistent messages to RabbitMQ topic exchanges.
+// Publisher confirms are enabled so a publish only succeeds once the broker has taken responsibility for the message
+type AMQPPublisher struct {
+	conn   *AMQPConnection
+	opts   AMQPPublisherOptions
+	logger *zap.Logger
+
+	// waiting is a semaphore bounding the publishes in progress
+	waiting chan struct{}
+
+	mu      sync.Mutex
+	channel *amqp.Channel
+	// ready is closed whilst channel is usable, it's replaced when the channel is lost
+	ready    chan struct{}
+	nextTag  uint64
+	pending  map[uint64]chan error
+	declared map[string]bool
+
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+var _ Publisher = (*AMQPPublisher)(nil)
+
+func NewAMQPPublisher(conn *AMQPConnection, opts AMQPPublisherOptions, logger *zap.Logger) *AMQPPublisher {
+	if opts.BufferSize < 1 {
+		opts.BufferSize = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &AMQPPublisher{
+		conn:     conn,
+		opts:     opts,
+		logger:   logger,
+		waiting:  make(chan struct{}, opts.BufferSize),
+		ready:    make(chan struct{}),
+		pending:  make(map[uint64]chan error),
+		declared: make(map[string]bool),
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+	}
+
+	go p.run(ctx)
+
+	return p
+}
+
+// run keeps a confirming channel open, reopening it and re-declaring the exchanges whenever it's lost
+func (p *AMQPPublisher) run(ctx context.Context) {
+	defer close(p.stopped)
+
+	for attempt := 0; ; attempt++ {
+		ch, err := p.conn.Channel(ctx)
+		if err != nil {
+			// only fails once the publisher or the connection has been closed
+			return
+		}
+
+		confirms, err := p.open(ch)
+		if err != nil {
+			p.logger.Error("error opening publisher channel", zap.Error(err))
+			ch.Close()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectBackoff.Delay(attempt)):
+			}
+			continue
+		}
+		attempt = 0
+
+		// confirmations arrive in order of delivery tag and the channel is closed along with the amqp channel
+		for confirm := range confirms {
+			err := ErrNacked
+			if confirm.Ack {
+				err = nil
+			}
+			p.resolve(confirm.DeliveryTag, err)
+		}
+
+		p.lost()
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// open puts the channel into confirm mode, re-declares the exchanges and makes it available to publishes
+func (p *AMQPPublisher) open(ch *amqp.Channel) (<-chan amqp.Confirmation, error) {
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 64))
+
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("error enabling publisher confirms: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for exchange := range p.declared {
+		if err := declareExchange(ch, exchange); err != nil {
+			return nil, err
+		}
+	}
+
+	p.channel = ch
+	p.nextTag = 1
+	close(p.ready)
+
+	return confirms, nil
+}
+
+// lost fails every publish still waiting for a confirmation, they're retried once a channel is available again
+func (p *AMQPPublisher) lost() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.channel = nil
+	p.ready = make(chan struct{})
+
+	for tag, confirmed := range p.pending {
+		confirmed <- errChannelClosed
+		delete(p.pending, tag)
+	}
+}
+
+func (p *AMQPPublisher) resolve(tag uint64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if confirmed, ok := p.pending[tag]; ok {
+		confirmed <- err
+		delete(p.pending, tag)
+	}
+}
+
+func (p *AMQPPublisher) Publish(ctx context.Context, exchange, routingKey string, msg Message) (err error) {
+	prepare(&msg)
+
+	ctx, span := startPublishSpan(ctx, amqpSystem, exchange, routingKey, &msg)
+	defer func() { endSpan(span, err) }()
+
+	if p.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.Timeout)
+		defer cancel()
+	}
+
+	select {
+	case p.waiting <- struct{}{}:
+	default:
+		return ErrBufferFull
+	}
+	publishesWaiting.Inc()
+	defer func() {
+		<-p.waiting
+		publishesWaiting.Dec()
+	}()
+
+	for {
+		err := p.publish(ctx, exchange, routingKey, &msg)
+		switch {
+		case err == nil:
+			publishConfirms.WithLabelValues("ack").Inc()
+			return nil
+		case errors.Is(err, ErrNacked):
+			publishConfirms.WithLabelValues("nack").Inc()
+			return err
+		case errors.Is(err, errChannelClosed) && p.opts.Policy == PublishBuffer:
+			// the message may or may not have reached the broker, publishing again risks a duplicate rather than a loss
+			continue
+		case errors.Is(err, errChannelClosed):
+			return ErrUnavailable
+		default:
+			return err
+		}
+	}
+}
+
+// publish sends the message once a channel is available and waits for the broker to confirm it
+func (p *AMQPPublisher) publish(ctx context.Context, exchange, routingKey string, msg *Message) error {
+	confirmed, err := p.send(ctx, exchange, routingKey, msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-confirmed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *AMQPPublisher) send(ctx context.Context, exchange, routingKey string, msg *Message) (<-chan error, error) {
+	for {
+		p.mu.Lock()
+		ch, ready := p.channel, p.ready
+		if ch != nil {
+			break
+		}
+		p.mu.Unlock()
+
+		if p.opts.Policy == PublishFailFast {
+			return nil, ErrUnavailable
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, ctx.Err())
+		case <-p.stopped:
+			return nil, ErrClosed
+		}
+	}
+	// channels aren't safe for concurrent publishing, the lock is held until the publish has been sent
+	defer p.mu.Unlock()
+
+	if !p.declared[exchange] {
+		if err := declareExchange(p.channel, exchange); err != nil {
+			return nil, err
+		}
+		p.declared[exchange] = true
+	}
+
+	err := p.channel.Publish(exchange, routingKey, false, false, amqp.Publishing{
+		Headers:      toTable(msg.Headers),
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	})
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil, errChannelClosed
+	} else if err != nil {
+		return nil, err
+	}
+
+	confirmed := make(chan error, 1)
+	p.pending[p.nextTag] = confirmed
+	p.nextTag++
+
+	return confirmed, nil
+}
+
+// Close stops publishing, the connection is left open
+func (p *AMQPPublisher) Close() error {
+	p.cancel()
+
+	p.mu.Lock()
+	ch := p.channel
+	p.mu.Unlock()
+
+	if ch != nil {
+		if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+			return fmt.Errorf("error closing the publisher channel: %w", err)
+		}
+	}
+
+	<-p.stopped
+
+	return nil
+}
+
+// AMQPSubscriber consumes from durable RabbitMQ queues, acknowledging each message once it has been handled or
+// moved to a retry or dead-letter queue. Consumers are restarted, re-declaring their queues and bindings,
+// whenever the connection or channel is lost
+type AMQPSubscriber struct {
+	conn   *AMQPConnection
+	name   string
+	logger *zap.Logger
+}
+
+var _ Subscriber = (*AMQPSubscriber)(nil)
+
+// NewAMQPSubscriber creates a subscriber whose consumers are tagged with the name, usually the service's
+func NewAMQPSubscriber(conn *AMQPConnection, name string, logger *zap.Logger) *AMQPSubscriber {
+	return &AMQPSubscriber{
+		conn:   conn,
+		name:   name,
+		logger: logger,
+	}
+}
+
+func (s *AMQPSubscriber) Subscribe(ctx context.Context, binding Binding, handler Handler) error {
+	for attempt := 0; ; attempt++ {
+		consumed, err := s.consume(ctx, binding, handler)
+		if ctx.Err() != nil {
+			return nil
+		} else if errors.Is(err, ErrClosed) {
+			return err
+		}
+
+		if consumed {
+			attempt = 0
+		}
+
+		delay := reconnectBackoff.Delay(attempt)
+		s.logger.Warn(
+			"consumer stopped unexpectedly, restarting",
+			zap.String("queue", binding.Queue),
+			zap.Error(err),
+			zap.Duration("delay", delay),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// consume handles deliveries until the context is done or the channel is lost, reporting whether consuming started
+func (s *AMQPSubscriber) consume(ctx context.Context, binding Binding, handler Handler) (bool, error) {
+	ch, err := s.createChannel(ctx, binding)
+	if err != nil {
+		return false, err
+	}
+	defer ch.Close()
+
+	chanClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	// failed messages are only acknowledged once the broker confirms their move to a retry or dead-letter queue
+	failures, err := newConfirmingChannel(ch)
+	if err != nil {
+		return false, err
+	}
+
+	// deliveries are acknowledged manually so anything unfinished at shutdown is requeued by the broker
+	consumerTag := s.name + "-" + uuid.NewString()
+	deliveries, err := ch.Consume(binding.Queue, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		return false, fmt.Errorf("error whilst consuming messages: %w", err)
+	}
+
+	c := &amqpConsumer{
+		failures: failures,
+		binding:  binding,
+		handler:  handler,
+		logger:   s.logger,
+	}
+
+	workerDone := make(chan struct{})
+	go func() {
+		c.worker(ctx, deliveries)
+		close(workerDone)
+	}()
+
+	select {
+	case chanErr := <-chanClosed:
+		<-workerDone
+
+		if chanErr == nil {
+			return true, errChannelClosed
+		}
+		return true, chanErr
+
+	case <-ctx.Done():
+	}
+
+	// stop the broker sending any further deliveries, this closes the deliveries channel
+	if err := ch.Cancel(consumerTag, false); err != nil {
+		return true, fmt.Errorf("error cancelling consumer: %w", err)
+	}
+
+	<-workerDone
+
+	return true, nil
+}
+
+// createChannel opens a channel and creates all of the necessary exchanges, queues, and bindings
+func (s *AMQPSubscriber) createChannel(ctx context.Context, binding Binding) (*amqp.Channel, error) {
+	ch, err := s.conn.Channel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := declareBinding(ch, binding); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	prefetch := binding.Concurrency
+	if prefetch < 1 {
+		prefetch = 1
+	}
+
+	err = ch.Qos(prefetch, 0, false)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("error configuring prefetch: %w", err)
+	}
+
+	return ch, nil
+}
+
+// amqpConsumer handles the deliveries from a single channel
+type amqpConsumer struct {
+	// failures moves failed messages to the retry and dead-letter queues on the deliveries' channel
+	failures *confirmingChannel
+	binding  Binding
+	handler  Handler
+	logger   *zap.Logger
+}
+
+// worker hands the deliveries to a pool of goroutines the size of the binding's concurrency,
+// returning once the deliveries channel is closed and every delivery has been handled
+func (c *amqpConsumer) worker(ctx context.Context, deliveries <-chan amqp.Delivery) {
+	pool := newDispatcher(c.binding.Concurrency)
+	defer pool.close()
+
+	for delivery := range deliveries {
+		delivery := delivery
+		msg := fromDelivery(delivery)
+
+		pool.dispatch(c.binding.key(msg), func() {
+			c.process(ctx, delivery, msg)
+		})
+	}
+}
+
+func (c *amqpConsumer) process(ctx context.Context, delivery amqp.Delivery, msg *Message) {
+	if ctx.Err() != nil {
+		// shutting down, hand anything we haven't started back to the broker
+		delivery.Nack(false, true)
+		return
+	}
+
+	err := handle(amqpSystem, c.binding, msg, c.handler)
+	if err != nil {
+		if err := c.fail(msg, err); err != nil {
+			c.logger.Error("error moving failed message, requeueing it", zap.String("queue", c.binding.Queue), zap.Error(err))
+			delivery.Nack(false, true)
+			return
+		}
+	}
+
+	// fails if the channel was lost whilst handling, the broker redelivers the message instead
+	delivery.Ack(false)
+}
+
+// fail moves the message to a retry queue, or to the dead-letter queue once it has run out of attempts
+func (c *amqpConsumer) fail(msg *Message, handlerErr error) error {
+	f := c.binding.Retry.next(msg, handlerErr)
+
+	headers := failureHeaders(msg, f, handlerErr)
+
+	queue := DeadLetterQueue(c.binding.Queue)
+	switch {
+	case f.deferred:
+		queue = retryQueue(c.binding.Queue, f.delay)
+		messagesDeferred.WithLabelValues(c.binding.Queue).Inc()
+	case !f.deadLetter:
+		queue = retryQueue(c.binding.Queue, f.delay)
+		messagesRetried.WithLabelValues(c.binding.Queue).Inc()
+	default:
+		c.logger.Warn(
+			"dead-lettering message",
+			zap.String("queue", c.binding.Queue),
+			zap.String("messageId", msg.ID),
+			zap.Int("attempts", f.attempts),
+			zap.Error(handlerErr),
+		)
+		messagesDeadLettered.WithLabelValues(c.binding.Queue).Inc()
+	}
+
+	return c.failures.publishToQueue(queue, msg, headers)
+}
+
+// confirmingChannel publishes straight to queues on a channel in confirm mode, waiting for each publish to be confirmed
+type confirmingChannel struct {
+	ch       *amqp.Channel
+	confirms <-chan amqp.Confirmation
+
+	// publishes share the channel's confirmations, so they're sent one at a time
+	mu sync.Mutex
+	// tag is the delivery tag of the latest publish, the broker numbers a channel's publishes from one
+	tag uint64
+}
+
+// newConfirmingChannel puts the channel into confirm mode
+func newConfirmingChannel(ch *amqp.Channel) (*confirmingChannel, error) {
+	// the buffer holds confirmations that arrive after their publish timed out, until the next publish skips them.
+	// They're sent to it by the connection's reader, which would otherwise block
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 64))
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("error enabling publisher confirms: %w", err)
+	}
+
+	return &confirmingChannel{ch: ch, confirms: confirms}, nil
+}
+
+// publishToQueue sends the message straight to the queue through the default exchange and waits for its confirmation.
+// Confirmations are matched to the publish by delivery tag, so a late one for an earlier publish isn't taken for this one's
+func (c *confirmingChannel) publishToQueue(queue string, msg *Message, headers map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.ch.Publish("", queue, false, false, amqp.Publishing{
+		Headers:      toTable(headers),
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+	// only publishes that were sent are numbered
+	c.tag++
+
+	timeout := time.After(confirmTimeout)
+	for {
+		select {
+		case confirm, ok := <-c.confirms:
+			if !ok {
+				return errChannelClosed
+			} else if confirm.DeliveryTag != c.tag {
+				// confirmations arrive in order, this is for an earlier publish that timed out
+				continue
+			} else if !confirm.Ack {
+				return ErrNacked
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for the broker to confirm the publish to %s", queue)
+		}
+	}
+}
+
+func declareBinding(ch *amqp.Channel, binding Binding) error {
+	if err := declareExchange(ch, binding.Exchange); err != nil {
+		return err
+	}
+
+	queue, err := ch.QueueDeclare(binding.Queue, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("error creating the queue: %w", err)
+	}
+
+	for _, routingKey := range binding.RoutingKeys {
+		err = ch.QueueBind(queue.Name, routingKey, binding.Exchange, false, nil)
+		if err != nil {
+			return fmt.Errorf("error binding queue to exchange: %w", err)
+		}
+	}
+
+	if _, err := ch.QueueDeclare(DeadLetterQueue(binding.Queue), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("error creating the dead-letter queue: %w", err)
+	}
+
+	// each delay has its own queue, once a message expires it's dead-lettered back onto the original queue.
+	// Including the delay in the name means changing the policy declares new queues rather than conflicting with the old
+	for _, delay := range binding.Retry.delays() {
+		_, err := ch.QueueDeclare(retryQueue(binding.Queue, delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": binding.Queue,
+		})
+		if err != nil {
+			return fmt.Errorf("error creating the retry queue: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func declareExchange(ch *amqp.Channel, exchange string) error {
+	if err := ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("error creating the exchange: %w", err)
+	}
+
+	return nil
+}
+
+func toTable(headers map[string]string) amqp.Table {
+	table := make(amqp.Table, len(headers))
+	for key, value := range headers {
+		table[key] = value
+	}
+
+	return table
+}
+
+// fromDelivery converts the delivery, restoring where it was originally published when it comes from a retry queue
+func fromDelivery(delivery amqp.Delivery) *Message {
+	msg := &Message{
+		ID:          delivery.MessageId,
+		ContentType: delivery.ContentType,
+		Headers:     fromTable(delivery.Headers),
+		Timestamp:   delivery.Timestamp,
+		Body:        delivery.Body,
+		Exchange:    delivery.Exchange,
+		RoutingKey:  delivery.RoutingKey,
+	}
+
+	restoreFailure(msg)
+
+	return msg
+}
+
+func fromTable(table amqp.Table) map[string]string {
+	headers := make(map[string]string, len(table))
+	for key, value := range table {
+		if s, ok := value.(string); ok {
+			headers[key] = s
+		}
+	}
+
+	return headers
+}
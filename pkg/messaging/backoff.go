@@ -0,0 +1,41 @@
+package messaging
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially increasing delays with jitter, capped at Max
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Step returns the exponential delay before the given attempt, counting from zero, without jitter
+func (b Backoff) Step(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 0; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+
+	if delay > b.Max {
+		delay = b.Max
+	}
+
+	return delay
+}
+
+// Delay returns how long to wait before the given attempt, counting from zero.
+// The delay is picked at random from the upper half of the exponential step so that clients spread out
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Step(attempt)
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+var reconnectBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second}
@@ -0,0 +1,172 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+// AMQPConnection keeps a connection to RabbitMQ open, redialling with backoff whenever it's lost.
+// Publishers and subscribers open their channels through it and re-declare their topology after reconnecting
+type AMQPConnection struct {
+	url    string
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	// ready is closed whilst conn is usable, it's replaced when the connection is lost
+	ready  chan struct{}
+	closed bool
+	done   chan struct{}
+}
+
+// DialAMQP connects to the broker, retrying with backoff until it succeeds or the context is done
+func DialAMQP(ctx context.Context, url string, logger *zap.Logger) (*AMQPConnection, error) {
+	c := &AMQPConnection{
+		url:    url,
+		logger: logger,
+		ready:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.setConnection(conn)
+	go c.watch(conn)
+
+	return c, nil
+}
+
+func (c *AMQPConnection) dial(ctx context.Context) (*amqp.Connection, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := amqp.Dial(c.url)
+		if err == nil {
+			return conn, nil
+		}
+
+		delay := reconnectBackoff.Delay(attempt)
+		c.logger.Warn("error connecting to rabbitmq, retrying", zap.Error(err), zap.Duration("delay", delay))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, ErrClosed
+		case <-time.After(delay):
+		}
+	}
+}
+
+// watch redials every time the connection is lost until the connection is closed
+func (c *AMQPConnection) watch(conn *amqp.Connection) {
+	for {
+		connErr := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+
+		c.logger.Warn("rabbitmq connection lost, reconnecting", zap.Error(connErr))
+		connectionLost.Inc()
+
+		var err error
+		if conn, err = c.dial(context.Background()); err != nil {
+			// only fails once the connection has been closed
+			return
+		}
+
+		c.logger.Info("reconnected to rabbitmq")
+		c.setConnection(conn)
+	}
+}
+
+func (c *AMQPConnection) setConnection(conn *amqp.Connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		conn.Close()
+		return
+	}
+
+	c.conn = conn
+	close(c.ready)
+}
+
+// Connected reports whether the connection is currently usable
+func (c *AMQPConnection) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.ready:
+		return !c.closed
+	default:
+		return false
+	}
+}
+
+// Channel opens a channel, waiting for the connection to be re-established if it has been lost
+func (c *AMQPConnection) Channel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		c.mu.Lock()
+		closed, ready, conn := c.closed, c.ready, c.conn
+		c.mu.Unlock()
+
+		if closed {
+			return nil, ErrClosed
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, ErrClosed
+		}
+
+		ch, err := conn.Channel()
+		if err == amqp.ErrClosed {
+			// lost between becoming ready and opening the channel, wait for the next connection
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		return ch, err
+	}
+}
+
+func (c *AMQPConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	close(c.done)
+
+	if c.conn != nil {
+		// it may already have been lost whilst reconnecting
+		if err := c.conn.Close(); err != nil && err != amqp.ErrClosed {
+			return err
+		}
+	}
+
+	return nil
+}
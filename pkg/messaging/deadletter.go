@@ -0,0 +1,109 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DeadLetter is a message that ran out of attempts or failed permanently
+type DeadLetter struct {
+	*Message
+	Error    string
+	FailedAt time.Time
+}
+
+// ListDeadLetters returns up to limit messages from the queue's dead-letter queue, leaving them in place
+func ListDeadLetters(ctx context.Context, conn *AMQPConnection, queue string, limit int) ([]*DeadLetter, error) {
+	ch, err := conn.Channel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// closing the channel hands every message we haven't acknowledged back to the queue
+	defer ch.Close()
+
+	letters := make([]*DeadLetter, 0)
+	for len(letters) < limit {
+		delivery, ok, err := ch.Get(DeadLetterQueue(queue), false)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the dead-letter queue: %w", err)
+		} else if !ok {
+			break
+		}
+
+		msg := fromDelivery(delivery)
+		failedAt, _ := time.Parse(time.RFC3339, msg.Headers[headerFailedAt])
+
+		letters = append(letters, &DeadLetter{
+			Message:  msg,
+			Error:    msg.Headers[headerError],
+			FailedAt: failedAt,
+		})
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetters moves messages from the queue's dead-letter queue back onto the queue with their attempts reset.
+// Only the messages with the given ids are replayed, or all of them when none are given
+func ReplayDeadLetters(ctx context.Context, conn *AMQPConnection, queue string, ids []string) (int, error) {
+	ch, err := conn.Channel(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// closing the channel hands every message we haven't acknowledged back to the queue
+	defer ch.Close()
+
+	replays, err := newConfirmingChannel(ch)
+	if err != nil {
+		return 0, err
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	// only look at what's there now, a replayed message that fails again mustn't be picked up a second time
+	dead, err := ch.QueueInspect(DeadLetterQueue(queue))
+	if err != nil {
+		return 0, fmt.Errorf("error inspecting the dead-letter queue: %w", err)
+	}
+
+	replayed := 0
+	for i := 0; i < dead.Messages; i++ {
+		delivery, ok, err := ch.Get(DeadLetterQueue(queue), false)
+		if err != nil {
+			return replayed, fmt.Errorf("error reading the dead-letter queue: %w", err)
+		} else if !ok {
+			break
+		}
+
+		if len(wanted) > 0 && !wanted[delivery.MessageId] {
+			continue
+		}
+
+		msg := fromDelivery(delivery)
+
+		// the original exchange and routing key are kept so the handler sees the message as it was first published
+		headers := make(map[string]string, len(msg.Headers))
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
+		delete(headers, headerAttempts)
+		delete(headers, headerDeferrals)
+		delete(headers, headerError)
+		delete(headers, headerFailedAt)
+
+		if err := replays.publishToQueue(queue, msg, headers); err != nil {
+			return replayed, fmt.Errorf("error replaying message %s: %w", msg.ID, err)
+		}
+
+		if err := delivery.Ack(false); err != nil {
+			return replayed, fmt.Errorf("error removing message %s from the dead-letter queue: %w", msg.ID, err)
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
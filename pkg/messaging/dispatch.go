@@ -0,0 +1,93 @@
+package messaging
+
+import (
+	"sync"
+)
+
+// dispatcher runs jobs on a fixed pool of workers.
+// Jobs sharing a key run one at a time in the order they were dispatched, the rest run in any order
+type dispatcher struct {
+	jobs chan dispatchJob
+	wg   sync.WaitGroup
+
+	mu sync.Mutex
+	// active holds the keys with a job running, along with the jobs queued behind it
+	active map[string][]func()
+}
+
+type dispatchJob struct {
+	key string
+	run func()
+}
+
+func newDispatcher(workers int) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &dispatcher{
+		jobs:   make(chan dispatchJob),
+		active: make(map[string][]func()),
+	}
+
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+
+	return d
+}
+
+// dispatch waits for a free worker to run the job,
+// unless a job with the same key is running in which case it's queued behind it and dispatch returns straight away
+func (d *dispatcher) dispatch(key string, run func()) {
+	if key != "" {
+		d.mu.Lock()
+		if waiting, ok := d.active[key]; ok {
+			d.active[key] = append(waiting, run)
+			d.mu.Unlock()
+			return
+		}
+		d.active[key] = nil
+		d.mu.Unlock()
+	}
+
+	d.jobs <- dispatchJob{key: key, run: run}
+}
+
+// close waits for every dispatched job to finish, nothing may be dispatched afterwards
+func (d *dispatcher) close() {
+	close(d.jobs)
+	d.wg.Wait()
+}
+
+func (d *dispatcher) worker() {
+	defer d.wg.Done()
+
+	for job := range d.jobs {
+		// the worker keeps the key until nothing is queued behind it so the jobs stay in order
+		for run := job.run; run != nil; run = d.next(job.key) {
+			run()
+		}
+	}
+}
+
+// next returns the job queued behind the key, releasing the key when there are none
+func (d *dispatcher) next(key string) func() {
+	if key == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	waiting := d.active[key]
+	if len(waiting) == 0 {
+		delete(d.active, key)
+		return nil
+	}
+
+	d.active[key] = waiting[1:]
+
+	return waiting[0]
+}
@@ -0,0 +1,265 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const memorySystem = "in-memory"
+
+// MemoryBus is an in-process Publisher and Subscriber routing messages like a topic exchange.
+// Queues hold their messages until consumed, so it's suited to running several services in one process
+type MemoryBus struct {
+	mu       sync.Mutex
+	queues   map[string]*memoryQueue
+	bindings map[string][]memoryBinding
+	closed   bool
+	done     chan struct{}
+}
+
+var (
+	_ Publisher  = (*MemoryBus)(nil)
+	_ Subscriber = (*MemoryBus)(nil)
+)
+
+type memoryBinding struct {
+	pattern string
+	queue   *memoryQueue
+}
+
+type memoryQueue struct {
+	mu       sync.Mutex
+	messages []*Message
+	// ready is signalled when messages are added, consumers pass it on whilst any remain
+	ready chan struct{}
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		queues:   make(map[string]*memoryQueue),
+		bindings: make(map[string][]memoryBinding),
+		done:     make(chan struct{}),
+	}
+}
+
+// Publish copies the message to every queue bound to the exchange with a matching pattern,
+// like a broker it's dropped when there are none
+func (b *MemoryBus) Publish(ctx context.Context, exchange, routingKey string, msg Message) (err error) {
+	prepare(&msg)
+
+	_, span := startPublishSpan(ctx, memorySystem, exchange, routingKey, &msg)
+	defer func() { endSpan(span, err) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	// a queue bound with several matching patterns still receives the message once
+	delivered := make(map[*memoryQueue]bool)
+	for _, binding := range b.bindings[exchange] {
+		if delivered[binding.queue] || !MatchTopic(binding.pattern, routingKey) {
+			continue
+		}
+
+		binding.queue.push(copyMessage(&msg, exchange, routingKey))
+		delivered[binding.queue] = true
+	}
+
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(ctx context.Context, binding Binding, handler Handler) error {
+	queue, err := b.declare(binding)
+	if err != nil {
+		return err
+	}
+
+	concurrency := binding.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	pool := newDispatcher(concurrency)
+	defer pool.close()
+
+	// like a prefetch, only take as many messages off the queue as there are workers to handle them
+	slots := make(chan struct{}, concurrency)
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+
+		msg, err := queue.pop(ctx, b.done)
+		if err != nil {
+			return err
+		} else if msg == nil {
+			return nil
+		}
+
+		pool.dispatch(binding.key(msg), func() {
+			defer func() { <-slots }()
+
+			if err := handle(memorySystem, binding, msg, handler); err != nil {
+				b.fail(binding, msg, err)
+			}
+		})
+	}
+}
+
+// fail puts the message back on the queue once the retry delay has passed,
+// or on the dead-letter queue once it has run out of attempts
+func (b *MemoryBus) fail(binding Binding, msg *Message, handlerErr error) {
+	f := binding.Retry.next(msg, handlerErr)
+
+	failed := copyMessage(msg, msg.Exchange, msg.RoutingKey)
+	failed.Headers = failureHeaders(msg, f, handlerErr)
+	failed.Attempts = f.attempts
+	failed.Deferrals = f.deferrals
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if f.deadLetter {
+		messagesDeadLettered.WithLabelValues(binding.Queue).Inc()
+		b.queue(DeadLetterQueue(binding.Queue)).push(failed)
+		return
+	}
+
+	if f.deferred {
+		messagesDeferred.WithLabelValues(binding.Queue).Inc()
+	} else {
+		messagesRetried.WithLabelValues(binding.Queue).Inc()
+	}
+
+	queue := b.queues[binding.Queue]
+	time.AfterFunc(f.delay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if !b.closed {
+			queue.push(failed)
+		}
+	})
+}
+
+// Close rejects further messages and stops every subscriber
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+
+	return nil
+}
+
+// declare creates the queue if needed and binds it to the exchange with any patterns it doesn't have yet
+func (b *MemoryBus) declare(binding Binding) (*memoryQueue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	queue := b.queue(binding.Queue)
+
+	for _, pattern := range binding.RoutingKeys {
+		if !b.isBound(binding.Exchange, pattern, queue) {
+			b.bindings[binding.Exchange] = append(b.bindings[binding.Exchange], memoryBinding{pattern: pattern, queue: queue})
+		}
+	}
+
+	return queue, nil
+}
+
+// queue returns the named queue, creating it if needed. It must be called with the lock held
+func (b *MemoryBus) queue(name string) *memoryQueue {
+	queue, ok := b.queues[name]
+	if !ok {
+		queue = &memoryQueue{ready: make(chan struct{}, 1)}
+		b.queues[name] = queue
+	}
+
+	return queue
+}
+
+// isBound must be called with the lock held
+func (b *MemoryBus) isBound(exchange, pattern string, queue *memoryQueue) bool {
+	for _, binding := range b.bindings[exchange] {
+		if binding.pattern == pattern && binding.queue == queue {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q *memoryQueue) push(msg *Message) {
+	q.mu.Lock()
+	q.messages = append(q.messages, msg)
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// pop waits for the next message, returning nil once the context is done or ErrClosed once the bus is closed
+func (q *memoryQueue) pop(ctx context.Context, done <-chan struct{}) (*Message, error) {
+	for {
+		q.mu.Lock()
+		if len(q.messages) > 0 {
+			msg := q.messages[0]
+			q.messages = q.messages[1:]
+			remaining := len(q.messages)
+			q.mu.Unlock()
+
+			if remaining > 0 {
+				q.signal()
+			}
+
+			return msg, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, nil
+		case <-done:
+			return nil, ErrClosed
+		}
+	}
+}
+
+func (q *memoryQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func copyMessage(msg *Message, exchange, routingKey string) *Message {
+	headers := make(map[string]string, len(msg.Headers))
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	return &Message{
+		ID:          msg.ID,
+		ContentType: msg.ContentType,
+		Headers:     headers,
+		Timestamp:   msg.Timestamp,
+		Body:        append([]byte(nil), msg.Body...),
+		Exchange:    exchange,
+		RoutingKey:  routingKey,
+	}
+}
@@ -0,0 +1,328 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		routingKey string
+		want       bool
+	}{
+		{"event.created", "event.created", true},
+		{"event.created", "event.updated", false},
+		{"event.*", "event.created", true},
+		{"event.*", "event", false},
+		{"event.*", "event.created.late", false},
+		{"*.created", "event.created", true},
+		{"#", "event.created", true},
+		{"#", "event", true},
+		{"event.#", "event", true},
+		{"event.#", "event.created.late", true},
+		{"#.deleted", "event.deleted", true},
+		{"#.deleted", "deleted", true},
+		{"#.deleted", "event.deleted.late", false},
+		{"event.#.late", "event.late", true},
+		{"event.#.late", "event.created.very.late", true},
+		{"*.#", "event", true},
+		{"*.*", "event", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchTopic(tt.pattern, tt.routingKey); got != tt.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", tt.pattern, tt.routingKey, got, tt.want)
+		}
+	}
+}
+
+// collector passes on every message delivered to a subscriber
+type collector struct {
+	received chan *Message
+}
+
+func newCollector() *collector {
+	return &collector{received: make(chan *Message, 100)}
+}
+
+func (c *collector) handle(ctx context.Context, msg *Message) error {
+	c.received <- msg
+
+	return nil
+}
+
+// wait returns the next delivered message, failing the test if none arrives in time
+func (c *collector) wait(t *testing.T) *Message {
+	t.Helper()
+
+	select {
+	case msg := <-c.received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+// none fails the test if a message is delivered within a short time
+func (c *collector) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case msg := <-c.received:
+		t.Fatalf("unexpected message %s with routing key %s", msg.Body, msg.RoutingKey)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// newTestBus returns a bus that's closed once the test's subscribers have stopped
+func newTestBus(t *testing.T) *MemoryBus {
+	t.Helper()
+
+	bus := NewMemoryBus()
+	t.Cleanup(func() { bus.Close() })
+
+	return bus
+}
+
+// subscribe consumes the binding until the test ends
+func subscribe(t *testing.T, bus *MemoryBus, binding Binding, handler Handler) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- bus.Subscribe(ctx, binding, handler) }()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error from subscriber: %v", err)
+		}
+	})
+}
+
+func publish(t *testing.T, bus *MemoryBus, exchange, routingKey, body string) {
+	t.Helper()
+
+	if err := bus.Publish(context.Background(), exchange, routingKey, Message{Body: []byte(body)}); err != nil {
+		t.Fatalf("publishing %s: %v", routingKey, err)
+	}
+}
+
+func TestMemoryBusRoutesByTopic(t *testing.T) {
+	bus := newTestBus(t)
+
+	// the queues are declared before publishing, messages published to an exchange without them are dropped
+	publish(t, bus, "calendar", "event.created", "dropped")
+
+	created := newCollector()
+	subscribe(t, bus, Binding{Exchange: "calendar", Queue: "created", RoutingKeys: []string{"*.created"}}, created.handle)
+
+	// bound with overlapping patterns, it should still get each message once
+	all := newCollector()
+	subscribe(t, bus, Binding{Exchange: "calendar", Queue: "all", RoutingKeys: []string{"#", "event.*"}}, all.handle)
+
+	deleted := newCollector()
+	subscribe(t, bus, Binding{Exchange: "calendar", Queue: "deleted", RoutingKeys: []string{"#.deleted"}}, deleted.handle)
+
+	// the subscribers declare their queues asynchronously
+	waitForBindings(t, bus, "calendar", 4)
+
+	publish(t, bus, "calendar", "event.created", "1")
+	publish(t, bus, "calendar", "event.deleted", "2")
+	publish(t, bus, "calendar", "event.updated.late", "3")
+	publish(t, bus, "weather", "event.created", "4")
+
+	if msg := created.wait(t); string(msg.Body) != "1" || msg.Exchange != "calendar" || msg.RoutingKey != "event.created" {
+		t.Fatalf("unexpected message %s from %s with routing key %s", msg.Body, msg.Exchange, msg.RoutingKey)
+	}
+	created.none(t)
+
+	if msg := deleted.wait(t); string(msg.Body) != "2" {
+		t.Fatalf("expected the deleted message, got %s", msg.Body)
+	}
+	deleted.none(t)
+
+	var bodies []string
+	for i := 0; i < 3; i++ {
+		bodies = append(bodies, string(all.wait(t).Body))
+	}
+	all.none(t)
+
+	sort.Strings(bodies)
+	if bodies[0] != "1" || bodies[1] != "2" || bodies[2] != "3" {
+		t.Fatalf("expected every calendar message once, got %v", bodies)
+	}
+}
+
+// waitForBindings waits until the exchange has the number of bindings
+func waitForBindings(t *testing.T, bus *MemoryBus, exchange string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		bus.mu.Lock()
+		bound := len(bus.bindings[exchange])
+		bus.mu.Unlock()
+
+		if bound >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %d bindings to %s", n, exchange)
+}
+
+func TestMemoryBusRetriesFailedMessages(t *testing.T) {
+	bus := newTestBus(t)
+
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}}
+
+	var mu sync.Mutex
+	var attempts []int
+	handled := newCollector()
+	subscribe(t, bus, Binding{Exchange: "calendar", Queue: "flaky", RoutingKeys: []string{"#"}, Retry: policy}, func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		attempts = append(attempts, msg.Attempts)
+		failing := len(attempts) < 3
+		mu.Unlock()
+
+		if failing {
+			return errors.New("flaky")
+		}
+		return handled.handle(ctx, msg)
+	})
+	waitForBindings(t, bus, "calendar", 1)
+
+	publish(t, bus, "calendar", "event.created", "1")
+
+	msg := handled.wait(t)
+	if string(msg.Body) != "1" || msg.RoutingKey != "event.created" {
+		t.Fatalf("unexpected message %s with routing key %s", msg.Body, msg.RoutingKey)
+	}
+	if msg.Headers[headerError] != "flaky" || msg.Headers[headerAttempts] != "2" {
+		t.Fatalf("expected the failure to be recorded in the headers, got %v", msg.Headers)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(attempts) != 3 || attempts[0] != 0 || attempts[1] != 1 || attempts[2] != 2 {
+		t.Fatalf("expected the attempts to count up from zero, got %v", attempts)
+	}
+}
+
+func TestMemoryBusDeadLetters(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}}
+
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{name: "after running out of attempts", err: errors.New("broken"), attempts: 3},
+		{name: "permanent failures straight away", err: Permanent(errors.New("malformed")), attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := newTestBus(t)
+
+			var mu sync.Mutex
+			handled := 0
+			subscribe(t, bus, Binding{Exchange: "calendar", Queue: "broken", RoutingKeys: []string{"#"}, Retry: policy}, func(ctx context.Context, msg *Message) error {
+				mu.Lock()
+				handled++
+				mu.Unlock()
+
+				return tt.err
+			})
+
+			dead := newCollector()
+			subscribe(t, bus, Binding{Queue: DeadLetterQueue("broken")}, dead.handle)
+			waitForBindings(t, bus, "calendar", 1)
+
+			publish(t, bus, "calendar", "event.created", "1")
+
+			msg := dead.wait(t)
+			if string(msg.Body) != "1" || msg.Attempts != tt.attempts {
+				t.Fatalf("expected the message after %d attempts, got %s after %d", tt.attempts, msg.Body, msg.Attempts)
+			}
+			if msg.Headers[headerError] != tt.err.Error() || msg.Headers[headerRoutingKey] != "event.created" || msg.Headers[headerExchange] != "calendar" {
+				t.Fatalf("expected the failure to be recorded in the headers, got %v", msg.Headers)
+			}
+			dead.none(t)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if handled != tt.attempts {
+				t.Fatalf("expected the message to be handled %d times, got %d", tt.attempts, handled)
+			}
+		})
+	}
+}
+
+func TestMemoryBusDefersWithoutUsingAttempts(t *testing.T) {
+	bus := newTestBus(t)
+
+	policy := RetryPolicy{MaxAttempts: 2, Backoff: Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}}
+
+	var mu sync.Mutex
+	deferrals := 0
+	handled := newCollector()
+	subscribe(t, bus, Binding{Exchange: "calendar", Queue: "outage", RoutingKeys: []string{"#"}, Retry: policy}, func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// deferred more times than the policy has attempts
+		if deferrals < 4 {
+			deferrals++
+			return Defer(errors.New("circuit open"))
+		}
+		return handled.handle(ctx, msg)
+	})
+
+	dead := newCollector()
+	subscribe(t, bus, Binding{Queue: DeadLetterQueue("outage")}, dead.handle)
+	waitForBindings(t, bus, "calendar", 1)
+
+	publish(t, bus, "calendar", "event.created", "1")
+
+	msg := handled.wait(t)
+	if msg.Attempts != 0 || msg.Deferrals != 4 {
+		t.Fatalf("expected 4 deferrals and no attempts, got %d and %d", msg.Deferrals, msg.Attempts)
+	}
+	dead.none(t)
+}
+
+func TestMemoryBusClosed(t *testing.T) {
+	bus := NewMemoryBus()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.Subscribe(context.Background(), Binding{Exchange: "calendar", Queue: "closed", RoutingKeys: []string{"#"}}, newCollector().handle)
+	}()
+	waitForBindings(t, bus, "calendar", 1)
+
+	bus.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected the subscriber to stop with ErrClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscriber to stop")
+	}
+
+	if err := bus.Publish(context.Background(), "calendar", "event.created", Message{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected publishing to fail with ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Package messaging decouples services from the message broker.
+// Messages are published to topic exchanges and delivered to every queue bound with a matching pattern,
+// consumers of the same queue compete for its messages.
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrClosed is returned when publishing or subscribing after the bus has been closed
+var ErrClosed = errors.New("messaging: closed")
+
+type Message struct {
+	ID          string
+	ContentType string
+	// Headers carry metadata such as the trace context alongside the body
+	Headers   map[string]string
+	Timestamp time.Time
+	Body      []byte
+
+	// Exchange and RoutingKey are set on delivered messages
+	Exchange   string
+	RoutingKey string
+	// Attempts is how many times handling the message has failed before this delivery
+	Attempts int
+	// Deferrals is how many times the message has been deferred, which doesn't count towards its attempts
+	Deferrals int
+}
+
+// Publisher sends messages to topic exchanges, declaring them when needed
+type Publisher interface {
+	Publish(ctx context.Context, exchange, routingKey string, msg Message) error
+	Close() error
+}
+
+// Handler processes a delivered message, the context carries the trace context of the publisher
+type Handler func(ctx context.Context, msg *Message) error
+
+// Binding describes the queue to consume from and which messages are routed to it
+type Binding struct {
+	Exchange string
+	Queue    string
+	// RoutingKeys are topic patterns, * matches exactly one word and # matches zero or more
+	RoutingKeys []string
+	// Retry decides how messages the handler fails are retried before being dead-lettered
+	Retry RetryPolicy
+
+	// Concurrency is how many messages are handled at once, one when unset.
+	// It's also the prefetch so the broker never hands a consumer more than it can work on
+	Concurrency int
+	// Timeout bounds how long the handler has for each message, there's no limit when unset
+	Timeout time.Duration
+	// Key groups the messages that must be handled in the order they were delivered, such as those about the same entity.
+	// Messages with the same key are never handled concurrently, any message may be handled alongside any other when unset
+	Key func(msg *Message) string
+}
+
+// key returns the message's ordering key, if the binding has one
+func (b Binding) key(msg *Message) string {
+	if b.Key == nil {
+		return ""
+	}
+
+	return b.Key(msg)
+}
+
+// Subscriber delivers messages to handlers
+type Subscriber interface {
+	// Subscribe declares the binding and passes each message to the handler until the context is cancelled.
+	// Once cancelled no new messages are accepted and it returns after the in-flight messages have been handled.
+	// Messages the handler fails are retried according to the binding's policy, then moved to its dead-letter queue.
+	// Deferred failures are retried on the same schedule but never dead-lettered
+	Subscribe(ctx context.Context, binding Binding, handler Handler) error
+}
+
+// prepare fills in the fields a publisher is expected to set
+func prepare(msg *Message) {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+}
+
+// handle passes the message to the handler within a span continuing the publisher's trace.
+// Handling isn't tied to the subscriber's context so in-flight messages can finish during shutdown
+func handle(system string, binding Binding, msg *Message, handler Handler) (err error) {
+	ctx := context.Background()
+	if binding.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, binding.Timeout)
+		defer cancel()
+	}
+
+	ctx, span := startProcessSpan(ctx, system, msg)
+	defer func() { endSpan(span, err) }()
+
+	messagesInFlight.WithLabelValues(binding.Queue).Inc()
+	defer messagesInFlight.WithLabelValues(binding.Queue).Dec()
+
+	return handler(ctx, msg)
+}
+
+// MatchTopic reports whether the routing key matches the topic pattern using AMQP topic exchange rules
+func MatchTopic(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			// try every number of words, including none, for the rest of the pattern
+			for i := 0; i <= len(words); i++ {
+				if matchWords(pattern[1:], words[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case "*":
+			if len(words) == 0 {
+				return false
+			}
+
+		default:
+			if len(words) == 0 || words[0] != pattern[0] {
+				return false
+			}
+		}
+
+		pattern, words = pattern[1:], words[1:]
+	}
+
+	return len(words) == 0
+}
@@ -0,0 +1,41 @@
+package messaging
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var connectionLost = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "amqp_connection_lost_total",
+	Help: "Number of times the connection to the broker was lost.",
+})
+
+var publishesWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "amqp_publishes_waiting",
+	Help: "Number of publishes waiting for the broker to become available or to confirm them.",
+})
+
+var publishConfirms = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "amqp_publish_confirms_total",
+	Help: "Number of publishes by how the broker responded, partitioned by outcome.",
+}, []string{"outcome"})
+
+var messagesRetried = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "messages_retried_total",
+	Help: "Number of failed messages scheduled to be handled again, partitioned by queue.",
+}, []string{"queue"})
+
+var messagesDeferred = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "messages_deferred_total",
+	Help: "Number of failed messages held until a dependency recovers without using up an attempt, partitioned by queue.",
+}, []string{"queue"})
+
+var messagesDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "messages_dead_lettered_total",
+	Help: "Number of messages moved to a dead-letter queue, partitioned by queue.",
+}, []string{"queue"})
+
+var messagesInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "messages_in_flight",
+	Help: "Number of messages being handled, partitioned by queue.",
+}, []string{"queue"})
@@ -0,0 +1,197 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/config"
+)
+
+// headers used to carry a failed message's history through the retry and dead-letter queues
+const (
+	headerAttempts   = "x-attempts"
+	headerDeferrals  = "x-deferrals"
+	headerExchange   = "x-original-exchange"
+	headerRoutingKey = "x-original-routing-key"
+	headerError      = "x-error"
+	headerFailedAt   = "x-failed-at"
+)
+
+// confirmTimeout limits how long to wait for the broker to confirm a failed message has been moved
+const confirmTimeout = 10 * time.Second
+
+// RetryPolicy retries failed messages with exponential backoff, the zero value dead-letters them straight away
+type RetryPolicy struct {
+	// MaxAttempts is how many times a message is handled before it's dead-lettered
+	MaxAttempts int
+	Backoff     Backoff
+}
+
+// RetryPolicyFromConfig builds the retry policy from the service's config
+func RetryPolicyFromConfig(cfg config.Retry) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: cfg.MaxAttempts,
+		Backoff:     Backoff{Initial: cfg.InitialDelay, Max: cfg.MaxDelay},
+	}
+}
+
+// delay returns how long to wait before handling a message again, or false when it should be dead-lettered instead
+func (p RetryPolicy) delay(attempts int) (time.Duration, bool) {
+	if attempts >= p.MaxAttempts {
+		return 0, false
+	}
+
+	return p.Backoff.Step(attempts - 1), true
+}
+
+// deferral returns how long to hold a message deferred for the given time, counting from one.
+// It backs off like a retry up to the longest delay the policy has a queue for, or returns false when the policy has none
+func (p RetryPolicy) deferral(deferrals int) (time.Duration, bool) {
+	delays := p.delays()
+	if len(delays) == 0 {
+		return 0, false
+	}
+
+	if deferrals > len(delays) {
+		deferrals = len(delays)
+	}
+
+	return delays[deferrals-1], true
+}
+
+// failure is what happens to a message the handler failed
+type failure struct {
+	attempts  int
+	deferrals int
+	delay     time.Duration
+	// deferred messages are held without using up an attempt
+	deferred   bool
+	deadLetter bool
+}
+
+// next decides whether the failed message is deferred, retried or dead-lettered
+func (p RetryPolicy) next(msg *Message, handlerErr error) failure {
+	if IsDeferred(handlerErr) {
+		if delay, ok := p.deferral(msg.Deferrals + 1); ok {
+			return failure{attempts: msg.Attempts, deferrals: msg.Deferrals + 1, delay: delay, deferred: true}
+		}
+	}
+
+	attempts := msg.Attempts + 1
+	delay, retry := p.delay(attempts)
+
+	return failure{
+		attempts:   attempts,
+		deferrals:  msg.Deferrals,
+		delay:      delay,
+		deadLetter: !retry || IsPermanent(handlerErr),
+	}
+}
+
+// delays returns every distinct delay the policy can use
+func (p RetryPolicy) delays() []time.Duration {
+	var delays []time.Duration
+	for attempts := 1; attempts < p.MaxAttempts; attempts++ {
+		delay, _ := p.delay(attempts)
+		if len(delays) == 0 || delays[len(delays)-1] != delay {
+			delays = append(delays, delay)
+		}
+	}
+
+	return delays
+}
+
+// DeadLetterQueue returns the name of the queue holding the messages dead-lettered from the queue
+func DeadLetterQueue(queue string) string {
+	return queue + ".dead"
+}
+
+// retryQueue returns the name of the queue holding messages from the queue until the delay has passed
+func retryQueue(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queue, delay)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as one retrying won't fix, such as a malformed body, so the message is dead-lettered straight away
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether the error was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+type deferredError struct {
+	err error
+}
+
+func (e *deferredError) Error() string { return e.err.Error() }
+func (e *deferredError) Unwrap() error { return e.err }
+
+// Defer marks a handler error as caused by a dependency that's known to be down, such as one behind an open circuit breaker.
+// The message is held on the retry queues without using up an attempt so it waits out the outage rather than being dead-lettered
+func Defer(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &deferredError{err: err}
+}
+
+// IsDeferred reports whether the error was marked with Defer
+func IsDeferred(err error) bool {
+	var deferred *deferredError
+	return errors.As(err, &deferred)
+}
+
+// failureHeaders records the failure in a copy of the message headers
+func failureHeaders(msg *Message, f failure, err error) map[string]string {
+	headers := make(map[string]string, len(msg.Headers)+6)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+
+	headers[headerAttempts] = strconv.Itoa(f.attempts)
+	if f.deferrals > 0 {
+		headers[headerDeferrals] = strconv.Itoa(f.deferrals)
+	}
+	headers[headerExchange] = msg.Exchange
+	headers[headerRoutingKey] = msg.RoutingKey
+	headers[headerError] = err.Error()
+	headers[headerFailedAt] = time.Now().UTC().Format(time.RFC3339)
+
+	return headers
+}
+
+// restoreFailure reads back the history recorded by failureHeaders
+func restoreFailure(msg *Message) {
+	if attempts, err := strconv.Atoi(msg.Headers[headerAttempts]); err == nil {
+		msg.Attempts = attempts
+	}
+
+	if deferrals, err := strconv.Atoi(msg.Headers[headerDeferrals]); err == nil {
+		msg.Deferrals = deferrals
+	}
+
+	if exchange, ok := msg.Headers[headerExchange]; ok {
+		msg.Exchange = exchange
+	}
+
+	if routingKey, ok := msg.Headers[headerRoutingKey]; ok {
+		msg.RoutingKey = routingKey
+	}
+}
@@ -0,0 +1,63 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/alexdunne/not-so-smart-cal/pkg/messaging")
+
+// startPublishSpan starts a producer span and writes its context into the message headers
+func startPublishSpan(ctx context.Context, system, exchange, routingKey string, msg *Message) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(
+		ctx,
+		exchange+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingDestinationKey.String(exchange),
+			semconv.MessagingMessageIDKey.String(msg.ID),
+			attribute.String("messaging.routing_key", routingKey),
+		),
+	)
+
+	msg.Headers = tracing.InjectHeaders(ctx, msg.Headers)
+
+	return ctx, span
+}
+
+// startProcessSpan continues the trace started by the publisher with a consumer span
+func startProcessSpan(ctx context.Context, system string, msg *Message) (context.Context, trace.Span) {
+	ctx = tracing.ExtractHeaders(ctx, msg.Headers)
+
+	return tracer.Start(
+		ctx,
+		msg.Exchange+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingDestinationKey.String(msg.Exchange),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingMessageIDKey.String(msg.ID),
+			attribute.String("messaging.routing_key", msg.RoutingKey),
+		),
+	)
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Check reports whether a dependency can currently be used
+type Check interface {
+	Name() string
+	// Ready returns why the dependency can't be used, or nil when it can
+	Ready() error
+}
+
+// ReadyHandler responds with 200 when every check passes, otherwise with 503 listing the checks that failed
+func ReadyHandler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var failures []string
+		for _, check := range checks {
+			if err := check.Ready(); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", check.Name(), err))
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if len(failures) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, strings.Join(failures, "\n"))
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,109 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/meeting"
+)
+
+// CalendarSource is the source of the calendar service's messages
+const CalendarSource = "/calendar"
+
+// types of the messages published when events change, the subject is the event id
+const (
+	EventCreated = "notsosmartcal.calendar.event.created"
+	EventUpdated = "notsosmartcal.calendar.event.updated"
+	EventDeleted = "notsosmartcal.calendar.event.deleted"
+)
+
+// CalendarEventVersion is the version of CalendarEvent published by this build
+const CalendarEventVersion = 1
+
+// CalendarEvent is the data of the event change messages, it holds the event after the change or as it was before being deleted
+type CalendarEvent struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Location  string    `json:"location"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Venue is the location in a structured form, it's nil when the event has no location
+	Venue *Venue `json:"venue,omitempty"`
+}
+
+// kinds of venue
+const (
+	VenuePhysical = "physical"
+	VenueVirtual  = "virtual"
+)
+
+// Venue is either somewhere physical or a virtual meeting
+type Venue struct {
+	Kind string `json:"kind"`
+	// Address is a physical location as it was typed, Place its coordinates when the user picked one of the suggestions
+	Address string `json:"address,omitempty"`
+	Place   *Place `json:"place,omitempty"`
+	// URL and Provider describe a virtual meeting, the URL is empty when the location only names the provider
+	URL      string `json:"url,omitempty"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// Place is a location picked from the geocoder's suggestions
+type Place struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Country   string  `json:"country"`
+	State     string  `json:"state,omitempty"`
+}
+
+// NewCalendarEvent wraps the event in an envelope of the given type
+func NewCalendarEvent(eventType string, event *CalendarEvent) (*Envelope, error) {
+	return New(CalendarSource, eventType, event.ID, CalendarEventVersion, event)
+}
+
+// DecodeCalendarEvent reads the data of an event change message, upgrading older versions
+func DecodeCalendarEvent(e *Envelope) (*CalendarEvent, error) {
+	var event CalendarEvent
+
+	switch e.SchemaVersion {
+	case 1:
+		if err := decodeData(e, &event, EventCreated, EventUpdated, EventDeleted); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, e.Type, e.SchemaVersion)
+	}
+
+	if event.Venue == nil && strings.TrimSpace(event.Location) != "" {
+		// messages sent before venues were introduced only have the location as it was typed
+		event.Venue = &Venue{Kind: VenuePhysical, Address: event.Location}
+		if m, ok := meeting.Detect(event.Location); ok {
+			event.Venue = &Venue{Kind: VenueVirtual, URL: m.URL, Provider: m.Provider}
+		}
+	}
+
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// Validate checks the fields consumers rely on
+func (e *CalendarEvent) Validate() error {
+	switch {
+	case e.ID == "":
+		return fmt.Errorf("%w: event is missing its id", ErrInvalid)
+	case e.StartsAt.IsZero():
+		return fmt.Errorf("%w: event %s is missing startsAt", ErrInvalid, e.ID)
+	case e.EndsAt.IsZero():
+		return fmt.Errorf("%w: event %s is missing endsAt", ErrInvalid, e.ID)
+	case e.Venue != nil && e.Venue.Kind != VenuePhysical && e.Venue.Kind != VenueVirtual:
+		return fmt.Errorf("%w: event %s has an unknown kind of venue %q", ErrInvalid, e.ID, e.Venue.Kind)
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+)
+
+var (
+	startsAt = time.Date(2021, 6, 14, 9, 0, 0, 0, time.UTC)
+	endsAt   = time.Date(2021, 6, 14, 14, 0, 0, 0, time.UTC)
+)
+
+// decodeCalendarMessage reads the event from a delivered message the way the worker does
+func decodeCalendarMessage(t *testing.T, msg *messaging.Message) (*Envelope, *CalendarEvent, error) {
+	t.Helper()
+
+	envelope, err := Decode(msg)
+	if err != nil {
+		t.Fatalf("decoding envelope: %v", err)
+	}
+
+	event, err := DecodeCalendarEvent(envelope)
+
+	return envelope, event, err
+}
+
+func TestDecodeCalendarEventBeforeEnvelopes(t *testing.T) {
+	// the calendar used to publish its model.Event as it was
+	body := `{
+		"id": "3f1c0e4e-8d6b-4a43-9d3c-2f8c1a8f0c11",
+		"title": "Picnic",
+		"location": "Hyde Park",
+		"startsAt": "2021-06-14T09:00:00Z",
+		"endsAt": "2021-06-14T14:00:00Z",
+		"createdAt": "2021-06-01T09:00:00Z"
+	}`
+
+	envelope, event, err := decodeCalendarMessage(t, &messaging.Message{
+		ID:          "legacy-1",
+		ContentType: "application/json",
+		Exchange:    "calendar",
+		RoutingKey:  "event.updated",
+		Timestamp:   startsAt,
+		Body:        []byte(body),
+	})
+	if err != nil {
+		t.Fatalf("decoding event: %v", err)
+	}
+
+	if envelope.Type != EventUpdated || envelope.SchemaVersion != 1 || envelope.Subject != "" || envelope.ID != "legacy-1" {
+		t.Fatalf("expected a version 1 %s envelope, got %+v", EventUpdated, envelope)
+	}
+
+	if event.ID != "3f1c0e4e-8d6b-4a43-9d3c-2f8c1a8f0c11" || event.Title != "Picnic" || !event.StartsAt.Equal(startsAt) || !event.EndsAt.Equal(endsAt) {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Venue == nil || event.Venue.Kind != VenuePhysical || event.Venue.Address != "Hyde Park" || event.Venue.Place != nil {
+		t.Fatalf("expected a physical venue from the location, got %+v", event.Venue)
+	}
+}
+
+func TestDecodeCalendarEventBeforeEnvelopesWithLink(t *testing.T) {
+	// a link to somewhere other than a meeting provider leaves the venue physical, so the event still gets weather
+	body := `{
+		"id": "3f1c0e4e-8d6b-4a43-9d3c-2f8c1a8f0c11",
+		"title": "Open air cinema",
+		"location": "Hyde Park, London - tickets https://eventbrite.com/e/123",
+		"startsAt": "2021-06-14T09:00:00Z",
+		"endsAt": "2021-06-14T14:00:00Z",
+		"createdAt": "2021-06-01T09:00:00Z"
+	}`
+
+	_, event, err := decodeCalendarMessage(t, &messaging.Message{
+		ContentType: "application/json",
+		RoutingKey:  "event.created",
+		Body:        []byte(body),
+	})
+	if err != nil {
+		t.Fatalf("decoding event: %v", err)
+	}
+
+	if event.Venue == nil || event.Venue.Kind != VenuePhysical || event.Venue.Address != event.Location || event.Venue.URL != "" {
+		t.Fatalf("expected a physical venue from the location, got %+v", event.Venue)
+	}
+}
+
+func TestDecodeCalendarEventBeforeEnvelopesWithUnknownRoutingKey(t *testing.T) {
+	_, err := Decode(&messaging.Message{
+		ContentType: "application/json",
+		RoutingKey:  "event.archived",
+		Body:        []byte(`{"id": "1"}`),
+	})
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %v", err)
+	}
+}
+
+func TestDecodeCalendarEventWithoutVenue(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     *Venue
+	}{
+		{
+			name:     "address",
+			location: "Hyde Park",
+			want:     &Venue{Kind: VenuePhysical, Address: "Hyde Park"},
+		},
+		{
+			name:     "meeting link",
+			location: "https://zoom.us/j/123456789",
+			want:     &Venue{Kind: VenueVirtual, URL: "https://zoom.us/j/123456789", Provider: "zoom"},
+		},
+		{
+			name:     "address with a link",
+			location: "Hyde Park, London - tickets https://eventbrite.com/e/123",
+			want:     &Venue{Kind: VenuePhysical, Address: "Hyde Park, London - tickets https://eventbrite.com/e/123"},
+		},
+		{
+			name:     "meeting provider",
+			location: "Teams",
+			want:     &Venue{Kind: VenueVirtual, Provider: "teams"},
+		},
+		{
+			name:     "video call",
+			location: "Video call",
+			want:     &Venue{Kind: VenueVirtual, Provider: "other"},
+		},
+		{
+			name:     "no location",
+			location: "  ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a version 1 envelope published before venues were added to it
+			data, _ := json.Marshal(map[string]interface{}{
+				"id":        "event-1",
+				"title":     "Picnic",
+				"location":  tt.location,
+				"startsAt":  startsAt,
+				"endsAt":    endsAt,
+				"createdAt": startsAt,
+			})
+
+			envelope, err := New(CalendarSource, EventCreated, "event-1", 1, json.RawMessage(data))
+			if err != nil {
+				t.Fatalf("creating envelope: %v", err)
+			}
+			msg, err := envelope.Message()
+			if err != nil {
+				t.Fatalf("encoding envelope: %v", err)
+			}
+
+			_, event, err := decodeCalendarMessage(t, &msg)
+			if err != nil {
+				t.Fatalf("decoding event: %v", err)
+			}
+
+			switch {
+			case tt.want == nil && event.Venue != nil:
+				t.Fatalf("expected no venue, got %+v", event.Venue)
+			case tt.want != nil && (event.Venue == nil || *event.Venue != *tt.want):
+				t.Fatalf("expected venue %+v, got %+v", tt.want, event.Venue)
+			}
+		})
+	}
+}
+
+func TestDecodeCalendarEventKeepsVenue(t *testing.T) {
+	// the venue published is used as it is, even though the location alone would look virtual
+	place := &Place{Name: "Zoom HQ", Latitude: 37.3318, Longitude: -121.8916, Country: "US", State: "California"}
+
+	envelope, err := NewCalendarEvent(EventCreated, &CalendarEvent{
+		ID:       "event-1",
+		Title:    "Visit",
+		Location: "Zoom",
+		StartsAt: startsAt,
+		EndsAt:   endsAt,
+		Venue:    &Venue{Kind: VenuePhysical, Address: "Zoom", Place: place},
+	})
+	if err != nil {
+		t.Fatalf("creating envelope: %v", err)
+	}
+	msg, err := envelope.Message()
+	if err != nil {
+		t.Fatalf("encoding envelope: %v", err)
+	}
+
+	decoded, event, err := decodeCalendarMessage(t, &msg)
+	if err != nil {
+		t.Fatalf("decoding event: %v", err)
+	}
+
+	if decoded.Subject != "event-1" || decoded.SchemaVersion != CalendarEventVersion {
+		t.Fatalf("unexpected envelope %+v", decoded)
+	}
+	if event.Venue == nil || event.Venue.Kind != VenuePhysical || event.Venue.Place == nil || *event.Venue.Place != *place {
+		t.Fatalf("expected the published venue, got %+v", event.Venue)
+	}
+}
+
+func TestDecodeCalendarEventRejects(t *testing.T) {
+	data, _ := json.Marshal(&CalendarEvent{ID: "event-1", Title: "Picnic", StartsAt: startsAt, EndsAt: endsAt})
+
+	tests := []struct {
+		name     string
+		envelope Envelope
+		want     error
+	}{
+		{
+			name:     "unknown version",
+			envelope: Envelope{Type: EventCreated, SchemaVersion: 2, Data: data},
+			want:     ErrUnsupportedVersion,
+		},
+		{
+			name:     "unexpected type",
+			envelope: Envelope{Type: WeatherUpdated, SchemaVersion: 1, Data: data},
+			want:     ErrInvalid,
+		},
+		{
+			name:     "missing times",
+			envelope: Envelope{Type: EventCreated, SchemaVersion: 1, Data: json.RawMessage(`{"id": "event-1"}`)},
+			want:     ErrInvalid,
+		},
+		{
+			name:     "unknown kind of venue",
+			envelope: Envelope{Type: EventCreated, SchemaVersion: 1, Data: json.RawMessage(`{"id": "event-1", "startsAt": "2021-06-14T09:00:00Z", "endsAt": "2021-06-14T14:00:00Z", "venue": {"kind": "hybrid"}}`)},
+			want:     ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeCalendarEvent(&tt.envelope); !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestDecodeUnknownVersionFromMessage(t *testing.T) {
+	// a newer calendar publishing a version this build can't read
+	body := `{
+		"specversion": "1.0",
+		"id": "msg-1",
+		"source": "/calendar",
+		"type": "notsosmartcal.calendar.event.created",
+		"subject": "event-1",
+		"time": "2021-06-14T09:00:00Z",
+		"datacontenttype": "application/json",
+		"schemaversion": 2,
+		"data": {"id": "event-1", "startsAt": "2021-06-14T09:00:00Z", "endsAt": "2021-06-14T14:00:00Z", "where": {"kind": "physical"}}
+	}`
+
+	_, _, err := decodeCalendarMessage(t, &messaging.Message{ContentType: ContentType, RoutingKey: "event.created", Body: []byte(body)})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
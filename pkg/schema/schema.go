@@ -0,0 +1,165 @@
+// Package schema defines the messages the services exchange over the bus.
+// Messages are CloudEvents 1.0 in structured mode, the envelope names the type of the data and the version of its schema
+// so consumers can reject or upgrade payloads rather than silently misreading them.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/google/uuid"
+)
+
+const (
+	// SpecVersion is the version of the CloudEvents spec envelopes follow
+	SpecVersion = "1.0"
+	// ContentType marks a message body as a structured mode CloudEvent
+	ContentType = "application/cloudevents+json"
+)
+
+var (
+	// ErrInvalid is returned when a message doesn't match its schema, retrying it won't help
+	ErrInvalid = errors.New("schema: invalid message")
+	// ErrUnsupportedVersion is returned for schema versions this build doesn't know how to read
+	ErrUnsupportedVersion = errors.New("schema: unsupported version")
+)
+
+// Envelope is a CloudEvent in structured mode
+type Envelope struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	// SchemaVersion is an extension attribute, it's bumped whenever the data changes in a way older consumers can't read
+	SchemaVersion int             `json:"schemaversion"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// New wraps the data in an envelope, the subject is the id of the entity the data is about
+func New(source, eventType, subject string, version int, data interface{}) (*Envelope, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling %s data: %w", eventType, err)
+	}
+
+	return &Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		SchemaVersion:   version,
+		Data:            body,
+	}, nil
+}
+
+// Message encodes the envelope as a message, sharing its id and time
+func (e *Envelope) Message() (messaging.Message, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return messaging.Message{}, err
+	}
+
+	return messaging.Message{
+		ID:          e.ID,
+		ContentType: ContentType,
+		Timestamp:   e.Time,
+		Body:        body,
+	}, nil
+}
+
+// Validate checks the attributes the CloudEvents spec requires
+func (e *Envelope) Validate() error {
+	switch {
+	case e.SpecVersion != SpecVersion:
+		return fmt.Errorf("%w: unknown specversion %q", ErrInvalid, e.SpecVersion)
+	case e.ID == "":
+		return fmt.Errorf("%w: missing id", ErrInvalid)
+	case e.Source == "":
+		return fmt.Errorf("%w: missing source", ErrInvalid)
+	case e.Type == "":
+		return fmt.Errorf("%w: missing type", ErrInvalid)
+	case e.SchemaVersion < 1:
+		return fmt.Errorf("%w: missing schemaversion", ErrInvalid)
+	}
+
+	return nil
+}
+
+// Decode reads the envelope from a delivered message.
+// Messages published before envelopes were introduced carried the bare data, they're read as version 1 of the type
+// their routing key maps to
+func Decode(msg *messaging.Message) (*Envelope, error) {
+	if msg.ContentType != ContentType {
+		return decodeLegacy(msg)
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(msg.Body, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	if err := envelope.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &envelope, nil
+}
+
+func decodeLegacy(msg *messaging.Message) (*Envelope, error) {
+	eventType, ok := legacyTypes[msg.RoutingKey]
+	if !ok {
+		return nil, fmt.Errorf("%w: unenveloped message with routing key %q", ErrInvalid, msg.RoutingKey)
+	}
+
+	if !json.Valid(msg.Body) {
+		return nil, fmt.Errorf("%w: body isn't json", ErrInvalid)
+	}
+
+	return &Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              msg.ID,
+		Source:          msg.Exchange,
+		Type:            eventType,
+		Time:            msg.Timestamp,
+		DataContentType: "application/json",
+		SchemaVersion:   1,
+		Data:            msg.Body,
+	}, nil
+}
+
+// legacyTypes maps the routing keys used before envelopes were introduced to their types
+var legacyTypes = map[string]string{
+	"event.created":   EventCreated,
+	"event.updated":   EventUpdated,
+	"event.deleted":   EventDeleted,
+	"weather.updated": WeatherUpdated,
+}
+
+// decodeData unmarshals the envelope's data, rejecting types the caller doesn't expect
+func decodeData(e *Envelope, v interface{}, types ...string) error {
+	known := false
+	for _, t := range types {
+		if e.Type == t {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalid, e.Type)
+	}
+
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("%w: %s data: %v", ErrInvalid, e.Type, err)
+	}
+
+	return nil
+}
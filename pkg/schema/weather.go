@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// WeatherSource is the source of the weather service's messages
+const WeatherSource = "/weather"
+
+// WeatherUpdated is the type of the message published when an event's forecast changes, the subject is the event id
+const WeatherUpdated = "notsosmartcal.weather.updated"
+
+// WeatherUpdateVersion is the version of WeatherUpdate published by this build
+const WeatherUpdateVersion = 1
+
+// WeatherUpdate is the data of the weather updated messages
+type WeatherUpdate struct {
+	EventID string `json:"eventId"`
+	// Weather is the forecast as the weather service serves it, it's passed on to clients untouched
+	Weather json.RawMessage `json:"weather"`
+}
+
+// NewWeatherUpdate wraps the update in an envelope
+func NewWeatherUpdate(update *WeatherUpdate) (*Envelope, error) {
+	return New(WeatherSource, WeatherUpdated, update.EventID, WeatherUpdateVersion, update)
+}
+
+// DecodeWeatherUpdate reads the data of a weather updated message, upgrading older versions
+func DecodeWeatherUpdate(e *Envelope) (*WeatherUpdate, error) {
+	var update WeatherUpdate
+
+	switch e.SchemaVersion {
+	case 1:
+		if err := decodeData(e, &update, WeatherUpdated); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, e.Type, e.SchemaVersion)
+	}
+
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &update, nil
+}
+
+// Validate checks the fields consumers rely on
+func (u *WeatherUpdate) Validate() error {
+	switch {
+	case u.EventID == "":
+		return fmt.Errorf("%w: weather update is missing its event id", ErrInvalid)
+	case len(u.Weather) == 0 || string(u.Weather) == "null":
+		return fmt.Errorf("%w: weather update for %s is missing its forecast", ErrInvalid, u.EventID)
+	}
+
+	return nil
+}
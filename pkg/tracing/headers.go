@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// HeadersCarrier adapts message headers so trace context can be carried across a message broker
+type HeadersCarrier map[string]string
+
+var _ propagation.TextMapCarrier = HeadersCarrier{}
+
+func (c HeadersCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c HeadersCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c HeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// InjectHeaders writes the trace context from ctx into the headers, creating them if needed
+func InjectHeaders(ctx context.Context, headers map[string]string) map[string]string {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, HeadersCarrier(headers))
+
+	return headers
+}
+
+// ExtractHeaders returns a copy of ctx carrying the trace context found in the headers
+func ExtractHeaders(ctx context.Context, headers map[string]string) context.Context {
+	if headers == nil {
+		return ctx
+	}
+
+	return otel.GetTextMapPropagator().Extract(ctx, HeadersCarrier(headers))
+}
@@ -0,0 +1,73 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/alexdunne/not-so-smart-cal/pkg/schema"
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// WeatherUpdatedRoutingKey is used for messages announcing an event's forecast has changed
+const WeatherUpdatedRoutingKey = "weather.updated"
+
+var messagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "weather",
+	Subsystem: "amqp",
+	Name:      "messages_published_total",
+	Help:      "Number of messages published, partitioned by exchange, routing key and outcome.",
+}, []string{"exchange", "routing_key", "outcome"})
+
+type WeatherPublisher struct {
+	publisher    messaging.Publisher
+	exchangeName string
+	logger       *zap.Logger
+}
+
+func NewWeatherPublisher(
+	publisher messaging.Publisher,
+	exchangeName string,
+	logger *zap.Logger,
+) *WeatherPublisher {
+	return &WeatherPublisher{
+		publisher:    publisher,
+		exchangeName: exchangeName,
+		logger:       logger,
+	}
+}
+
+// PublishWeatherUpdated announces the event's new forecast
+func (p *WeatherPublisher) PublishWeatherUpdated(ctx context.Context, update *weather.WeatherUpdated) (err error) {
+	routingKey := WeatherUpdatedRoutingKey
+
+	p.logger.Info("publishing message", zap.String("exchange", p.exchangeName), zap.String("routing key", routingKey))
+
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		messagesPublished.WithLabelValues(p.exchangeName, routingKey, outcome).Inc()
+	}()
+
+	forecast, err := json.Marshal(update.Weather)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := schema.NewWeatherUpdate(&schema.WeatherUpdate{EventID: update.EventID, Weather: forecast})
+	if err != nil {
+		return err
+	}
+
+	msg, err := envelope.Message()
+	if err != nil {
+		return err
+	}
+
+	return p.publisher.Publish(ctx, p.exchangeName, routingKey, msg)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/alexdunne/not-so-smart-cal/weather/openapi"
+	"github.com/alexdunne/not-so-smart-cal/weather/openweather"
+	weatherRedis "github.com/alexdunne/not-so-smart-cal/weather/redis"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// fakeEventStorage serves the forecasts the worker would have stored
+type fakeEventStorage struct {
+	events map[string]*weather.Event
+}
+
+func (s *fakeEventStorage) Get(ctx context.Context, eventId string) (*weather.Event, error) {
+	event, ok := s.events[eventId]
+	if !ok {
+		return nil, weatherRedis.ErrNotFound
+	}
+
+	return event, nil
+}
+
+// fakeLocationSearcher returns the places whatever the query, or err when it's set
+type fakeLocationSearcher struct {
+	locations []*weather.GeocodedLocation
+	err       error
+	// priority and deadline are what the last search was made with
+	priority weather.Priority
+	deadline time.Time
+}
+
+func (s *fakeLocationSearcher) Candidates(ctx context.Context, query string, limit int) ([]*weather.GeocodedLocation, error) {
+	s.priority = weather.PriorityFrom(ctx)
+	s.deadline, _ = ctx.Deadline()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if limit > 0 && limit < len(s.locations) {
+		return s.locations[:limit], nil
+	}
+
+	return s.locations, nil
+}
+
+// contractClient sends requests to the router, failing the test when a request or response doesn't match
+// openapi.json
+type contractClient struct {
+	t       *testing.T
+	handler http.Handler
+	router  routers.Router
+	// server is the url in the spec the requests are made to
+	server string
+	// validateRequests is turned off to check how the handlers respond to requests the spec doesn't allow
+	validateRequests bool
+}
+
+func newContractClient(t *testing.T, server *Server, validateRequests bool) *contractClient {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+
+	// ids are checked as well as the shape of the documents
+	openapi3.DefineStringFormat("uuid", openapi3.FormatOfStringForUUIDOfRFC4122)
+
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openapi.Spec)
+	if err != nil {
+		t.Fatalf("loading the spec: %v", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		t.Fatalf("the spec is invalid: %v", err)
+	}
+
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatalf("routing the spec: %v", err)
+	}
+
+	server.logger = zap.NewNop()
+
+	return &contractClient{
+		t:                t,
+		handler:          newRouter(server),
+		router:           router,
+		server:           doc.Servers[0].URL,
+		validateRequests: validateRequests,
+	}
+}
+
+// get requests the path, served in-process, and returns the response once it's been checked against the spec
+func (c *contractClient) get(path string, query url.Values, header http.Header) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	route, pathParams, err := c.router.FindRoute(req)
+	if err != nil {
+		c.t.Fatalf("GET %s isn't in the spec: %v", req.URL.Path, err)
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    req,
+		PathParams: pathParams,
+		Route:      route,
+	}
+
+	if c.validateRequests {
+		if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+			c.t.Fatalf("GET %s request doesn't match the spec: %v", req.URL.Path, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+
+	err = openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 rec.Code,
+		Header:                 rec.Header(),
+		Body:                   ioutil.NopCloser(strings.NewReader(rec.Body.String())),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	if err != nil {
+		c.t.Fatalf("GET %s %d response doesn't match the spec: %v\n%s", req.URL.Path, rec.Code, err, rec.Body)
+	}
+
+	return rec
+}
+
+const eventID = "00000000-0000-4000-8000-000000000001"
+
+func newEventStorage() *fakeEventStorage {
+	startsAt := time.Date(2021, 6, 14, 9, 0, 0, 0, time.UTC)
+	sunrise := time.Date(2021, 6, 14, 3, 43, 0, 0, time.UTC)
+
+	return &fakeEventStorage{
+		events: map[string]*weather.Event{
+			eventID: {
+				ID:               eventID,
+				StartsAt:         startsAt,
+				EndsAt:           startsAt.Add(time.Hour),
+				GeocodedLocation: &weather.GeocodedLocation{Name: "London", Latitude: "51.5072", Longitude: "-0.1276", Country: "GB"},
+				WeatherSummary: &weather.WeatherSummary{
+					Source:                   weather.SourceForecast,
+					Confidence:               weather.ConfidenceHigh,
+					Type:                     "Rain",
+					Description:              "light rain",
+					Condition:                weather.ConditionLightRain,
+					Temp:                     15,
+					FeelsLike:                14,
+					MinTemp:                  13,
+					MaxTemp:                  16,
+					PrecipitationProbability: 0.8,
+					PrecipitationVolume:      1.2,
+					WindSpeed:                4,
+					WindGust:                 7,
+					UVIndex:                  3,
+					Humidity:                 80,
+					Sunrise:                  &sunrise,
+					Alerts: []weather.Alert{
+						{Event: "Yellow rain warning", Sender: "Met Office", Description: "Heavy rain", Start: startsAt, End: startsAt.Add(6 * time.Hour)},
+					},
+					Hourly: []weather.HourlyWeather{
+						{Time: startsAt, Type: "Rain", Description: "light rain", Condition: weather.ConditionLightRain, Temp: 15, FeelsLike: 14, PrecipitationProbability: 0.8, PrecipitationVolume: 1.2, WindSpeed: 4, WindGust: 7, UVIndex: 3, Humidity: 80},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestEventWeatherContract(t *testing.T) {
+	c := newContractClient(t, &Server{eventStorage: newEventStorage()}, true)
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		header   http.Header
+		wantLang string
+	}{
+		{name: "defaults", wantLang: "en"},
+		{name: "imperial", query: url.Values{"units": {"imperial"}}, wantLang: "en"},
+		{name: "lang", query: url.Values{"units": {"standard"}, "lang": {"fr"}}, wantLang: "fr"},
+		{name: "Accept-Language", header: http.Header{"Accept-Language": {"de-DE,de;q=0.9"}}, wantLang: "de"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := c.get("/event/"+eventID, tt.query, tt.header)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 with the forecast, got %d: %s", rec.Code, rec.Body)
+			}
+			if lang := rec.Header().Get("Content-Language"); lang != tt.wantLang {
+				t.Fatalf("expected the forecast in %s, got %s", tt.wantLang, lang)
+			}
+		})
+	}
+}
+
+func TestEventWeatherNotFoundContract(t *testing.T) {
+	c := newContractClient(t, &Server{eventStorage: newEventStorage()}, true)
+
+	rec := c.get("/event/00000000-0000-4000-8000-000000000404", nil, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestEventWeatherUnsupportedUnitsContract(t *testing.T) {
+	// the spec doesn't allow the units, the handler has to reject them too
+	c := newContractClient(t, &Server{eventStorage: newEventStorage()}, false)
+
+	rec := c.get("/event/"+eventID, url.Values{"units": {"kelvin"}}, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSearchLocationsContract(t *testing.T) {
+	searcher := &fakeLocationSearcher{
+		locations: []*weather.GeocodedLocation{
+			{Name: "Paris", Latitude: "48.8589", Longitude: "2.32", Country: "FR"},
+			{Name: "Paris", Latitude: "33.6617", Longitude: "-95.5555", Country: "US", State: "Texas"},
+		},
+	}
+	c := newContractClient(t, &Server{locationSearcher: searcher}, true)
+
+	rec := c.get("/locations", url.Values{"q": {"Paris"}}, nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"state":"Texas"`) {
+		t.Fatalf("expected 200 with the places, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = c.get("/locations", url.Values{"q": {"Paris"}, "limit": {"1"}}, nil)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Texas") {
+		t.Fatalf("expected 200 with one place, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSearchLocationsGiveWayToForecasts(t *testing.T) {
+	searcher := &fakeLocationSearcher{}
+	c := newContractClient(t, &Server{locationSearcher: searcher}, true)
+
+	c.get("/locations", url.Values{"q": {"Paris"}}, nil)
+
+	if searcher.priority != weather.PriorityLow {
+		t.Fatalf("expected searches to be low priority, got %s", searcher.priority)
+	}
+	if searcher.deadline.IsZero() || time.Until(searcher.deadline) > searchTimeout {
+		t.Fatalf("expected searches to give up within %s, got a deadline of %s", searchTimeout, searcher.deadline)
+	}
+}
+
+func TestSearchLocationsRateLimitedContract(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "rate limit", err: fmt.Errorf("%w, the next window opens in 40s", weatherRedis.ErrRateLimited)},
+		{name: "daily quota", err: weatherRedis.ErrQuotaExhausted},
+		{name: "openweather's rate limit", err: openweather.ErrRateLimited},
+		{name: "timed out", err: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newContractClient(t, &Server{locationSearcher: &fakeLocationSearcher{err: tt.err}}, true)
+
+			rec := c.get("/locations", url.Values{"q": {"Paris"}}, nil)
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503, got %d: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSearchLocationsShortQueryContract(t *testing.T) {
+	// the spec doesn't allow the query, the handler has to reject it too
+	c := newContractClient(t, &Server{locationSearcher: &fakeLocationSearcher{}}, false)
+
+	rec := c.get("/locations", url.Values{"q": {" Pa "}}, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSearchLocationsUnavailableContract(t *testing.T) {
+	c := newContractClient(t, &Server{locationSearcher: &fakeLocationSearcher{err: errors.New("connection refused")}}, true)
+
+	rec := c.get("/locations", url.Values{"q": {"Paris"}}, nil)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -2,22 +2,65 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/alexdunne/not-so-smart-cal/pkg/config"
+	"github.com/alexdunne/not-so-smart-cal/pkg/metrics"
+	"github.com/alexdunne/not-so-smart-cal/pkg/tracing"
 	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/alexdunne/not-so-smart-cal/weather/openapi"
+	"github.com/alexdunne/not-so-smart-cal/weather/openweather"
 	weatherRedis "github.com/alexdunne/not-so-smart-cal/weather/redis"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
 )
 
+type Config struct {
+	HTTP        config.HTTP             `yaml:"http"`
+	Redis       config.Redis            `yaml:"redis"`
+	OpenWeather openweather.Config      `yaml:"openWeather"`
+	Geocode     openweather.CacheConfig `yaml:"geocode"`
+	Shutdown    config.Shutdown         `yaml:"shutdown"`
+}
+
 type EventStorage interface {
 	Get(ctx context.Context, eventId string) (*weather.Event, error)
 }
 
+type LocationSearcher interface {
+	Candidates(ctx context.Context, query string, limit int) ([]*weather.GeocodedLocation, error)
+}
+
+// Location is a geocoding candidate as served to clients
+type Location struct {
+	Name      string      `json:"name"`
+	Latitude  json.Number `json:"lat"`
+	Longitude json.Number `json:"lon"`
+	Country   string      `json:"country"`
+	State     string      `json:"state,omitempty"`
+}
+
+// minQueryLength stops the first keystrokes of a search using up the geocoding quota
+const minQueryLength = 3
+
+// searchTimeout answers before the calendar gives up on its suggestions, rather than waiting on the rate limit
+const searchTimeout = 2 * time.Second
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	logger, err := zap.NewProduction()
 	if err != nil {
 		fmt.Printf("error creating the logger: %v\n", err)
@@ -25,32 +68,195 @@ func main() {
 	}
 	defer logger.Sync()
 
+	var cfg Config
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		logger.Fatal("error loading config", zap.Error(err))
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, "weather-api")
+	if err != nil {
+		logger.Fatal("error configuring tracing", zap.Error(err))
+		os.Exit(1)
+	}
+
 	redisClient := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")),
+		Addr:     cfg.Redis.Addr(),
+		Password: cfg.Redis.Password,
 	})
-	defer redisClient.Close()
+	redisClient.AddHook(weatherRedis.TracingHook{})
 	logger.Info("opened redis connection")
 
 	eventStorage := weatherRedis.NewStorage(redisClient)
 
+	// searches share the openweather budget with the worker and background refresh
+	openWeatherLimiter := weatherRedis.NewRateLimiter(
+		redisClient,
+		"openweather",
+		cfg.OpenWeather.RateLimit,
+		cfg.OpenWeather.DailyQuota,
+	)
+	openWeatherClient := openweather.NewClient(cfg.OpenWeather, openWeatherLimiter, logger)
+
+	var locationSearcher LocationSearcher = openweather.NewGeocodeService(
+		openWeatherClient,
+		openweather.NewCache(redisClient, cfg.Geocode),
+		logger,
+	)
+
+	server := &Server{
+		logger:           logger,
+		eventStorage:     eventStorage,
+		locationSearcher: locationSearcher,
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.HTTP.Addr(),
+		Handler: newRouter(server),
+	}
+
+	go func() {
+		logger.Info("starting http server", zap.String("addr", srv.Addr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("error whilst running http server", zap.Error(err))
+			stop()
+		}
+	}()
+
+	// wait for termination
+	<-ctx.Done()
+
+	logger.Info("weather api shutting down", zap.Duration("timeout", cfg.Shutdown.Timeout))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	// stop accepting new requests and wait for the in-flight ones to finish
+	// before closing the redis connection they depend on
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error shutting down http server", zap.Error(err))
+	}
+
+	if err := redisClient.Close(); err != nil {
+		logger.Error("error closing redis connection", zap.Error(err))
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("error flushing traces", zap.Error(err))
+	}
+
+	logger.Info("weather api stopped")
+}
+
+type Server struct {
+	logger           *zap.Logger
+	eventStorage     EventStorage
+	locationSearcher LocationSearcher
+}
+
+// newRouter serves the http api described by openapi.json
+func newRouter(server *Server) *gin.Engine {
 	r := gin.Default()
+	r.Use(metrics.GinMiddleware())
+	r.Use(otelgin.Middleware("weather-api"))
+
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openapi.Spec)
+	})
+	r.GET("/event/:eventId", server.eventWeather)
+	r.GET("/locations", server.searchLocations)
+
+	return r
+}
+
+func (s *Server) eventWeather(c *gin.Context) {
+	eventId := c.Param("eventId")
+
+	units, err := weather.ParseUnits(c.Query("units"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	r.GET("/event/:eventId", func(c *gin.Context) {
-		eventId := c.Param("eventId")
+	// one stored forecast serves every preference, it's converted as it's read
+	preferences := weather.Preferences{
+		Units:    units,
+		Language: weather.Language(c.Query("lang"), c.GetHeader("Accept-Language")),
+	}
 
-		event, err := eventStorage.Get(c.Request.Context(), eventId)
+	event, err := s.eventStorage.Get(c.Request.Context(), eventId)
 
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{})
-			return
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{})
+		return
+	}
+
+	c.Header("Content-Language", preferences.Language)
+	c.Header("Vary", "Accept-Language")
+
+	var summary *weather.WeatherSummary
+	if event.WeatherSummary != nil {
+		summary = event.WeatherSummary.Present(preferences)
+	}
+
+	c.JSON(200, gin.H{
+		"data": gin.H{
+			"weather": summary,
+			"units":   preferences.Units,
+			"lang":    preferences.Language,
+		},
+	})
+}
+
+func (s *Server) searchLocations(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if len(query) < minQueryLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("q must be at least %d characters", minQueryLength)})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	// searches run on every keystroke, forecasts have first call on the budget and suggestions make do without
+	ctx, cancel := context.WithTimeout(c.Request.Context(), searchTimeout)
+	defer cancel()
+	ctx = weather.WithPriority(ctx, weather.PriorityLow)
+
+	locations, err := s.locationSearcher.Candidates(ctx, query, limit)
+	if rateLimited(err) {
+		s.logger.Warn("locations are unavailable whilst the geocoder is rate limited", zap.String("query", query), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "locations are temporarily unavailable"})
+		return
+	} else if err != nil {
+		s.logger.Error("error whilst searching for locations", zap.String("query", query), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "locations are unavailable"})
+		return
+	}
+
+	response := make([]*Location, len(locations))
+	for i, location := range locations {
+		response[i] = &Location{
+			Name:      location.Name,
+			Latitude:  json.Number(location.Latitude),
+			Longitude: json.Number(location.Longitude),
+			Country:   location.Country,
+			State:     location.State,
 		}
+	}
 
-		c.JSON(200, gin.H{
-			"data": gin.H{
-				"weather": event.WeatherSummary,
-			},
-		})
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"locations": response,
+		},
 	})
+}
 
-	r.Run()
+// rateLimited reports whether the search was refused by a rate limit or daily quota, ours or OpenWeather's, or ran
+// out of time waiting on one
+func rateLimited(err error) bool {
+	return errors.Is(err, weatherRedis.ErrRateLimited) ||
+		errors.Is(err, weatherRedis.ErrQuotaExhausted) ||
+		errors.Is(err, openweather.ErrRateLimited) ||
+		errors.Is(err, context.DeadlineExceeded)
 }
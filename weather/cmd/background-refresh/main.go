@@ -2,45 +2,53 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/alexdunne/not-so-smart-cal/pkg/config"
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/alexdunne/not-so-smart-cal/pkg/tracing"
 	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/alexdunne/not-so-smart-cal/weather/bus"
 	"github.com/alexdunne/not-so-smart-cal/weather/openweather"
+	"github.com/alexdunne/not-so-smart-cal/weather/provider"
 	weatherRedis "github.com/alexdunne/not-so-smart-cal/weather/redis"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
-var OPEN_WEATHER_API_KEY = os.Getenv("OPEN_WEATHER_API_KEY")
-
-type WeatherService interface {
-	FetchWeather(location *weather.GeocodedLocation, timeToCheckFor time.Time) (*weather.WeatherSummary, error)
+type Config struct {
+	AMQP        config.AMQP        `yaml:"amqp"`
+	Redis       config.Redis       `yaml:"redis"`
+	OpenWeather openweather.Config `yaml:"openWeather"`
+	Weather     provider.Config    `yaml:"weather"`
+	Shutdown    config.Shutdown    `yaml:"shutdown"`
+	// Minutes has always been read as hours, the name is kept so existing deployments passing -minutes don't change
+	Minutes   int           `yaml:"minutes" default:"1440" usage:"how many hours in the future should we check, despite the name"`
+	Estimates time.Duration `yaml:"estimates" env:"ESTIMATE_HORIZON" default:"384h" usage:"how far ahead events with climate estimates are checked for a forecast, as far as the providers forecast"`
+	Workers   int           `yaml:"workers" default:"3" usage:"how many workers should be created"`
 }
 
 type EventStorage interface {
 	GetFutureEvents(ctx context.Context, max time.Time) ([]*weather.Event, error)
+	RemoveExpiredFutureEvents(ctx context.Context) error
 	Set(ctx context.Context, eventId string, value *weather.Event) error
 }
 
-func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// setup signal handlers
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt)
-	go func() {
-		<-signalCh
-		cancel()
-	}()
+type WeatherPublisher interface {
+	PublishWeatherUpdated(ctx context.Context, update *weather.WeatherUpdated) error
+}
 
-	minutes := flag.Int("minutes", 1440, "how many minutes in the future should we check")
-	workers := flag.Int("workers", 3, "how many workers should be created")
-	flag.Parse()
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	logger, err := zap.NewProduction()
 	if err != nil {
@@ -49,116 +57,234 @@ func main() {
 	}
 	defer logger.Sync()
 
+	var cfg Config
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		logger.Fatal("error loading config", zap.Error(err))
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, "weather-background-refresh")
+	if err != nil {
+		logger.Fatal("error configuring tracing", zap.Error(err))
+		os.Exit(1)
+	}
+
 	redisClient := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")),
+		Addr:     cfg.Redis.Addr(),
+		Password: cfg.Redis.Password,
 	})
-	defer redisClient.Close()
+	redisClient.AddHook(weatherRedis.TracingHook{})
 	logger.Info("opened redis connection")
 
-	weatherService := openweather.NewWeatherService(logger, OPEN_WEATHER_API_KEY)
+	publisherOpts, err := messaging.PublisherOptions(cfg.AMQP)
+	if err != nil {
+		logger.Fatal("error configuring publisher", zap.Error(err))
+		os.Exit(1)
+	}
+
+	// reconnects in the background if the broker goes away later on
+	amqpConn, err := messaging.DialAMQP(ctx, cfg.AMQP.URL(), logger)
+	if err != nil {
+		logger.Fatal("error opening rabbitmq connection", zap.Error(err))
+		os.Exit(1)
+	}
+	logger.Info("opened rabbitmq connection")
+
+	// every replica, and the background refresh, draw from the same openweather budget
+	openWeatherLimiter := weatherRedis.NewRateLimiter(
+		redisClient,
+		"openweather",
+		cfg.OpenWeather.RateLimit,
+		cfg.OpenWeather.DailyQuota,
+	)
+	openWeatherClient := openweather.NewClient(cfg.OpenWeather, openWeatherLimiter, logger)
+
+	weatherService, err := provider.New(
+		cfg.Weather,
+		openWeatherClient,
+		// shared with the other replicas and the background refresh so events nearby share a forecast
+		weatherRedis.NewForecastCache(redisClient),
+		logger,
+	)
+	if err != nil {
+		logger.Fatal("error configuring weather providers", zap.Error(err))
+		os.Exit(1)
+	}
 	eventStorage := weatherRedis.NewStorage(redisClient)
 
-	go func() {
-		// do this is the background as we're not really bothered about the results
-		logger.Info("removing expired future events")
-		eventStorage.RemoveExpiredFutureEvents(ctx)
-	}()
+	publisher := messaging.NewAMQPPublisher(amqpConn, publisherOpts, logger)
+
+	weatherPublisher := bus.NewWeatherPublisher(publisher, "weather", logger)
+
+	logger.Info("removing expired future events")
+	if err := eventStorage.RemoveExpiredFutureEvents(ctx); err != nil {
+		// not fatal, expired events are ignored when fetching future events
+		logger.Error("error removing expired future events", zap.Error(err))
+	}
+
+	logger.Info("fetching future events", zap.Int("hours", cfg.Minutes), zap.Duration("estimates", cfg.Estimates))
 
-	logger.Info("fetching future events", zap.Int("minutes", *minutes))
+	refreshUntil := time.Now().Add(time.Hour * time.Duration(cfg.Minutes))
+	estimatesUntil := time.Now().Add(cfg.Estimates)
+
+	latest := refreshUntil
+	if estimatesUntil.After(latest) {
+		latest = estimatesUntil
+	}
 
-	events, err := eventStorage.GetFutureEvents(ctx, time.Now().Add(time.Hour*time.Duration(*minutes)))
+	futureEvents, err := eventStorage.GetFutureEvents(ctx, latest)
 	if err != nil {
 		logger.Error("error fetching future events", zap.Error(err))
+		redisClient.Close()
+		amqpConn.Close()
 		os.Exit(1)
 	}
 
-	logger.Info("fetched future events", zap.Int("eventsCount", len(events)))
-
-	if len(events) == 0 {
-		// no events found, we're done here
-		os.Exit(0)
+	// further out only estimates are refreshed, so they're replaced by a forecast once the event is in range
+	events := make([]*weather.Event, 0, len(futureEvents))
+	for _, event := range futureEvents {
+		estimated := event.WeatherSummary != nil && event.WeatherSummary.Source == weather.SourceClimate
+		if !event.StartsAt.After(refreshUntil) || estimated {
+			events = append(events, event)
+		}
 	}
 
-	pending, complete := make(chan *weather.Event), make(chan string)
+	logger.Info("fetched future events", zap.Int("eventsCount", len(events)))
+
+	pending := make(chan *weather.Event)
 
 	// kick off the workers
-	for i := 0; i < int(*workers); i++ {
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
 		w := &Worker{
 			id:             uuid.NewString(),
 			logger:         logger,
 			eventStorage:   eventStorage,
 			weatherService: weatherService,
+			publisher:      weatherPublisher,
 		}
 
-		go w.Run(pending, complete)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Run(pending)
+		}()
 	}
 
 	logger.Info("populating workers with future events")
 	go func() {
+		defer close(pending)
+
 		for _, event := range events {
-			pending <- event
+			select {
+			case pending <- event:
+			case <-ctx.Done():
+				// shutting down, leave the remaining events for the next run
+				return
+			}
 		}
 	}()
 
+	workersDone := make(chan struct{})
 	go func() {
-		completed := 0
-		for {
-			<-complete
+		wg.Wait()
+		close(workersDone)
+	}()
 
-			completed++
-			logger.Info("event finished processing", zap.Int("completed", completed))
+	select {
+	case <-workersDone:
+		logger.Info("all events finished processing", zap.Int("eventsCount", len(events)))
 
-			if completed == len(events) {
-				logger.Info("all events finished processing", zap.Int("completed", completed))
-				ctx.Done()
-			}
+	case <-ctx.Done():
+		logger.Info("background refresh shutting down", zap.Duration("timeout", cfg.Shutdown.Timeout))
+
+		select {
+		case <-workersDone:
+		case <-time.After(cfg.Shutdown.Timeout):
+			logger.Warn("shutdown deadline exceeded, abandoning in-flight events")
 		}
-	}()
+	}
 
-	<-ctx.Done()
+	if err := redisClient.Close(); err != nil {
+		logger.Error("error closing redis connection", zap.Error(err))
+	}
+
+	if err := publisher.Close(); err != nil {
+		logger.Error("error closing publisher", zap.Error(err))
+	}
 
-	close(pending)
-	close(complete)
+	if err := amqpConn.Close(); err != nil {
+		logger.Error("error closing rabbitmq connection", zap.Error(err))
+	}
 
-	os.Exit(0)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("error flushing traces", zap.Error(err))
+	}
 }
 
+var tracer = otel.Tracer("github.com/alexdunne/not-so-smart-cal/weather/cmd/background-refresh")
+
 type Worker struct {
 	id             string
 	logger         *zap.Logger
 	eventStorage   EventStorage
-	weatherService WeatherService
+	weatherService weather.Provider
+	publisher      WeatherPublisher
 }
 
-func (w *Worker) Run(in <-chan *weather.Event, out chan<- string) {
+func (w *Worker) Run(in <-chan *weather.Event) {
 	w.logger.Info("starting worker", zap.String("workerId", w.id))
 
 	for event := range in {
-		w.logger.Info("starting to process event", zap.String("workerId", w.id), zap.String("eventId", event.ID))
+		w.process(event)
+	}
 
-		weatherResponse, err := w.weatherService.FetchWeather(event.GeocodedLocation, event.StartsAt)
-		if err != nil {
-			w.logger.Error("error whilst fetching weather data", zap.String("workerId", w.id), zap.Error(err))
-			continue
-		}
+	w.logger.Info("worker finished", zap.String("workerId", w.id))
+}
 
-		w.logger.Info(
-			"weather fetched for event",
-			zap.String("workerId", w.id),
-			zap.String("eventId", event.ID),
-			zap.Any("weather", weatherResponse),
-		)
+func (w *Worker) process(event *weather.Event) {
+	ctx, span := tracer.Start(context.Background(), "Worker.process")
+	span.SetAttributes(attribute.String("event.id", event.ID))
+	defer span.End()
 
-		w.logger.Info("caching event weather", zap.String("workerId", w.id), zap.String("eventId", event.ID))
+	ctx = weather.WithPriority(ctx, weather.PriorityFor(event.StartsAt))
 
-		w.eventStorage.Set(context.Background(), event.ID, &weather.Event{
-			ID:               event.ID,
-			StartsAt:         event.StartsAt,
-			GeocodedLocation: event.GeocodedLocation,
-			WeatherSummary:   weatherResponse,
-		})
+	w.logger.Info("starting to process event", zap.String("workerId", w.id), zap.String("eventId", event.ID))
 
-		w.logger.Info("finished processing event", zap.String("workerId", w.id), zap.String("eventId", event.ID))
-		out <- event.ID
+	weatherResponse, err := w.weatherService.Forecast(ctx, event.GeocodedLocation, event.StartsAt, event.EndsAt)
+	if err != nil {
+		w.logger.Error("error whilst fetching weather data", zap.String("workerId", w.id), zap.Error(err))
+		return
 	}
+
+	w.logger.Info(
+		"weather fetched for event",
+		zap.String("workerId", w.id),
+		zap.String("eventId", event.ID),
+		zap.Any("weather", weatherResponse),
+	)
+
+	w.logger.Info("caching event weather", zap.String("workerId", w.id), zap.String("eventId", event.ID))
+
+	err = w.eventStorage.Set(ctx, event.ID, &weather.Event{
+		ID:               event.ID,
+		StartsAt:         event.StartsAt,
+		EndsAt:           event.EndsAt,
+		GeocodedLocation: event.GeocodedLocation,
+		WeatherSummary:   weatherResponse,
+	})
+	if err != nil {
+		w.logger.Error("error whilst caching event weather", zap.String("workerId", w.id), zap.Error(err))
+		return
+	}
+
+	err = w.publisher.PublishWeatherUpdated(ctx, &weather.WeatherUpdated{EventID: event.ID, Weather: weatherResponse})
+	if err != nil {
+		w.logger.Error("error whilst publishing weather update", zap.String("workerId", w.id), zap.Error(err))
+	}
+
+	w.logger.Info("finished processing event", zap.String("workerId", w.id), zap.String("eventId", event.ID))
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/pkg/config"
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"go.uber.org/zap"
+)
+
+const usage = `usage: dlq <command> [flags]
+
+commands:
+  list     show the messages in a queue's dead-letter queue
+  replay   move dead-lettered messages back onto their queue, all of them or only those given with --ids
+`
+
+type Config struct {
+	AMQP config.AMQP `yaml:"amqp"`
+
+	Queue string `yaml:"queue" flag:"queue" default:"fetch_weather_for_event" usage:"queue whose dead-lettered messages to inspect"`
+	Limit int    `yaml:"limit" flag:"limit" default:"50" usage:"most messages to list"`
+	IDs   string `yaml:"ids" flag:"ids" usage:"comma separated ids of the messages to replay, all of them when empty"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		fmt.Printf("error creating the logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+
+	var cfg Config
+	if err := config.Load(&cfg, os.Args[2:]); err != nil {
+		logger.Fatal("error loading config", zap.Error(err))
+		os.Exit(1)
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	amqpConn, err := messaging.DialAMQP(dialCtx, cfg.AMQP.URL(), logger)
+	if err != nil {
+		logger.Fatal("error opening rabbitmq connection", zap.Error(err))
+		os.Exit(1)
+	}
+	defer amqpConn.Close()
+
+	switch os.Args[1] {
+	case "list":
+		err = list(ctx, amqpConn, cfg)
+	case "replay":
+		err = replay(ctx, amqpConn, cfg)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logger.Fatal("error running command", zap.String("command", os.Args[1]), zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+func list(ctx context.Context, conn *messaging.AMQPConnection, cfg Config) error {
+	letters, err := messaging.ListDeadLetters(ctx, conn, cfg.Queue, cfg.Limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tROUTING KEY\tATTEMPTS\tFAILED AT\tERROR")
+	for _, letter := range letters {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%d\t%s\t%s\n",
+			letter.ID,
+			letter.RoutingKey,
+			letter.Attempts,
+			letter.FailedAt.Format(time.RFC3339),
+			letter.Error,
+		)
+	}
+
+	return w.Flush()
+}
+
+func replay(ctx context.Context, conn *messaging.AMQPConnection, cfg Config) error {
+	var ids []string
+	if cfg.IDs != "" {
+		ids = strings.Split(cfg.IDs, ",")
+	}
+
+	replayed, err := messaging.ReplayDeadLetters(ctx, conn, cfg.Queue, ids)
+	fmt.Printf("replayed %d message(s) onto %s\n", replayed, cfg.Queue)
+
+	return err
+}
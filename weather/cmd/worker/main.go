@@ -2,45 +2,59 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/alexdunne/not-so-smart-cal/pkg/config"
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/alexdunne/not-so-smart-cal/pkg/metrics"
+	"github.com/alexdunne/not-so-smart-cal/pkg/schema"
+	"github.com/alexdunne/not-so-smart-cal/pkg/tracing"
 	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/alexdunne/not-so-smart-cal/weather/bus"
 	"github.com/alexdunne/not-so-smart-cal/weather/openweather"
+	"github.com/alexdunne/not-so-smart-cal/weather/provider"
 	weatherRedis "github.com/alexdunne/not-so-smart-cal/weather/redis"
 	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
-	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-var OPEN_WEATHER_API_KEY = os.Getenv("OPEN_WEATHER_API_KEY")
+type Config struct {
+	AMQP        config.AMQP             `yaml:"amqp"`
+	Retry       config.Retry            `yaml:"retry"`
+	Consumer    config.Consumer         `yaml:"consumer"`
+	Redis       config.Redis            `yaml:"redis"`
+	OpenWeather openweather.Config      `yaml:"openWeather"`
+	Geocode     openweather.CacheConfig `yaml:"geocode"`
+	Weather     provider.Config         `yaml:"weather"`
+	Metrics     config.Metrics          `yaml:"metrics"`
+	Shutdown    config.Shutdown         `yaml:"shutdown"`
+}
 
 type GeocodeService interface {
 	GeocodeLocation(ctx context.Context, location string) (*weather.GeocodedLocation, error)
 }
 
-type WeatherService interface {
-	FetchWeather(location *weather.GeocodedLocation, timeToCheckFor time.Time) (*weather.WeatherSummary, error)
-}
-
 type EventStorage interface {
 	Set(ctx context.Context, eventId string, value *weather.Event) error
+	Delete(ctx context.Context, eventId string) error
 }
 
-func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+type WeatherPublisher interface {
+	PublishWeatherUpdated(ctx context.Context, update *weather.WeatherUpdated) error
+}
 
-	// setup signal handlers
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt)
-	go func() {
-		<-signalCh
-		cancel()
-	}()
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	logger, err := zap.NewProduction()
 	if err != nil {
@@ -49,187 +63,379 @@ func main() {
 	}
 	defer logger.Sync()
 
-	connStr := fmt.Sprintf(
-		"amqp://%s:%s@%s:%s",
-		os.Getenv("AMQP_USER"),
-		os.Getenv("AMQP_PASSWORD"),
-		os.Getenv("AMQP_HOST"),
-		os.Getenv("AMQP_PORT"),
-	)
-	amqpConn, err := amqp.Dial(connStr)
+	var cfg Config
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		logger.Fatal("error loading config", zap.Error(err))
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, "weather-worker")
+	if err != nil {
+		logger.Fatal("error configuring tracing", zap.Error(err))
+		os.Exit(1)
+	}
+
+	publisherOpts, err := messaging.PublisherOptions(cfg.AMQP)
+	if err != nil {
+		logger.Fatal("error configuring publisher", zap.Error(err))
+		os.Exit(1)
+	}
+
+	// reconnects in the background if the broker goes away later on
+	amqpConn, err := messaging.DialAMQP(ctx, cfg.AMQP.URL(), logger)
 	if err != nil {
 		logger.Fatal("error opening rabbitmq connection", zap.Error(err))
 		os.Exit(1)
 	}
-	defer amqpConn.Close()
 	logger.Info("opened rabbitmq connection")
 
 	redisClient := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")),
+		Addr:     cfg.Redis.Addr(),
+		Password: cfg.Redis.Password,
 	})
-	defer redisClient.Close()
+	redisClient.AddHook(weatherRedis.TracingHook{})
 	logger.Info("opened redis connection")
 
-	geocoder := openweather.NewGeocodeService(redisClient, logger, OPEN_WEATHER_API_KEY)
-	weatherService := openweather.NewWeatherService(logger, OPEN_WEATHER_API_KEY)
+	// every replica, and the background refresh, draw from the same openweather budget
+	openWeatherLimiter := weatherRedis.NewRateLimiter(
+		redisClient,
+		"openweather",
+		cfg.OpenWeather.RateLimit,
+		cfg.OpenWeather.DailyQuota,
+	)
+	openWeatherClient := openweather.NewClient(cfg.OpenWeather, openWeatherLimiter, logger)
+
+	geocoder := openweather.NewGeocodeService(
+		openWeatherClient,
+		openweather.NewCache(redisClient, cfg.Geocode),
+		logger,
+	)
+	weatherService, err := provider.New(
+		cfg.Weather,
+		openWeatherClient,
+		// shared with the other replicas and the background refresh so events nearby share a forecast
+		weatherRedis.NewForecastCache(redisClient),
+		logger,
+	)
+	if err != nil {
+		logger.Fatal("error configuring weather providers", zap.Error(err))
+		os.Exit(1)
+	}
 	eventStorage := weatherRedis.NewStorage(redisClient)
 
+	publisher := messaging.NewAMQPPublisher(amqpConn, publisherOpts, logger)
+
+	subscriber := messaging.NewAMQPSubscriber(amqpConn, "weather-worker", logger)
+
+	weatherPublisher := bus.NewWeatherPublisher(publisher, "weather", logger)
+
 	consumer := NewCalendarEventWeatherConsumer(
-		amqpConn,
+		subscriber,
 		geocoder,
 		weatherService,
 		eventStorage,
+		weatherPublisher,
 		logger,
 	)
 
+	// reports not ready whilst the openweather circuit breaker is open
+	metricsServer := metrics.NewServer(cfg.Metrics.Addr(), openWeatherClient)
 	go func() {
-		logger.Info("starting CalendarEventWeather consumer")
-		err := consumer.StartConsumer("calendar", "event.created", "fetch_weather_for_event")
-		if err != nil {
-			logger.Fatal("error whilst running consumer", zap.Error(err))
-			cancel()
+		logger.Info("starting metrics server", zap.String("addr", metricsServer.Addr))
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("error whilst running metrics server", zap.Error(err))
 		}
 	}()
 
+	consumerErrCh := make(chan error, 1)
+	go func() {
+		logger.Info("starting CalendarEventWeather consumer")
+		consumerErrCh <- consumer.StartConsumer(ctx, messaging.Binding{
+			Exchange:    "calendar",
+			Queue:       "fetch_weather_for_event",
+			RoutingKeys: []string{"event.created", "event.updated", "event.deleted"},
+			Retry:       messaging.RetryPolicyFromConfig(cfg.Retry),
+			Concurrency: cfg.Consumer.Concurrency,
+			Timeout:     cfg.Consumer.Timeout,
+			// updates to the same event are handled in order so an older one can't overwrite a newer forecast
+			Key: eventID,
+		})
+	}()
+
+	exitCode := 0
+
 	// wait for termination
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+		logger.Info("weather worker shutting down", zap.Duration("timeout", cfg.Shutdown.Timeout))
+
+		// the consumer stops taking new deliveries as soon as the context is cancelled,
+		// give the in-flight deliveries until the deadline to finish
+		select {
+		case err := <-consumerErrCh:
+			if err != nil {
+				logger.Error("error whilst stopping consumer", zap.Error(err))
+				exitCode = 1
+			}
+		case <-time.After(cfg.Shutdown.Timeout):
+			logger.Warn("shutdown deadline exceeded, unacknowledged deliveries will be requeued")
+		}
+
+	case err := <-consumerErrCh:
+		logger.Error("error whilst running consumer", zap.Error(err))
+		exitCode = 1
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error shutting down metrics server", zap.Error(err))
+	}
+
+	if err := redisClient.Close(); err != nil {
+		logger.Error("error closing redis connection", zap.Error(err))
+	}
+
+	if err := publisher.Close(); err != nil {
+		logger.Error("error closing publisher", zap.Error(err))
+	}
+
+	// closing the connection hands any unacknowledged deliveries back to the broker
+	if err := amqpConn.Close(); err != nil {
+		logger.Error("error closing rabbitmq connection", zap.Error(err))
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("error flushing traces", zap.Error(err))
+	}
+
+	logger.Info("weather worker stopped")
+	logger.Sync()
+
+	os.Exit(exitCode)
 }
 
 type CalendarEventWeatherConsumer struct {
-	conn           *amqp.Connection
+	subscriber     messaging.Subscriber
 	geocoder       GeocodeService
-	weatherService WeatherService
+	weatherService weather.Provider
 	eventStorage   EventStorage
+	publisher      WeatherPublisher
 	logger         *zap.Logger
 }
 
 func NewCalendarEventWeatherConsumer(
-	conn *amqp.Connection,
+	subscriber messaging.Subscriber,
 	geocoder GeocodeService,
-	weatherService WeatherService,
+	weatherService weather.Provider,
 	eventStorage EventStorage,
+	publisher WeatherPublisher,
 	logger *zap.Logger,
 ) *CalendarEventWeatherConsumer {
 	return &CalendarEventWeatherConsumer{
-		conn:           conn,
+		subscriber:     subscriber,
 		geocoder:       geocoder,
 		weatherService: weatherService,
 		eventStorage:   eventStorage,
+		publisher:      publisher,
 		logger:         logger,
 	}
 }
 
-func (c *CalendarEventWeatherConsumer) StartConsumer(exchangeName, routingKey, queueName string) error {
-	ch, err := c.createChannel(exchangeName, routingKey, queueName)
-	if err != nil {
-		return errors.Wrap(err, "error creating channel")
-	}
-	defer ch.Close()
-
-	messages, err := ch.Consume(queueName, "", true, false, false, false, nil)
+// StartConsumer consumes messages until the context is cancelled.
+// Once cancelled no new messages are accepted and it returns after the in-flight messages have been handled.
+func (c *CalendarEventWeatherConsumer) StartConsumer(ctx context.Context, binding messaging.Binding) error {
+	err := c.subscriber.Subscribe(ctx, binding, c.consume)
 	if err != nil {
 		return errors.Wrap(err, "error whilst consuming messages")
 	}
 
-	c.logger.Info("starting workers")
-	// kick off a worker to proccess the incoming messages
-	go c.worker(messages)
+	c.logger.Info("consumer stopped")
 
-	chanErr := <-ch.NotifyClose(make(chan *amqp.Error))
-
-	c.logger.Info("channel notified to close")
-
-	return chanErr
+	return nil
 }
 
-// createChannel creates a channel from the amqp connection
-// and creates all of the necessary exchanges, queues, and bindings
-func (c *CalendarEventWeatherConsumer) createChannel(
-	exchangeName, routingKey, queueName string,
-) (*amqp.Channel, error) {
-	ch, err := c.conn.Channel()
+// eventID returns the id of the event the message is about, messages that can't be decoded aren't ordered
+func eventID(msg *messaging.Message) string {
+	envelope, err := schema.Decode(msg)
 	if err != nil {
-		return nil, errors.Wrap(err, "error creating amqp channel")
+		return ""
 	}
 
-	err = ch.ExchangeDeclare(exchangeName, "topic", true, false, false, false, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "error creating the exchange")
+	if envelope.Subject != "" {
+		return envelope.Subject
 	}
 
-	queue, err := ch.QueueDeclare(queueName, true, false, false, false, nil)
+	// messages sent before envelopes were introduced have no subject
+	event, err := schema.DecodeCalendarEvent(envelope)
 	if err != nil {
-		return nil, errors.Wrap(err, "error creating the queue")
+		return ""
 	}
 
-	err = ch.QueueBind(queue.Name, routingKey, exchangeName, false, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "error binding queue to exchange")
+	return event.ID
+}
+
+// consume records metrics around handling each message
+func (c *CalendarEventWeatherConsumer) consume(ctx context.Context, msg *messaging.Message) error {
+	if !msg.Timestamp.IsZero() {
+		messageLag.WithLabelValues(msg.RoutingKey).Observe(time.Since(msg.Timestamp).Seconds())
 	}
 
-	err = ch.Qos(1, 0, false)
+	err := c.handle(ctx, msg)
+
+	outcome := "success"
 	if err != nil {
-		return nil, errors.Wrap(err, "error configuring prefetch")
+		outcome = "error"
 	}
+	messagesConsumed.WithLabelValues(msg.RoutingKey, outcome).Inc()
 
-	return ch, nil
+	return err
 }
 
-type IncomingEvent struct {
-	ID       string    `json:"id"`
-	Location string    `json:"location"`
-	StartsAt time.Time `json:"startsAt"`
-}
+func (c *CalendarEventWeatherConsumer) handle(ctx context.Context, msg *messaging.Message) error {
+	span := trace.SpanFromContext(ctx)
+
+	c.logger.Info("received a message")
+
+	envelope, err := schema.Decode(msg)
+	if err != nil {
+		c.logger.Error("error decoding message", zap.Error(err))
+		// the body won't parse any better next time
+		return messaging.Permanent(err)
+	}
 
-func (c *CalendarEventWeatherConsumer) worker(messages <-chan amqp.Delivery) {
-	for delivery := range messages {
-		ctx := context.Background()
+	event, err := schema.DecodeCalendarEvent(envelope)
+	if err != nil {
+		c.logger.Error("error decoding event", zap.Error(err))
+		return messaging.Permanent(err)
+	}
+
+	span.SetAttributes(attribute.String("event.id", event.ID))
 
-		c.logger.Info("received a message")
+	// imminent events get the rate limited apis' remaining budget ahead of far-future ones
+	ctx = weather.WithPriority(ctx, weather.PriorityFor(event.StartsAt))
 
-		var event IncomingEvent
-		err := json.Unmarshal(delivery.Body, &event)
+	c.logger.Info(
+		"starting to process event",
+		zap.String("type", envelope.Type),
+		zap.String("eventId", event.ID),
+		zap.Any("event", event),
+	)
+
+	if envelope.Type == schema.EventDeleted {
+		return c.forget(ctx, event.ID)
+	}
 
-		if err != nil {
-			c.logger.Error("error unmarshaling event", zap.Error(err))
-			return
+	if event.Venue == nil || event.Venue.Kind != schema.VenuePhysical {
+		reason := "no_location"
+		if event.Venue != nil {
+			reason = event.Venue.Kind
 		}
 
-		c.logger.Info("starting to process event", zap.String("eventId", event.ID), zap.Any("event", event))
+		c.logger.Info("not fetching weather information for events without a physical location", zap.String("reason", reason))
+		eventsSkipped.WithLabelValues(reason).Inc()
 
-		if time.Until(event.StartsAt).Hours() <= 0 {
-			c.logger.Info("not fetching weather information for events in the past")
-			continue
+		if envelope.Type == schema.EventUpdated {
+			// it may have had a physical location before the update
+			return c.forget(ctx, event.ID)
 		}
+		return nil
+	}
+
+	// updates are treated like new events, the location or time may have changed so both are looked up again
 
-		location, err := c.geocoder.GeocodeLocation(ctx, event.Location)
-		if err != nil {
-			c.logger.Error("error whilst fetching location", zap.Error(err))
-			continue
+	location, err := c.locate(ctx, event)
+	if err != nil {
+		c.logger.Error("error whilst fetching location", zap.Error(err))
+		if errors.Is(err, openweather.ErrLocationNotFound) || errors.Is(err, openweather.ErrBadRequest) {
+			return messaging.Permanent(err)
+		}
+		if errors.Is(err, openweather.ErrCircuitOpen) {
+			// there's no other geocoder to fall back on, hold the event until openweather recovers
+			return messaging.Defer(err)
 		}
+		return err
+	}
+
+	c.logger.Info(
+		"event located",
+		zap.String("location", event.Venue.Address),
+		zap.Bool("confirmed", event.Venue.Place != nil),
+		zap.String("lat", location.Latitude),
+		zap.String("lon", location.Longitude),
+	)
 
-		c.logger.Info(
-			"event location geocoded",
-			zap.String("location", event.Location),
-			zap.String("lat", location.Latitude),
-			zap.String("lon", location.Longitude),
-		)
-
-		weatherResponse, err := c.weatherService.FetchWeather(location, event.StartsAt)
-		if err != nil {
-			c.logger.Error("error whilst fetching weather data", zap.Error(err))
-			continue
+	// past events get the weather observed during them, and those too far ahead an estimate
+	weatherResponse, err := c.weatherService.Forecast(ctx, location, event.StartsAt, event.EndsAt)
+	if errors.Is(err, weather.ErrOutOfRange) {
+		// only when history or estimates are turned off, asking again won't change that
+		c.logger.Info("not fetching weather information for events out of range", zap.Error(err))
+		eventsSkipped.WithLabelValues("out_of_range").Inc()
+
+		if envelope.Type == schema.EventUpdated {
+			// the weather we have is for when it used to be
+			return c.forget(ctx, event.ID)
 		}
+		return nil
+	} else if errors.Is(err, weather.ErrUnavailable) {
+		// every provider is down, rate limited or out of quota, hold the event until one can answer
+		c.logger.Error("no weather provider available", zap.Error(err))
+		return messaging.Defer(err)
+	} else if err != nil {
+		c.logger.Error("error whilst fetching weather data", zap.Error(err))
+		return err
+	}
 
-		c.logger.Info("weather fetched for event", zap.String("eventId", event.ID), zap.Any("weather", weatherResponse))
+	c.logger.Info("weather fetched for event", zap.String("eventId", event.ID), zap.Any("weather", weatherResponse))
 
-		c.logger.Info("caching event weather", zap.String("eventId", event.ID))
+	c.logger.Info("caching event weather", zap.String("eventId", event.ID))
 
-		c.eventStorage.Set(ctx, event.ID, &weather.Event{
-			ID:               event.ID,
-			StartsAt:         event.StartsAt,
-			GeocodedLocation: location,
-			WeatherSummary:   weatherResponse,
-		})
+	err = c.eventStorage.Set(ctx, event.ID, &weather.Event{
+		ID:               event.ID,
+		StartsAt:         event.StartsAt,
+		EndsAt:           event.EndsAt,
+		GeocodedLocation: location,
+		WeatherSummary:   weatherResponse,
+	})
+	if err != nil {
+		c.logger.Error("error whilst caching event weather", zap.Error(err))
+		return err
 	}
+
+	// the forecast is already stored, a failed notification only delays clients seeing it
+	err = c.publisher.PublishWeatherUpdated(ctx, &weather.WeatherUpdated{EventID: event.ID, Weather: weatherResponse})
+	if err != nil {
+		c.logger.Error("error whilst publishing weather update", zap.Error(err))
+	}
+
+	return nil
+}
+
+// locate returns the place the user confirmed for the event's venue, only geocoding its address when they didn't pick one
+func (c *CalendarEventWeatherConsumer) locate(ctx context.Context, event *schema.CalendarEvent) (*weather.GeocodedLocation, error) {
+	place := event.Venue.Place
+	if place == nil {
+		return c.geocoder.GeocodeLocation(ctx, event.Venue.Address)
+	}
+
+	return &weather.GeocodedLocation{
+		Name:      place.Name,
+		Latitude:  strconv.FormatFloat(place.Latitude, 'f', -1, 64),
+		Longitude: strconv.FormatFloat(place.Longitude, 'f', -1, 64),
+		Country:   place.Country,
+		State:     place.State,
+	}, nil
+}
+
+// forget removes the event's weather so it's no longer served or refreshed
+func (c *CalendarEventWeatherConsumer) forget(ctx context.Context, eventID string) error {
+	c.logger.Info("removing event weather", zap.String("eventId", eventID))
+
+	if err := c.eventStorage.Delete(ctx, eventID); err != nil {
+		c.logger.Error("error whilst removing event weather", zap.Error(err))
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	calendarBus "github.com/alexdunne/not-so-smart-cal/calendar/bus"
+	"github.com/alexdunne/not-so-smart-cal/calendar/model"
+	"github.com/alexdunne/not-so-smart-cal/pkg/messaging"
+	"github.com/alexdunne/not-so-smart-cal/pkg/schema"
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/alexdunne/not-so-smart-cal/weather/bus"
+	"github.com/alexdunne/not-so-smart-cal/weather/openweather"
+	"go.uber.org/zap"
+)
+
+type fakeGeocoder struct {
+	mu        sync.Mutex
+	locations []string
+	err       error
+}
+
+func (g *fakeGeocoder) GeocodeLocation(ctx context.Context, location string) (*weather.GeocodedLocation, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.locations = append(g.locations, location)
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	return &weather.GeocodedLocation{Name: location, Latitude: "51.5072", Longitude: "-0.1276", Country: "GB"}, nil
+}
+
+type fakeProvider struct {
+	mu sync.Mutex
+	// err is returned for the next failures forecasts
+	err      error
+	failures int
+	calls    int
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Forecast(ctx context.Context, location *weather.GeocodedLocation, startsAt, endsAt time.Time) (*weather.WeatherSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	if p.failures > 0 {
+		p.failures--
+		return nil, p.err
+	}
+
+	return &weather.WeatherSummary{
+		Source:      weather.SourceForecast,
+		Type:        "Clear",
+		Description: "clear sky in " + location.Name,
+	}, nil
+}
+
+type fakeStorage struct {
+	mu     sync.Mutex
+	events map[string]*weather.Event
+}
+
+func (s *fakeStorage) Set(ctx context.Context, eventID string, value *weather.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events[eventID] = value
+
+	return nil
+}
+
+func (s *fakeStorage) Delete(ctx context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.events, eventID)
+
+	return nil
+}
+
+func (s *fakeStorage) get(eventID string) *weather.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.events[eventID]
+}
+
+// pipeline runs the calendar's publisher and the worker against each other over an in-memory bus
+type pipeline struct {
+	calendar *calendarBus.CalendarPublisher
+	geocoder *fakeGeocoder
+	provider *fakeProvider
+	storage  *fakeStorage
+	updates  chan *schema.WeatherUpdate
+	dead     chan *messaging.Message
+}
+
+func newPipeline(t *testing.T) *pipeline {
+	t.Helper()
+
+	logger := zap.NewNop()
+	memoryBus := messaging.NewMemoryBus()
+
+	p := &pipeline{
+		calendar: calendarBus.NewCalendarPublisher(memoryBus, "calendar", logger),
+		geocoder: &fakeGeocoder{},
+		provider: &fakeProvider{},
+		storage:  &fakeStorage{events: make(map[string]*weather.Event)},
+		updates:  make(chan *schema.WeatherUpdate, 10),
+		dead:     make(chan *messaging.Message, 10),
+	}
+
+	consumer := NewCalendarEventWeatherConsumer(
+		memoryBus,
+		p.geocoder,
+		p.provider,
+		p.storage,
+		bus.NewWeatherPublisher(memoryBus, "weather", logger),
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	run := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(); err != nil {
+				t.Errorf("unexpected error from subscriber: %v", err)
+			}
+		}()
+	}
+
+	run(func() error {
+		return consumer.StartConsumer(ctx, messaging.Binding{
+			Exchange:    "calendar",
+			Queue:       "fetch_weather_for_event",
+			RoutingKeys: []string{"event.created", "event.updated", "event.deleted"},
+			Retry:       messaging.RetryPolicy{MaxAttempts: 2, Backoff: messaging.Backoff{Initial: time.Millisecond, Max: time.Millisecond}},
+			Key:         eventID,
+		})
+	})
+
+	run(func() error {
+		return memoryBus.Subscribe(ctx, messaging.Binding{Exchange: "weather", Queue: "weather_updates", RoutingKeys: []string{bus.WeatherUpdatedRoutingKey}}, func(ctx context.Context, msg *messaging.Message) error {
+			envelope, err := schema.Decode(msg)
+			if err != nil {
+				return err
+			}
+
+			update, err := schema.DecodeWeatherUpdate(envelope)
+			if err != nil {
+				return err
+			}
+
+			p.updates <- update
+			return nil
+		})
+	})
+
+	run(func() error {
+		return memoryBus.Subscribe(ctx, messaging.Binding{Queue: messaging.DeadLetterQueue("fetch_weather_for_event")}, func(ctx context.Context, msg *messaging.Message) error {
+			p.dead <- msg
+			return nil
+		})
+	})
+
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+		memoryBus.Close()
+	})
+
+	// the queues are declared as the subscribers start, anything published before then would be dropped
+	p.waitForQueues(t)
+
+	return p
+}
+
+// waitForQueues sends an event through until its forecast comes back, by then every queue has been declared
+func (p *pipeline) waitForQueues(t *testing.T) {
+	t.Helper()
+
+	startsAt := time.Now().Add(time.Hour)
+	ping := &model.Event{
+		ID:       "ping",
+		Title:    "ping",
+		StartsAt: startsAt,
+		EndsAt:   startsAt.Add(time.Hour),
+		Venue:    model.NewVenue("", &model.Place{Name: "Greenwich", Latitude: 51.4769, Longitude: 0, Country: "GB"}),
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		p.publish(t, model.EventCreated, ping)
+
+		select {
+		case <-p.updates:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	t.Fatal("timed out waiting for the subscribers to start")
+}
+
+func (p *pipeline) publish(t *testing.T, changeType model.EventChangeType, event *model.Event) {
+	t.Helper()
+
+	if err := p.calendar.PublishEventChange(context.Background(), changeType, event); err != nil {
+		t.Fatalf("publishing %s: %v", changeType, err)
+	}
+}
+
+// waitForUpdate returns the next weather update, skipping any left over from waitForQueues
+func (p *pipeline) waitForUpdate(t *testing.T) *schema.WeatherUpdate {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case update := <-p.updates:
+			if update.EventID != "ping" {
+				return update
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a weather update")
+			return nil
+		}
+	}
+}
+
+func (p *pipeline) waitForDeadLetter(t *testing.T) *messaging.Message {
+	t.Helper()
+
+	select {
+	case msg := <-p.dead:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a dead-lettered message")
+		return nil
+	}
+}
+
+// waitFor polls until the condition holds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestCalendarEventsReachTheWorker(t *testing.T) {
+	p := newPipeline(t)
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	event := &model.Event{
+		ID:        "event-1",
+		Title:     "Picnic",
+		Location:  "Hyde Park",
+		StartsAt:  startsAt,
+		EndsAt:    startsAt.Add(3 * time.Hour),
+		CreatedAt: startsAt.Add(-48 * time.Hour),
+		Venue:     model.NewVenue("Hyde Park", &model.Place{Name: "Hyde Park", Latitude: 51.5073, Longitude: -0.1657, Country: "GB"}),
+	}
+
+	p.publish(t, model.EventCreated, event)
+
+	update := p.waitForUpdate(t)
+	if update.EventID != "event-1" {
+		t.Fatalf("expected an update for event-1, got %s", update.EventID)
+	}
+
+	var summary weather.WeatherSummary
+	if err := json.Unmarshal(update.Weather, &summary); err != nil {
+		t.Fatalf("decoding the forecast: %v", err)
+	}
+	if summary.Description != "clear sky in Hyde Park" {
+		t.Fatalf("unexpected forecast %+v", summary)
+	}
+
+	stored := p.storage.get("event-1")
+	if stored == nil || !stored.StartsAt.Equal(event.StartsAt) || stored.GeocodedLocation.Latitude != "51.5073" {
+		t.Fatalf("expected the forecast to be stored at the picked place, got %+v", stored)
+	}
+
+	// the place was picked so the address is never geocoded
+	p.geocoder.mu.Lock()
+	geocoded := len(p.geocoder.locations)
+	p.geocoder.mu.Unlock()
+	if geocoded != 0 {
+		t.Fatalf("expected no geocoding, got %d lookups", geocoded)
+	}
+
+	// moving the event online removes its forecast
+	event.Location = "https://meet.google.com/abc-defg-hij"
+	event.Venue = model.NewVenue(event.Location, nil)
+	p.publish(t, model.EventUpdated, event)
+
+	waitFor(t, "the forecast to be removed", func() bool { return p.storage.get("event-1") == nil })
+}
+
+func TestCalendarEventsWithoutAPlaceAreGeocoded(t *testing.T) {
+	p := newPipeline(t)
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	event := &model.Event{
+		ID:       "event-2",
+		Title:    "Dinner",
+		Location: "Soho",
+		StartsAt: startsAt,
+		EndsAt:   startsAt.Add(2 * time.Hour),
+		Venue:    model.NewVenue("Soho", nil),
+	}
+
+	p.publish(t, model.EventCreated, event)
+
+	if update := p.waitForUpdate(t); update.EventID != "event-2" {
+		t.Fatalf("expected an update for event-2, got %s", update.EventID)
+	}
+
+	p.geocoder.mu.Lock()
+	locations := p.geocoder.locations
+	p.geocoder.mu.Unlock()
+	if len(locations) != 1 || locations[0] != "Soho" {
+		t.Fatalf("expected Soho to be geocoded, got %v", locations)
+	}
+
+	p.publish(t, model.EventDeleted, event)
+
+	waitFor(t, "the forecast to be removed", func() bool { return p.storage.get("event-2") == nil })
+}
+
+func TestCalendarEventsThatCantBeLocatedAreDeadLettered(t *testing.T) {
+	p := newPipeline(t)
+	p.geocoder.err = openweather.ErrLocationNotFound
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	p.publish(t, model.EventCreated, &model.Event{
+		ID:       "event-3",
+		Title:    "Treasure hunt",
+		Location: "Atlantis",
+		StartsAt: startsAt,
+		EndsAt:   startsAt.Add(time.Hour),
+		Venue:    model.NewVenue("Atlantis", nil),
+	})
+
+	msg := p.waitForDeadLetter(t)
+	if msg.Attempts != 1 || msg.Headers["x-original-routing-key"] != "event.created" {
+		t.Fatalf("expected the event to be dead-lettered after one attempt, got %d attempts and headers %v", msg.Attempts, msg.Headers)
+	}
+}
+
+func TestCalendarEventsAreDeferredWhilstNoProviderIsAvailable(t *testing.T) {
+	p := newPipeline(t)
+
+	// failing more times than the retry policy allows, as the rate limiter does when it's out of budget
+	p.provider.mu.Lock()
+	p.provider.err = fmt.Errorf("%w: rate limit would be exceeded", weather.ErrUnavailable)
+	p.provider.failures = 4
+	p.provider.calls = 0
+	p.provider.mu.Unlock()
+
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	p.publish(t, model.EventCreated, &model.Event{
+		ID:       "event-4",
+		Title:    "Barbecue",
+		Location: "Victoria Park",
+		StartsAt: startsAt,
+		EndsAt:   startsAt.Add(4 * time.Hour),
+		Venue:    model.NewVenue("Victoria Park", nil),
+	})
+
+	if update := p.waitForUpdate(t); update.EventID != "event-4" {
+		t.Fatalf("expected an update for event-4, got %s", update.EventID)
+	}
+
+	select {
+	case msg := <-p.dead:
+		t.Fatalf("expected nothing to be dead-lettered, got %s", msg.Body)
+	default:
+	}
+
+	p.provider.mu.Lock()
+	defer p.provider.mu.Unlock()
+
+	if p.provider.calls != 5 {
+		t.Fatalf("expected the forecast to be asked for 5 times, got %d", p.provider.calls)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "worker",
+		Name:      "messages_consumed_total",
+		Help:      "Number of messages consumed, partitioned by routing key and outcome.",
+	}, []string{"routing_key", "outcome"})
+
+	eventsSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "worker",
+		Name:      "events_skipped_total",
+		Help:      "Number of events no forecast was fetched for, partitioned by reason (out_of_range, no_location or virtual).",
+	}, []string{"reason"})
+
+	messageLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "weather",
+		Subsystem: "worker",
+		Name:      "message_lag_seconds",
+		Help:      "Time between a message being published and the worker starting to handle it.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900},
+	}, []string{"routing_key"})
+)
@@ -0,0 +1,136 @@
+package weather
+
+// The conditions every provider's forecasts are mapped onto. They're stored alongside the provider's own description
+// so it can be translated when it's read
+const (
+	ConditionClear           = "clear"
+	ConditionFewClouds       = "few-clouds"
+	ConditionPartlyCloudy    = "partly-cloudy"
+	ConditionCloudy          = "cloudy"
+	ConditionOvercast        = "overcast"
+	ConditionMist            = "mist"
+	ConditionFog             = "fog"
+	ConditionHaze            = "haze"
+	ConditionSmoke           = "smoke"
+	ConditionDust            = "dust"
+	ConditionLightDrizzle    = "light-drizzle"
+	ConditionDrizzle         = "drizzle"
+	ConditionHeavyDrizzle    = "heavy-drizzle"
+	ConditionFreezingDrizzle = "freezing-drizzle"
+	ConditionLightRain       = "light-rain"
+	ConditionRain            = "rain"
+	ConditionHeavyRain       = "heavy-rain"
+	ConditionFreezingRain    = "freezing-rain"
+	ConditionLightShowers    = "light-showers"
+	ConditionShowers         = "showers"
+	ConditionHeavyShowers    = "heavy-showers"
+	ConditionLightSnow       = "light-snow"
+	ConditionSnow            = "snow"
+	ConditionHeavySnow       = "heavy-snow"
+	ConditionSleet           = "sleet"
+	ConditionThunderstorm    = "thunderstorm"
+	ConditionHail            = "thunderstorm-hail"
+	ConditionSquall          = "squall"
+	ConditionTornado         = "tornado"
+	ConditionUnknown         = "unknown"
+)
+
+// descriptions translates each condition, English is left to the providers' own descriptions
+var descriptions = map[string]map[string]string{
+	"de": {
+		ConditionClear:           "klarer Himmel",
+		ConditionFewClouds:       "ein paar Wolken",
+		ConditionPartlyCloudy:    "teilweise bewölkt",
+		ConditionCloudy:          "bewölkt",
+		ConditionOvercast:        "bedeckt",
+		ConditionMist:            "trüb",
+		ConditionFog:             "Nebel",
+		ConditionHaze:            "Dunst",
+		ConditionSmoke:           "Rauch",
+		ConditionDust:            "Staub",
+		ConditionLightDrizzle:    "leichter Nieselregen",
+		ConditionDrizzle:         "Nieselregen",
+		ConditionHeavyDrizzle:    "starker Nieselregen",
+		ConditionFreezingDrizzle: "gefrierender Nieselregen",
+		ConditionLightRain:       "leichter Regen",
+		ConditionRain:            "Regen",
+		ConditionHeavyRain:       "starker Regen",
+		ConditionFreezingRain:    "gefrierender Regen",
+		ConditionLightShowers:    "leichte Regenschauer",
+		ConditionShowers:         "Regenschauer",
+		ConditionHeavyShowers:    "heftige Regenschauer",
+		ConditionLightSnow:       "leichter Schneefall",
+		ConditionSnow:            "Schneefall",
+		ConditionHeavySnow:       "starker Schneefall",
+		ConditionSleet:           "Schneeregen",
+		ConditionThunderstorm:    "Gewitter",
+		ConditionHail:            "Gewitter mit Hagel",
+		ConditionSquall:          "Sturmböen",
+		ConditionTornado:         "Tornado",
+		ConditionUnknown:         "unbekannt",
+	},
+	"es": {
+		ConditionClear:           "cielo claro",
+		ConditionFewClouds:       "algunas nubes",
+		ConditionPartlyCloudy:    "parcialmente nublado",
+		ConditionCloudy:          "nublado",
+		ConditionOvercast:        "cubierto",
+		ConditionMist:            "neblina",
+		ConditionFog:             "niebla",
+		ConditionHaze:            "bruma",
+		ConditionSmoke:           "humo",
+		ConditionDust:            "polvo",
+		ConditionLightDrizzle:    "llovizna ligera",
+		ConditionDrizzle:         "llovizna",
+		ConditionHeavyDrizzle:    "llovizna intensa",
+		ConditionFreezingDrizzle: "llovizna helada",
+		ConditionLightRain:       "lluvia ligera",
+		ConditionRain:            "lluvia",
+		ConditionHeavyRain:       "lluvia intensa",
+		ConditionFreezingRain:    "lluvia helada",
+		ConditionLightShowers:    "chubascos ligeros",
+		ConditionShowers:         "chubascos",
+		ConditionHeavyShowers:    "chubascos fuertes",
+		ConditionLightSnow:       "nevada ligera",
+		ConditionSnow:            "nieve",
+		ConditionHeavySnow:       "nevada intensa",
+		ConditionSleet:           "aguanieve",
+		ConditionThunderstorm:    "tormenta",
+		ConditionHail:            "tormenta con granizo",
+		ConditionSquall:          "turbonada",
+		ConditionTornado:         "tornado",
+		ConditionUnknown:         "desconocido",
+	},
+	"fr": {
+		ConditionClear:           "ciel dégagé",
+		ConditionFewClouds:       "peu nuageux",
+		ConditionPartlyCloudy:    "partiellement nuageux",
+		ConditionCloudy:          "nuageux",
+		ConditionOvercast:        "couvert",
+		ConditionMist:            "brume",
+		ConditionFog:             "brouillard",
+		ConditionHaze:            "brume sèche",
+		ConditionSmoke:           "fumée",
+		ConditionDust:            "poussière",
+		ConditionLightDrizzle:    "bruine légère",
+		ConditionDrizzle:         "bruine",
+		ConditionHeavyDrizzle:    "forte bruine",
+		ConditionFreezingDrizzle: "bruine verglaçante",
+		ConditionLightRain:       "pluie légère",
+		ConditionRain:            "pluie",
+		ConditionHeavyRain:       "forte pluie",
+		ConditionFreezingRain:    "pluie verglaçante",
+		ConditionLightShowers:    "averses légères",
+		ConditionShowers:         "averses",
+		ConditionHeavyShowers:    "fortes averses",
+		ConditionLightSnow:       "légères chutes de neige",
+		ConditionSnow:            "neige",
+		ConditionHeavySnow:       "fortes chutes de neige",
+		ConditionSleet:           "neige fondue",
+		ConditionThunderstorm:    "orage",
+		ConditionHail:            "orage avec grêle",
+		ConditionSquall:          "grains",
+		ConditionTornado:         "tornade",
+		ConditionUnknown:         "inconnu",
+	},
+}
@@ -0,0 +1,87 @@
+// Package fixture provides a weather provider serving fixed forecasts, for tests and for working offline
+package fixture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+)
+
+// defaultSummary is served for every location when no fixtures are loaded
+var defaultSummary = weather.WeatherSummary{
+	Type:        "Clear",
+	Description: "clear sky",
+	Condition:   weather.ConditionClear,
+	Temp:        18,
+	FeelsLike:   18,
+	MinTemp:     18,
+	MaxTemp:     18,
+}
+
+// WeatherService serves the same forecast for a location whatever the time, with no hourly breakdown
+type WeatherService struct {
+	// forecasts are keyed by lower-cased location name, "*" matches any location without its own
+	forecasts map[string]weather.WeatherSummary
+}
+
+var _ weather.Provider = (*WeatherService)(nil)
+
+// NewWeatherService serves a clear sky everywhere
+func NewWeatherService() *WeatherService {
+	return &WeatherService{
+		forecasts: map[string]weather.WeatherSummary{"*": defaultSummary},
+	}
+}
+
+// LoadWeatherService reads the forecasts from a JSON file mapping location names to summaries, for example
+//
+//	{"London": {"type": "Rain", "description": "light rain", "temp": 11, "windSpeed": 4.2}, "*": {...}}
+func LoadWeatherService(path string) (*WeatherService, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading weather fixtures: %w", err)
+	}
+
+	var fixtures map[string]weather.WeatherSummary
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("error parsing weather fixtures: %w", err)
+	}
+
+	forecasts := make(map[string]weather.WeatherSummary, len(fixtures))
+	for name, summary := range fixtures {
+		// fixtures written before events were summarised as a whole only have the one temperature
+		if summary.MinTemp == 0 && summary.MaxTemp == 0 {
+			summary.MinTemp, summary.MaxTemp = summary.Temp, summary.Temp
+		}
+
+		forecasts[strings.ToLower(name)] = summary
+	}
+
+	return &WeatherService{forecasts: forecasts}, nil
+}
+
+func (ws *WeatherService) Name() string {
+	return "fixture"
+}
+
+func (ws *WeatherService) Forecast(
+	ctx context.Context,
+	location *weather.GeocodedLocation,
+	startsAt, endsAt time.Time,
+) (*weather.WeatherSummary, error) {
+	summary, ok := ws.forecasts[strings.ToLower(location.Name)]
+	if !ok {
+		summary, ok = ws.forecasts["*"]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: no fixture for %s", weather.ErrUnavailable, location.Name)
+	}
+
+	return &summary, nil
+}
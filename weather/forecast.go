@@ -0,0 +1,35 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUnavailable is returned when a provider can't be reached or has refused the request, such as when its quota is used up
+	ErrUnavailable = errors.New("weather provider unavailable")
+	// ErrOutOfRange is returned when the time is further ahead than the provider forecasts
+	ErrOutOfRange = errors.New("time is outside of the forecast range")
+)
+
+// Provider forecasts the weather, each implementation wraps a different weather API
+type Provider interface {
+	// Name identifies the provider in logs and metrics
+	Name() string
+	// Forecast summarises the weather expected at the location between the times, see Span
+	Forecast(ctx context.Context, location *GeocodedLocation, startsAt, endsAt time.Time) (*WeatherSummary, error)
+}
+
+// ForecastCache holds provider responses until the provider next refreshes its forecast, so events close to each
+// other share one request
+type ForecastCache interface {
+	// Fetch returns the cached response for the location, calling fetch to fill the cache on a miss
+	Fetch(
+		ctx context.Context,
+		provider string,
+		location *GeocodedLocation,
+		refresh time.Duration,
+		fetch func(ctx context.Context) ([]byte, error),
+	) ([]byte, error)
+}
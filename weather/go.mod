@@ -3,22 +3,25 @@ module github.com/alexdunne/not-so-smart-cal/weather
 go 1.16
 
 require (
-	github.com/gin-gonic/gin v1.7.1 // indirect
+	github.com/alexdunne/not-so-smart-cal/calendar v0.0.0
+	github.com/alexdunne/not-so-smart-cal/pkg v0.0.0
+	github.com/getkin/kin-openapi v0.61.0
+	github.com/gin-gonic/gin v1.7.4
 	github.com/go-playground/validator/v10 v10.6.1 // indirect
-	github.com/go-redis/redis/v8 v8.8.2 // indirect
-	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/google/uuid v1.2.0 // indirect
-	github.com/json-iterator/go v1.1.11 // indirect
-	github.com/leodido/go-urn v1.2.1 // indirect
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
-	github.com/streadway/amqp v1.0.0 // indirect
-	github.com/ugorji/go v1.2.5 // indirect
+	github.com/go-redis/redis/v8 v8.8.2
+	github.com/google/uuid v1.2.0
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
+	go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin v0.24.0
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.24.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	go.uber.org/multierr v1.7.0 // indirect
-	go.uber.org/zap v1.16.0 // indirect
+	go.uber.org/zap v1.16.0
 	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a // indirect
-	golang.org/x/sys v0.0.0-20210514084401-e8d321eab015 // indirect
-	golang.org/x/text v0.3.6 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	golang.org/x/sync v0.0.0-20220907140024-f12130a52804
 )
+
+replace github.com/alexdunne/not-so-smart-cal/pkg => ../pkg
+
+replace github.com/alexdunne/not-so-smart-cal/calendar => ../calendar
@@ -5,49 +5,100 @@ import "time"
 type Event struct {
 	ID               string            `json:"id"`
 	StartsAt         time.Time         `json:"startsAt"`
+	EndsAt           time.Time         `json:"endsAt"` // zero for events stored before the whole event was forecast
 	GeocodedLocation *GeocodedLocation `json:"geocodedLocation"`
 	WeatherSummary   *WeatherSummary   `json:"weatherSummary"`
 }
 
+// WeatherUpdated is published whenever the forecast stored for an event changes
+type WeatherUpdated struct {
+	EventID string          `json:"eventId"`
+	Weather *WeatherSummary `json:"weather"`
+}
+
 type GeocodedLocation struct {
 	Name      string
 	Latitude  string
 	Longitude string
 	Country   string
+	// State is only known for some countries, such as the US
+	State string `json:",omitempty"`
 }
 
+// WeatherSummary is the forecast across the whole of an event. Temperatures are in degrees Celsius, precipitation
+// in millimetres and wind speeds in metres a second
 type WeatherSummary struct {
+	// Source says whether the summary is a forecast, the observed weather or an estimate, see the Source constants
+	Source string `json:"source"`
+	// Confidence is how far the summary can be relied on, see the Confidence constants
+	Confidence string `json:"confidence"`
+	// Type and Description are the condition forecast for most of the event
 	Type        string `json:"type"`
 	Description string `json:"description"`
-	Temp        string `json:"temp"`
+	// Condition identifies the description whichever provider gave it, it's one of the Condition constants
+	Condition string `json:"condition"`
+	// Temp and FeelsLike are the temperatures when the event starts
+	Temp      float64 `json:"temp"`
+	FeelsLike float64 `json:"feelsLike"`
+	MinTemp   float64 `json:"minTemp"`
+	MaxTemp   float64 `json:"maxTemp"`
+	// PrecipitationProbability is the highest chance of precipitation during the event, from 0 to 1
+	PrecipitationProbability float64 `json:"precipitationProbability"`
+	// PrecipitationVolume is the total rain and snow expected during the event
+	PrecipitationVolume float64 `json:"precipitationVolume"`
+	// WindSpeed, WindGust and UVIndex are the highest expected during the event
+	WindSpeed float64 `json:"windSpeed"`
+	WindGust  float64 `json:"windGust"`
+	UVIndex   float64 `json:"uvIndex"`
+	// Humidity is the average relative humidity during the event as a percentage
+	Humidity int `json:"humidity"`
+	// Sunrise and Sunset are for the day the event starts, when the provider gives them
+	Sunrise *time.Time `json:"sunrise,omitempty"`
+	Sunset  *time.Time `json:"sunset,omitempty"`
+	// Alerts are the severe weather warnings in force at some point during the event
+	Alerts []Alert `json:"alerts,omitempty"`
+	// Hourly breaks the forecast down by hour, it's empty when the event is too far ahead for hourly forecasts
+	Hourly []HourlyWeather `json:"hourly,omitempty"`
 }
 
-type WeatherResponse struct {
-	Hourly []WeatherHourlyResponse `json:"hourly"`
-	Daily  []WeatherDailyResponse  `json:"daily"`
-}
+// Where a summary's weather comes from
+const (
+	// SourceForecast is a provider's forecast
+	SourceForecast = "forecast"
+	// SourceHistorical is the weather observed during an event that has already happened
+	SourceHistorical = "historical"
+	// SourceClimate is an estimate from the weather on the same days in previous years, for events too far ahead to
+	// forecast. It's replaced by a forecast once the event is in range
+	SourceClimate = "climate"
+)
 
-type WeatherHourlyResponse struct {
-	Dt        int                  `json:"dt"`
-	Temp      float64              `json:"temp"`
-	FeelsLike float64              `json:"feels_like"`
-	Weather   []WeatherInformation `json:"weather"`
+// How far a summary can be relied on
+const (
+	ConfidenceHigh   = "high"
+	ConfidenceMedium = "medium"
+	ConfidenceLow    = "low"
+)
+
+// ForecastConfidence rates a forecast by how far ahead the event is, forecasts lose accuracy after a few days
+func ForecastConfidence(startsAt time.Time) string {
+	if time.Until(startsAt) < 72*time.Hour {
+		return ConfidenceHigh
+	}
+
+	return ConfidenceMedium
 }
 
-type WeatherDailyResponse struct {
-	Dt   int `json:"dt"`
-	Temp struct {
-		Day   float64 `json:"day"`
-		Night float64 `json:"night"`
-		Eve   float64 `json:"eve"`
-		Morn  float64 `json:"morn"`
-	} `json:"temp"`
-	Weather []WeatherInformation `json:"weather"`
+// Alert is a severe weather warning issued by a national weather service
+type Alert struct {
+	Event       string    `json:"event"`
+	Sender      string    `json:"sender"`
+	Description string    `json:"description"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Tags        []string  `json:"tags,omitempty"`
 }
 
-type WeatherInformation struct {
-	ID          int    `json:"id"`
-	Main        string `json:"main"`
-	Description string `json:"description"`
-	Icon        string `json:"icon"`
+// Overlaps reports whether the alert is in force at any point between from and to
+func (a Alert) Overlaps(from, to time.Time) bool {
+	return a.Start.Before(to) && a.End.After(from)
 }
@@ -0,0 +1,56 @@
+package openmeteo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"go.uber.org/zap"
+)
+
+// get returns the body of an Open-Meteo API's response, checked to be json before it's cached
+func get(
+	ctx context.Context,
+	httpClient *http.Client,
+	logger *zap.Logger,
+	endpoint, baseURL string,
+	query url.Values,
+) (_ []byte, err error) {
+	defer func() {
+		apiRequests.WithLabelValues(endpoint, outcomeLabel(err)).Inc()
+	}()
+
+	requestURL := baseURL + "?" + query.Encode()
+
+	logger.Debug("requesting weather information", zap.String("url", requestURL))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", weather.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: open-meteo responded with %s", weather.ErrUnavailable, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("open-meteo responded with invalid json")
+	}
+
+	return body, nil
+}
@@ -0,0 +1,191 @@
+package openmeteo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// climateYears is how many of the previous years the climate normals are worked out from
+const climateYears = 10
+
+// climateRefreshInterval is how long the years of observations are cached, they only change at the new year
+const climateRefreshInterval = 7 * 24 * time.Hour
+
+// wetDay is the precipitation a day needs for it to count as having rained or snowed
+const wetDay = 1.0
+
+// ClimateService estimates the weather for events too far ahead to forecast from the weather on the same days in
+// previous years
+type ClimateService struct {
+	httpClient *http.Client
+	cache      weather.ForecastCache
+	logger     *zap.Logger
+}
+
+var _ weather.Provider = (*ClimateService)(nil)
+
+func NewClimateService(cache weather.ForecastCache, logger *zap.Logger) *ClimateService {
+	return &ClimateService{
+		httpClient: newHTTPClient(),
+		cache:      cache,
+		logger:     logger,
+	}
+}
+
+func (cs *ClimateService) Name() string {
+	return "open-meteo-climate"
+}
+
+// Forecast estimates the weather for each day of the event from its average in previous years. The chance of
+// precipitation is how many of those years were wet, there's no hourly breakdown, humidity or UV index
+func (cs *ClimateService) Forecast(
+	ctx context.Context,
+	location *weather.GeocodedLocation,
+	startsAt, endsAt time.Time,
+) (_ *weather.WeatherSummary, err error) {
+	ctx, span := tracer.Start(ctx, "ClimateService.Forecast")
+	span.SetAttributes(
+		attribute.String("location.lat", location.Latitude),
+		attribute.String("location.lon", location.Longitude),
+	)
+	defer endSpan(span, &err)
+
+	from, to := weather.Span(startsAt, endsAt)
+
+	body, err := cs.cache.Fetch(ctx, cs.Name(), location, climateRefreshInterval, func(ctx context.Context) ([]byte, error) {
+		return cs.requestClimate(ctx, location)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result *climateResponse
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	byDay := result.byDayOfYear()
+
+	days := make([]weather.DailyWeather, 0)
+	for date := from.UTC().Truncate(24 * time.Hour); date.Before(to); date = date.Add(24 * time.Hour) {
+		if normal, ok := result.normal(byDay[dayOfYear(date)]); ok {
+			normal.Date = date
+			days = append(days, normal)
+		}
+	}
+
+	if len(days) == 0 {
+		return nil, fmt.Errorf("%w: no observations for the days of the event", weather.ErrOutOfRange)
+	}
+
+	return weather.SummariseDays(days), nil
+}
+
+// requestClimate returns the body of the archive API's response with the days of the previous climateYears years
+func (cs *ClimateService) requestClimate(ctx context.Context, location *weather.GeocodedLocation) ([]byte, error) {
+	year := time.Now().UTC().Year()
+
+	query := url.Values{}
+	query.Set("latitude", location.Latitude)
+	query.Set("longitude", location.Longitude)
+	query.Set("daily", "weathercode,temperature_2m_max,temperature_2m_min,precipitation_sum,windspeed_10m_max,windgusts_10m_max")
+	query.Set("windspeed_unit", "ms")
+	query.Set("timeformat", "unixtime")
+	query.Set("timezone", "UTC")
+	query.Set("start_date", fmt.Sprintf("%d-01-01", year-climateYears))
+	query.Set("end_date", fmt.Sprintf("%d-12-31", year-1))
+
+	return get(ctx, cs.httpClient, cs.logger, "climate", "https://archive-api.open-meteo.com/v1/archive", query)
+}
+
+type climateResponse struct {
+	Daily struct {
+		Time          []int64    `json:"time"`
+		WeatherCode   []*int     `json:"weathercode"`
+		MaxTemp       []*float64 `json:"temperature_2m_max"`
+		MinTemp       []*float64 `json:"temperature_2m_min"`
+		Precipitation []*float64 `json:"precipitation_sum"`
+		WindSpeed     []*float64 `json:"windspeed_10m_max"`
+		WindGust      []*float64 `json:"windgusts_10m_max"`
+	} `json:"daily"`
+}
+
+// byDayOfYear groups the indexes of the days observed by their day of the year
+func (r *climateResponse) byDayOfYear() map[string][]int {
+	days := make(map[string][]int)
+	for i, dt := range r.Daily.Time {
+		day := dayOfYear(time.Unix(dt, 0).UTC())
+		days[day] = append(days[day], i)
+	}
+
+	return days
+}
+
+// normal averages the days observed, skipping any without temperatures
+func (r *climateResponse) normal(indexes []int) (weather.DailyWeather, bool) {
+	daily := r.Daily
+
+	var normal weather.DailyWeather
+	codes := make(map[int]int)
+	commonest, observed, wet := -1, 0, 0
+
+	for _, i := range indexes {
+		if i >= len(daily.MinTemp) || i >= len(daily.MaxTemp) || daily.MinTemp[i] == nil || daily.MaxTemp[i] == nil {
+			continue
+		}
+
+		observed++
+		normal.MinTemp += *daily.MinTemp[i]
+		normal.MaxTemp += *daily.MaxTemp[i]
+		normal.PrecipitationVolume += valueAt(daily.Precipitation, i)
+		normal.WindSpeed += valueAt(daily.WindSpeed, i)
+		normal.WindGust += valueAt(daily.WindGust, i)
+
+		if valueAt(daily.Precipitation, i) >= wetDay {
+			wet++
+		}
+
+		if i < len(daily.WeatherCode) && daily.WeatherCode[i] != nil {
+			code := *daily.WeatherCode[i]
+			codes[code]++
+			if commonest == -1 || codes[code] > codes[commonest] {
+				commonest = code
+			}
+		}
+	}
+
+	if observed == 0 {
+		return normal, false
+	}
+
+	n := float64(observed)
+	normal.MinTemp /= n
+	normal.MaxTemp /= n
+	normal.PrecipitationVolume /= n
+	normal.WindSpeed /= n
+	normal.WindGust /= n
+	normal.PrecipitationProbability = float64(wet) / n
+
+	condition := conditionFor(commonest)
+	normal.Type, normal.Description, normal.Condition = condition.Type, condition.Description, condition.Condition
+
+	return normal, true
+}
+
+// dayOfYear identifies the day by its month and day, the 29th of February is counted as the 28th
+func dayOfYear(t time.Time) string {
+	if t.Month() == time.February && t.Day() == 29 {
+		return "02-28"
+	}
+
+	return t.Format("01-02")
+}
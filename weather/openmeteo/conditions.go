@@ -0,0 +1,50 @@
+package openmeteo
+
+import "github.com/alexdunne/not-so-smart-cal/weather"
+
+type condition struct {
+	Type        string
+	Description string
+	Condition   string
+}
+
+// conditions maps the WMO weather interpretation codes Open-Meteo uses onto the same types OpenWeather reports,
+// and the conditions shared by every provider
+var conditions = map[int]condition{
+	0:  {"Clear", "clear sky", weather.ConditionClear},
+	1:  {"Clear", "mainly clear", weather.ConditionFewClouds},
+	2:  {"Clouds", "partly cloudy", weather.ConditionPartlyCloudy},
+	3:  {"Clouds", "overcast", weather.ConditionOvercast},
+	45: {"Fog", "fog", weather.ConditionFog},
+	48: {"Fog", "depositing rime fog", weather.ConditionFog},
+	51: {"Drizzle", "light drizzle", weather.ConditionLightDrizzle},
+	53: {"Drizzle", "moderate drizzle", weather.ConditionDrizzle},
+	55: {"Drizzle", "dense drizzle", weather.ConditionHeavyDrizzle},
+	56: {"Drizzle", "light freezing drizzle", weather.ConditionFreezingDrizzle},
+	57: {"Drizzle", "dense freezing drizzle", weather.ConditionFreezingDrizzle},
+	61: {"Rain", "slight rain", weather.ConditionLightRain},
+	63: {"Rain", "moderate rain", weather.ConditionRain},
+	65: {"Rain", "heavy rain", weather.ConditionHeavyRain},
+	66: {"Rain", "light freezing rain", weather.ConditionFreezingRain},
+	67: {"Rain", "heavy freezing rain", weather.ConditionFreezingRain},
+	71: {"Snow", "slight snow fall", weather.ConditionLightSnow},
+	73: {"Snow", "moderate snow fall", weather.ConditionSnow},
+	75: {"Snow", "heavy snow fall", weather.ConditionHeavySnow},
+	77: {"Snow", "snow grains", weather.ConditionSnow},
+	80: {"Rain", "slight rain showers", weather.ConditionLightShowers},
+	81: {"Rain", "moderate rain showers", weather.ConditionShowers},
+	82: {"Rain", "violent rain showers", weather.ConditionHeavyShowers},
+	85: {"Snow", "slight snow showers", weather.ConditionLightSnow},
+	86: {"Snow", "heavy snow showers", weather.ConditionHeavySnow},
+	95: {"Thunderstorm", "thunderstorm", weather.ConditionThunderstorm},
+	96: {"Thunderstorm", "thunderstorm with slight hail", weather.ConditionHail},
+	99: {"Thunderstorm", "thunderstorm with heavy hail", weather.ConditionHail},
+}
+
+func conditionFor(code int) condition {
+	if c, ok := conditions[code]; ok {
+		return c
+	}
+
+	return condition{"Unknown", "unknown", weather.ConditionUnknown}
+}
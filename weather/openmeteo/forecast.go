@@ -0,0 +1,187 @@
+// Package openmeteo forecasts the weather with the Open-Meteo API, which is free for non-commercial use and needs no key
+package openmeteo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// forecastDays is how far ahead Open-Meteo is asked to forecast, it's the most the API allows
+const forecastDays = 16
+
+// refreshInterval is how often Open-Meteo updates its forecasts, cached responses are kept until then
+const refreshInterval = time.Hour
+
+type WeatherService struct {
+	httpClient *http.Client
+	cache      weather.ForecastCache
+	logger     *zap.Logger
+}
+
+var _ weather.Provider = (*WeatherService)(nil)
+
+func NewWeatherService(cache weather.ForecastCache, logger *zap.Logger) *WeatherService {
+	return &WeatherService{
+		httpClient: newHTTPClient(),
+		cache:      cache,
+		logger:     logger,
+	}
+}
+
+func (ws *WeatherService) Name() string {
+	return "open-meteo"
+}
+
+func (ws *WeatherService) Forecast(
+	ctx context.Context,
+	location *weather.GeocodedLocation,
+	startsAt, endsAt time.Time,
+) (_ *weather.WeatherSummary, err error) {
+	ctx, span := tracer.Start(ctx, "WeatherService.Forecast")
+	span.SetAttributes(
+		attribute.String("location.lat", location.Latitude),
+		attribute.String("location.lon", location.Longitude),
+	)
+	defer endSpan(span, &err)
+
+	from, to := weather.Span(startsAt, endsAt)
+	if time.Until(from) > forecastDays*24*time.Hour {
+		return nil, fmt.Errorf("%w: open-meteo forecasts up to %d days ahead", weather.ErrOutOfRange, forecastDays)
+	}
+
+	result, err := ws.fetchHourlyForecast(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	hours := result.hours(from, to)
+	if len(hours) == 0 {
+		return nil, fmt.Errorf("%w: weather for time after %d was not found", weather.ErrOutOfRange, from.Unix())
+	}
+
+	summary := weather.SummariseHours(hours)
+	result.describe(summary, from)
+
+	return summary, nil
+}
+
+type forecastResponse struct {
+	Hourly struct {
+		Time                     []int64    `json:"time"`
+		Temperature              []float64  `json:"temperature_2m"`
+		WeatherCode              []int      `json:"weathercode"`
+		PrecipitationProbability []*float64 `json:"precipitation_probability"`
+		ApparentTemperature      []*float64 `json:"apparent_temperature"`
+		Precipitation            []*float64 `json:"precipitation"`
+		WindSpeed                []*float64 `json:"windspeed_10m"`
+		WindGust                 []*float64 `json:"windgusts_10m"`
+		UVIndex                  []*float64 `json:"uv_index"`
+		Humidity                 []*float64 `json:"relativehumidity_2m"`
+	} `json:"hourly"`
+	Daily struct {
+		Time    []int64 `json:"time"`
+		Sunrise []int64 `json:"sunrise"`
+		Sunset  []int64 `json:"sunset"`
+	} `json:"daily"`
+}
+
+// valueAt returns the hour's value, zero when the forecast doesn't go that far or has no value for the hour
+func valueAt(values []*float64, i int) float64 {
+	if i >= len(values) || values[i] == nil {
+		return 0
+	}
+
+	return *values[i]
+}
+
+// hours returns the weather for the hours between from and to
+func (r *forecastResponse) hours(from, to time.Time) []weather.HourlyWeather {
+	hourly := r.Hourly
+
+	hours := make([]weather.HourlyWeather, 0)
+	for i, dt := range hourly.Time {
+		if dt < from.Unix() || dt >= to.Unix() {
+			continue
+		}
+
+		if i >= len(hourly.Temperature) || i >= len(hourly.WeatherCode) {
+			break
+		}
+
+		condition := conditionFor(hourly.WeatherCode[i])
+
+		hours = append(hours, weather.HourlyWeather{
+			Time:        time.Unix(dt, 0).UTC(),
+			Type:        condition.Type,
+			Description: condition.Description,
+			Condition:   condition.Condition,
+			Temp:        hourly.Temperature[i],
+			FeelsLike:   valueAt(hourly.ApparentTemperature, i),
+			// the probability is a percentage
+			PrecipitationProbability: valueAt(hourly.PrecipitationProbability, i) / 100,
+			PrecipitationVolume:      valueAt(hourly.Precipitation, i),
+			WindSpeed:                valueAt(hourly.WindSpeed, i),
+			WindGust:                 valueAt(hourly.WindGust, i),
+			UVIndex:                  valueAt(hourly.UVIndex, i),
+			Humidity:                 int(valueAt(hourly.Humidity, i)),
+		})
+	}
+
+	return hours
+}
+
+// describe adds the sunrise and sunset on the day the event starts, open-meteo has no alerts
+func (r *forecastResponse) describe(summary *weather.WeatherSummary, from time.Time) {
+	day := from.Truncate(24 * time.Hour).Unix()
+	for i, dt := range r.Daily.Time {
+		if dt == day && i < len(r.Daily.Sunrise) && i < len(r.Daily.Sunset) {
+			sunrise, sunset := time.Unix(r.Daily.Sunrise[i], 0).UTC(), time.Unix(r.Daily.Sunset[i], 0).UTC()
+			summary.Sunrise, summary.Sunset = &sunrise, &sunset
+			return
+		}
+	}
+}
+
+func (ws *WeatherService) fetchHourlyForecast(ctx context.Context, location *weather.GeocodedLocation) (*forecastResponse, error) {
+	body, err := ws.cache.Fetch(ctx, ws.Name(), location, refreshInterval, func(ctx context.Context) ([]byte, error) {
+		return ws.requestForecast(ctx, location)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response *forecastResponse
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// requestForecast returns the body of the forecast API's response
+func (ws *WeatherService) requestForecast(ctx context.Context, location *weather.GeocodedLocation) ([]byte, error) {
+	query := url.Values{}
+	query.Set("latitude", location.Latitude)
+	query.Set("longitude", location.Longitude)
+	query.Set(
+		"hourly",
+		"temperature_2m,weathercode,precipitation_probability,apparent_temperature,precipitation,"+
+			"windspeed_10m,windgusts_10m,uv_index,relativehumidity_2m",
+	)
+	query.Set("daily", "sunrise,sunset")
+	query.Set("windspeed_unit", "ms")
+	query.Set("timeformat", "unixtime")
+	query.Set("timezone", "UTC")
+	query.Set("forecast_days", fmt.Sprint(forecastDays))
+
+	return get(ctx, ws.httpClient, ws.logger, "forecast", "https://api.open-meteo.com/v1/forecast", query)
+}
@@ -0,0 +1,109 @@
+package openmeteo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// archiveDelay is how long Open-Meteo takes to add observations to its archive, more recent weather is served by
+// the forecast API, which keeps the past few months
+const archiveDelay = 5 * 24 * time.Hour
+
+// HistoryService serves the weather observed during events that have already happened. Each event's days are
+// requested on their own, so responses aren't cached
+type HistoryService struct {
+	httpClient *http.Client
+	logger     *zap.Logger
+}
+
+var _ weather.Provider = (*HistoryService)(nil)
+
+func NewHistoryService(logger *zap.Logger) *HistoryService {
+	return &HistoryService{
+		httpClient: newHTTPClient(),
+		logger:     logger,
+	}
+}
+
+func (hs *HistoryService) Name() string {
+	return "open-meteo-history"
+}
+
+// Forecast returns the weather observed between the times, which must have passed. There's no chance of
+// precipitation, it's 1 for the hours it rained or snowed, and no UV index
+func (hs *HistoryService) Forecast(
+	ctx context.Context,
+	location *weather.GeocodedLocation,
+	startsAt, endsAt time.Time,
+) (_ *weather.WeatherSummary, err error) {
+	ctx, span := tracer.Start(ctx, "HistoryService.Forecast")
+	span.SetAttributes(
+		attribute.String("location.lat", location.Latitude),
+		attribute.String("location.lon", location.Longitude),
+	)
+	defer endSpan(span, &err)
+
+	from, to := weather.Span(startsAt, endsAt)
+	if to.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the event hasn't finished", weather.ErrOutOfRange)
+	}
+
+	body, err := hs.requestHistory(ctx, location, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *forecastResponse
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	hours := result.hours(from, to)
+	if len(hours) == 0 {
+		return nil, fmt.Errorf("%w: weather for time after %d was not found", weather.ErrOutOfRange, from.Unix())
+	}
+
+	for i := range hours {
+		if hours[i].PrecipitationVolume > 0 {
+			hours[i].PrecipitationProbability = 1
+		}
+	}
+
+	summary := weather.SummariseHours(hours)
+	result.describe(summary, from)
+
+	return summary, nil
+}
+
+func (hs *HistoryService) requestHistory(
+	ctx context.Context,
+	location *weather.GeocodedLocation,
+	from, to time.Time,
+) ([]byte, error) {
+	query := url.Values{}
+	query.Set("latitude", location.Latitude)
+	query.Set("longitude", location.Longitude)
+	query.Set("hourly", "temperature_2m,weathercode,apparent_temperature,precipitation,windspeed_10m,windgusts_10m,relativehumidity_2m")
+	query.Set("daily", "sunrise,sunset")
+	query.Set("windspeed_unit", "ms")
+	query.Set("timeformat", "unixtime")
+	query.Set("timezone", "UTC")
+	query.Set("start_date", from.UTC().Format("2006-01-02"))
+	// the end is exclusive, an event ending at midnight doesn't need the next day
+	query.Set("end_date", to.Add(-time.Nanosecond).UTC().Format("2006-01-02"))
+
+	if time.Since(to) < archiveDelay {
+		return get(ctx, hs.httpClient, hs.logger, "history", "https://api.open-meteo.com/v1/forecast", query)
+	}
+
+	return get(ctx, hs.httpClient, hs.logger, "history", "https://archive-api.open-meteo.com/v1/archive", query)
+}
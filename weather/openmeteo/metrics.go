@@ -0,0 +1,21 @@
+package openmeteo
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "weather",
+	Subsystem: "openmeteo",
+	Name:      "requests_total",
+	Help:      "Number of calls made to the Open-Meteo APIs, partitioned by endpoint (forecast, history or climate) and outcome.",
+}, []string{"endpoint", "outcome"})
+
+func outcomeLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
@@ -0,0 +1,29 @@
+package openmeteo
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/alexdunne/not-so-smart-cal/weather/openmeteo")
+
+// newHTTPClient returns a client that creates a span for every outbound request and propagates the trace context
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+}
+
+// endSpan records the error, if any, and ends the span. It's intended to be deferred with a pointer to the named error result
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,206 @@
+package openweather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned without calling OpenWeather whilst the circuit breaker is open.
+// It wraps weather.ErrUnavailable so the next provider is tried instead
+var ErrCircuitOpen = fmt.Errorf("%w: openweather circuit breaker is open", weather.ErrUnavailable)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitHalfOpen:
+		return "half-open"
+	case circuitOpen:
+		return "open"
+	}
+
+	return "closed"
+}
+
+// callOutcome is what a call tells the breaker about OpenWeather's health
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// callAbandoned calls ended before OpenWeather answered for reasons of their own, such as the caller giving up
+	callAbandoned
+)
+
+// breaker stops calls to OpenWeather once enough of them fail in a row, failing fast rather than waiting on it.
+// After the cooldown it lets a few probes through, closing again once they all succeed and reopening if any fail
+type breaker struct {
+	failures int
+	cooldown time.Duration
+	probes   int
+	logger   *zap.Logger
+
+	mu    sync.Mutex
+	state circuitState
+	// generation changes with the state so calls that started under an earlier state are ignored
+	generation uint64
+	// consecutive counts the failures in a row whilst closed
+	consecutive int
+	openedAt    time.Time
+	// probing and succeeded count the probes in flight and those that succeeded whilst half-open
+	probing   int
+	succeeded int
+}
+
+func newBreaker(cfg Config, logger *zap.Logger) *breaker {
+	probes := cfg.BreakerProbes
+	if probes < 1 {
+		probes = 1
+	}
+
+	breakerState.Set(float64(circuitClosed))
+
+	return &breaker{
+		failures: cfg.BreakerFailures,
+		cooldown: cfg.BreakerCooldown,
+		probes:   probes,
+		logger:   logger,
+	}
+}
+
+// allow reports whether a call may be made, returning the generation to record its outcome against
+func (b *breaker) allow() (uint64, error) {
+	if b.failures < 1 {
+		// disabled
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return 0, ErrCircuitOpen
+		}
+		b.transition(circuitHalfOpen)
+		fallthrough
+
+	case circuitHalfOpen:
+		if b.probing >= b.probes {
+			return 0, ErrCircuitOpen
+		}
+		b.probing++
+	}
+
+	return b.generation, nil
+}
+
+// record counts the outcome of a call allow let through
+func (b *breaker) record(generation uint64, outcome callOutcome) {
+	if b.failures < 1 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case circuitClosed:
+		switch outcome {
+		case callSucceeded:
+			b.consecutive = 0
+		case callFailed:
+			b.consecutive++
+			if b.consecutive >= b.failures {
+				b.transition(circuitOpen)
+			}
+		}
+
+	case circuitHalfOpen:
+		b.probing--
+
+		switch outcome {
+		case callSucceeded:
+			b.succeeded++
+			if b.succeeded >= b.probes {
+				b.transition(circuitClosed)
+			}
+		case callFailed:
+			b.transition(circuitOpen)
+		}
+	}
+}
+
+// ready returns ErrCircuitOpen whilst the breaker is open and not yet due to probe OpenWeather again
+func (b *breaker) ready() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen && time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+
+	return nil
+}
+
+// transition moves the breaker to the state, it must be called with the lock held
+func (b *breaker) transition(state circuitState) {
+	b.logger.Warn(
+		"openweather circuit breaker changed state",
+		zap.Stringer("from", b.state),
+		zap.Stringer("to", state),
+	)
+
+	b.state = state
+	b.generation++
+	b.consecutive = 0
+	b.probing = 0
+	b.succeeded = 0
+	if state == circuitOpen {
+		b.openedAt = time.Now()
+	}
+
+	breakerState.Set(float64(state))
+	breakerTransitions.WithLabelValues(state.String()).Inc()
+}
+
+// outcomeOf classifies a call's error by what it says about OpenWeather's health
+func outcomeOf(ctx context.Context, err error) callOutcome {
+	var apiErr *APIError
+
+	switch {
+	case err == nil:
+		return callSucceeded
+	case ctx.Err() != nil:
+		return callAbandoned
+	case errors.As(err, &apiErr):
+		// OpenWeather answered a request it didn't like, so it's up
+		if errors.Is(err, ErrBadRequest) {
+			return callSucceeded
+		}
+		return callFailed
+	case errors.Is(err, weather.ErrUnavailable):
+		// refused by the limiter without reaching OpenWeather
+		return callAbandoned
+	}
+
+	// timeouts, connection errors and invalid responses
+	return callFailed
+}
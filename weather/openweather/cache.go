@@ -0,0 +1,189 @@
+package openweather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrNotFound is returned when a location isn't in the cache
+var ErrNotFound = errors.New("no results found")
+
+// CacheConfig configures how long geocoded locations are remembered for
+type CacheConfig struct {
+	Size        int           `yaml:"size" env:"GEOCODE_CACHE_SIZE" default:"1000" usage:"most geocoded locations each process keeps in memory"`
+	TTL         time.Duration `yaml:"ttl" env:"GEOCODE_CACHE_TTL" default:"720h" usage:"how long a geocoded location is cached for"`
+	NegativeTTL time.Duration `yaml:"negativeTtl" env:"GEOCODE_CACHE_NEGATIVE_TTL" default:"15m" usage:"how long a location that couldn't be geocoded is remembered for"`
+	// CandidatesTTL is kept short as the places matching a search are only wanted whilst someone is typing
+	CandidatesTTL time.Duration `yaml:"candidatesTtl" env:"GEOCODE_CANDIDATES_CACHE_TTL" default:"10m" usage:"how long the places matching a location search are cached for"`
+}
+
+// Storage remembers geocoded locations, along with the locations that had no matches
+type Storage interface {
+	// Get returns ErrNotFound when the location isn't stored and ErrLocationNotFound when it's known to have no matches
+	Get(ctx context.Context, location string) (*weather.GeocodedLocation, error)
+	Set(ctx context.Context, location string, value *weather.GeocodedLocation) error
+	SetNotFound(ctx context.Context, location string) error
+	// GetCandidates returns ErrNotFound when the query's matches aren't stored
+	GetCandidates(ctx context.Context, query string) ([]*weather.GeocodedLocation, error)
+	SetCandidates(ctx context.Context, query string, candidates []*weather.GeocodedLocation) error
+}
+
+// geocodeEntry is what's cached for a location, Location is nil when it had no matches
+type geocodeEntry struct {
+	Location *weather.GeocodedLocation `json:"location,omitempty"`
+}
+
+// Cache keeps geocoded locations in an in-process LRU in front of redis, which is shared by every replica.
+// Locations are normalised so differences in case and spacing share an entry
+type Cache struct {
+	local         *lru
+	redisClient   *redis.Client
+	ttl           time.Duration
+	negativeTTL   time.Duration
+	candidatesTTL time.Duration
+}
+
+var _ Storage = (*Cache)(nil)
+
+func NewCache(redisClient *redis.Client, cfg CacheConfig) *Cache {
+	return &Cache{
+		local:         newLRU(cfg.Size),
+		redisClient:   redisClient,
+		ttl:           cfg.TTL,
+		negativeTTL:   cfg.NegativeTTL,
+		candidatesTTL: cfg.CandidatesTTL,
+	}
+}
+
+func (c *Cache) Get(ctx context.Context, location string) (*weather.GeocodedLocation, error) {
+	key := normaliseLocation(location)
+
+	if entry, ok := c.local.get(key); ok {
+		geocodeCacheLookups.WithLabelValues("local", lookupResult(entry)).Inc()
+		return entry.result()
+	}
+	geocodeCacheLookups.WithLabelValues("local", "miss").Inc()
+
+	var val *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		val = pipe.Get(ctx, redisKey(key))
+		ttl = pipe.PTTL(ctx, redisKey(key))
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		geocodeCacheLookups.WithLabelValues("redis", "miss").Inc()
+		return nil, ErrNotFound
+	} else if err != nil {
+		geocodeCacheLookups.WithLabelValues("redis", "error").Inc()
+		return nil, err
+	}
+
+	var entry geocodeEntry
+	if err := json.Unmarshal([]byte(val.Val()), &entry); err != nil {
+		geocodeCacheLookups.WithLabelValues("redis", "error").Inc()
+		return nil, err
+	}
+	geocodeCacheLookups.WithLabelValues("redis", lookupResult(&entry)).Inc()
+
+	// the local copy expires with the shared one
+	if ttl.Val() > 0 {
+		c.local.set(key, &entry, time.Now().Add(ttl.Val()))
+	}
+
+	return entry.result()
+}
+
+func (c *Cache) Set(ctx context.Context, location string, value *weather.GeocodedLocation) error {
+	return c.store(ctx, location, &geocodeEntry{Location: value}, c.ttl)
+}
+
+// SetNotFound remembers that the location had no matches, for a shorter time so new places and fixed typos show up
+func (c *Cache) SetNotFound(ctx context.Context, location string) error {
+	return c.store(ctx, location, &geocodeEntry{}, c.negativeTTL)
+}
+
+func (c *Cache) store(ctx context.Context, location string, entry *geocodeEntry, ttl time.Duration) error {
+	key := normaliseLocation(location)
+
+	jsonVal, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.local.set(key, entry, time.Now().Add(ttl))
+
+	return c.redisClient.Set(ctx, redisKey(key), string(jsonVal), ttl).Err()
+}
+
+// GetCandidates returns the places cached for a search. They're only kept in redis, searches are spread across
+// every replica and are rarely repeated on the same one
+func (c *Cache) GetCandidates(ctx context.Context, query string) ([]*weather.GeocodedLocation, error) {
+	val, err := c.redisClient.Get(ctx, candidatesRedisKey(normaliseLocation(query))).Result()
+	if errors.Is(err, redis.Nil) {
+		candidatesCacheLookups.WithLabelValues("miss").Inc()
+		return nil, ErrNotFound
+	} else if err != nil {
+		candidatesCacheLookups.WithLabelValues("error").Inc()
+		return nil, err
+	}
+
+	var candidates []*weather.GeocodedLocation
+	if err := json.Unmarshal([]byte(val), &candidates); err != nil {
+		candidatesCacheLookups.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	candidatesCacheLookups.WithLabelValues("hit").Inc()
+
+	return candidates, nil
+}
+
+// SetCandidates caches the places matching a search, searches without any matches are cached too
+func (c *Cache) SetCandidates(ctx context.Context, query string, candidates []*weather.GeocodedLocation) error {
+	if candidates == nil {
+		candidates = []*weather.GeocodedLocation{}
+	}
+
+	jsonVal, err := json.Marshal(candidates)
+	if err != nil {
+		return err
+	}
+
+	return c.redisClient.Set(ctx, candidatesRedisKey(normaliseLocation(query)), string(jsonVal), c.candidatesTTL).Err()
+}
+
+// result returns the cached location, or ErrLocationNotFound for a negative entry
+func (e *geocodeEntry) result() (*weather.GeocodedLocation, error) {
+	if e.Location == nil {
+		return nil, ErrLocationNotFound
+	}
+
+	return e.Location, nil
+}
+
+func lookupResult(entry *geocodeEntry) string {
+	if entry.Location == nil {
+		return "negative_hit"
+	}
+
+	return "hit"
+}
+
+func redisKey(key string) string {
+	return "geocode:" + key
+}
+
+func candidatesRedisKey(key string) string {
+	return "geocode-candidates:" + key
+}
+
+// normaliseLocation lower cases the location and collapses its whitespace, so " London " and "LONDON" are the same
+func normaliseLocation(location string) string {
+	return strings.Join(strings.Fields(strings.ToLower(location)), " ")
+}
@@ -0,0 +1,242 @@
+package openweather
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBaseURL = "https://api.openweathermap.org"
+	userAgent      = "not-so-smart-cal-weather/1.0 (+https://github.com/alexdunne/not-so-smart-cal)"
+
+	// retryDelay is doubled for every retry, maxRetryAfter caps how long a Retry-After header is respected for
+	retryDelay    = 250 * time.Millisecond
+	maxRetryAfter = 5 * time.Second
+)
+
+var (
+	// ErrUnauthorized is returned when OpenWeather rejects the API key, usually because it's wrong or the plan has lapsed
+	ErrUnauthorized = fmt.Errorf("%w: openweather rejected the api key", weather.ErrUnavailable)
+	// ErrRateLimited is returned when OpenWeather is throttling requests despite the limiter
+	ErrRateLimited = fmt.Errorf("%w: openweather rate limit exceeded", weather.ErrUnavailable)
+	// ErrServer is returned when OpenWeather fails to handle a request
+	ErrServer = fmt.Errorf("%w: openweather server error", weather.ErrUnavailable)
+	// ErrBadRequest is returned for any other 4xx, retrying or failing over won't help
+	ErrBadRequest = errors.New("openweather rejected the request")
+)
+
+// APIError is returned when OpenWeather responds with a status other than 2xx.
+// It unwraps to ErrUnauthorized, ErrRateLimited, ErrServer or ErrBadRequest depending on the status
+type APIError struct {
+	Endpoint   string
+	StatusCode int
+	// Message is the explanation OpenWeather includes in the body of most errors
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("openweather %s responded with %d: %s", e.Endpoint, e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServer
+	}
+
+	return ErrBadRequest
+}
+
+// retryable reports whether the same request might succeed if it's made again
+func (e *APIError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client makes GET requests to the OpenWeather API. Each attempt waits on the limiter and has its own timeout,
+// failures that might be temporary are retried with backoff.
+// Calls go through a circuit breaker so they fail fast with ErrCircuitOpen whilst OpenWeather is down
+type Client struct {
+	httpClient *http.Client
+	breaker    *breaker
+	baseURL    string
+	limiter    Limiter
+	logger     *zap.Logger
+	apiKey     string
+	timeout    time.Duration
+	retries    int
+}
+
+func NewClient(cfg Config, limiter Limiter, logger *zap.Logger) *Client {
+	return &Client{
+		httpClient: newHTTPClient(),
+		breaker:    newBreaker(cfg, logger),
+		baseURL:    defaultBaseURL,
+		limiter:    limiter,
+		logger:     logger,
+		apiKey:     cfg.APIKey,
+		timeout:    cfg.Timeout,
+		retries:    cfg.Retries,
+	}
+}
+
+// Name identifies the client in readiness checks
+func (c *Client) Name() string {
+	return "openweather"
+}
+
+// Ready returns ErrCircuitOpen whilst the circuit breaker is open
+func (c *Client) Ready() error {
+	return c.breaker.ready()
+}
+
+// get requests the path with the query, returning the body once it has been checked to be json.
+// The endpoint names the API in errors and metrics
+func (c *Client) get(ctx context.Context, endpoint, path string, query url.Values) ([]byte, error) {
+	generation, err := c.breaker.allow()
+	if err != nil {
+		breakerRejections.WithLabelValues(endpoint).Inc()
+		return nil, err
+	}
+
+	body, err := c.retry(ctx, endpoint, path, query)
+	c.breaker.record(generation, outcomeOf(ctx, err))
+
+	return body, err
+}
+
+// retry makes attempts until one succeeds, fails in a way that won't improve or the retries run out
+func (c *Client) retry(ctx context.Context, endpoint, path string, query url.Values) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := c.attempt(ctx, endpoint, path, query)
+		apiRequests.WithLabelValues(endpoint, outcomeLabel(err)).Inc()
+		if err == nil {
+			return body, nil
+		}
+
+		if attempt >= c.retries || !retryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		delay := retryDelay << attempt
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+		if retryAfter > 0 {
+			if retryAfter > maxRetryAfter {
+				// waiting that long would hold up the caller, let it fail over to another provider instead
+				return nil, err
+			}
+			delay = retryAfter
+		}
+
+		c.logger.Warn(
+			"openweather request failed, retrying",
+			zap.String("endpoint", endpoint),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt makes a single request, returning how long OpenWeather asked to wait before retrying if it did
+func (c *Client) attempt(ctx context.Context, endpoint, path string, query url.Values) ([]byte, time.Duration, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	params := url.Values{}
+	for key, values := range query {
+		params[key] = values
+	}
+	params.Set("appid", c.apiKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	// the query is logged without the key
+	c.logger.Debug("requesting openweather", zap.String("endpoint", endpoint), zap.String("query", query.Encode()))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{Endpoint: endpoint, StatusCode: resp.StatusCode, Message: errorMessage(body, resp.Status)}
+
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+
+		return nil, time.Duration(retryAfter) * time.Second, apiErr
+	}
+
+	if !json.Valid(body) {
+		return nil, 0, fmt.Errorf("openweather %s responded with invalid json", endpoint)
+	}
+
+	return body, 0, nil
+}
+
+// retryable reports whether the request failed in a way that making it again could fix
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.retryable()
+	}
+
+	// a full quota won't have recovered in a few hundred milliseconds
+	if errors.Is(err, weather.ErrUnavailable) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// timeouts of the attempt, connection errors and the like
+	return true
+}
+
+// errorMessage reads the message from an OpenWeather error body, falling back to the status
+func errorMessage(body []byte, status string) string {
+	var response struct {
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal(body, &response); err != nil || response.Message == "" {
+		return status
+	}
+
+	return response.Message
+}
@@ -0,0 +1,71 @@
+package openweather
+
+import "github.com/alexdunne/not-so-smart-cal/weather"
+
+// conditions maps OpenWeather's condition ids onto the conditions shared by every provider,
+// see https://openweathermap.org/weather-conditions
+var conditions = map[int]string{
+	200: weather.ConditionThunderstorm,
+	201: weather.ConditionThunderstorm,
+	202: weather.ConditionThunderstorm,
+	210: weather.ConditionThunderstorm,
+	211: weather.ConditionThunderstorm,
+	212: weather.ConditionThunderstorm,
+	221: weather.ConditionThunderstorm,
+	230: weather.ConditionThunderstorm,
+	231: weather.ConditionThunderstorm,
+	232: weather.ConditionThunderstorm,
+	300: weather.ConditionLightDrizzle,
+	301: weather.ConditionDrizzle,
+	302: weather.ConditionHeavyDrizzle,
+	310: weather.ConditionLightDrizzle,
+	311: weather.ConditionDrizzle,
+	312: weather.ConditionHeavyDrizzle,
+	313: weather.ConditionShowers,
+	314: weather.ConditionHeavyShowers,
+	321: weather.ConditionShowers,
+	500: weather.ConditionLightRain,
+	501: weather.ConditionRain,
+	502: weather.ConditionHeavyRain,
+	503: weather.ConditionHeavyRain,
+	504: weather.ConditionHeavyRain,
+	511: weather.ConditionFreezingRain,
+	520: weather.ConditionLightShowers,
+	521: weather.ConditionShowers,
+	522: weather.ConditionHeavyShowers,
+	531: weather.ConditionShowers,
+	600: weather.ConditionLightSnow,
+	601: weather.ConditionSnow,
+	602: weather.ConditionHeavySnow,
+	611: weather.ConditionSleet,
+	612: weather.ConditionSleet,
+	613: weather.ConditionSleet,
+	615: weather.ConditionSleet,
+	616: weather.ConditionSleet,
+	620: weather.ConditionLightSnow,
+	621: weather.ConditionSnow,
+	622: weather.ConditionHeavySnow,
+	701: weather.ConditionMist,
+	711: weather.ConditionSmoke,
+	721: weather.ConditionHaze,
+	731: weather.ConditionDust,
+	741: weather.ConditionFog,
+	751: weather.ConditionDust,
+	761: weather.ConditionDust,
+	762: weather.ConditionDust,
+	771: weather.ConditionSquall,
+	781: weather.ConditionTornado,
+	800: weather.ConditionClear,
+	801: weather.ConditionFewClouds,
+	802: weather.ConditionPartlyCloudy,
+	803: weather.ConditionCloudy,
+	804: weather.ConditionOvercast,
+}
+
+func conditionFor(id int) string {
+	if condition, ok := conditions[id]; ok {
+		return condition
+	}
+
+	return weather.ConditionUnknown
+}
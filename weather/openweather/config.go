@@ -0,0 +1,28 @@
+package openweather
+
+import (
+	"context"
+	"time"
+)
+
+// Config configures access to the OpenWeather API
+type Config struct {
+	APIKey string `yaml:"apiKey" env:"OPEN_WEATHER_API_KEY" required:"true"`
+
+	// the defaults match the free plan, the limits are shared by every replica through redis
+	RateLimit  int `yaml:"rateLimit" env:"OPEN_WEATHER_RATE_LIMIT" default:"60" usage:"most calls to OpenWeather per minute"`
+	DailyQuota int `yaml:"dailyQuota" env:"OPEN_WEATHER_DAILY_QUOTA" default:"1000" usage:"most calls to OpenWeather per UTC day"`
+
+	Timeout time.Duration `yaml:"timeout" env:"OPEN_WEATHER_TIMEOUT" default:"5s" usage:"how long each request to OpenWeather may take"`
+	Retries int           `yaml:"retries" env:"OPEN_WEATHER_RETRIES" default:"2" usage:"how many times a failed request to OpenWeather is retried"`
+
+	// the circuit breaker is disabled when BreakerFailures is zero
+	BreakerFailures int           `yaml:"breakerFailures" env:"OPEN_WEATHER_BREAKER_FAILURES" default:"5" usage:"failed calls to OpenWeather in a row that open the circuit breaker"`
+	BreakerCooldown time.Duration `yaml:"breakerCooldown" env:"OPEN_WEATHER_BREAKER_COOLDOWN" default:"30s" usage:"how long the circuit breaker stays open before probing OpenWeather again"`
+	BreakerProbes   int           `yaml:"breakerProbes" env:"OPEN_WEATHER_BREAKER_PROBES" default:"1" usage:"calls let through to probe OpenWeather, the circuit breaker closes once they all succeed"`
+}
+
+// Limiter keeps calls to the API within its rate limit and quota, it's waited on before every request
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
@@ -5,73 +5,81 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/alexdunne/not-so-smart-cal/weather"
-	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
 type GeocodeService struct {
-	storage           Storage
-	logger            *zap.Logger
-	openWeatherAPIKey string
+	client  *Client
+	storage Storage
+	logger  *zap.Logger
 }
 
-func NewGeocodeService(redisClient *redis.Client, logger *zap.Logger, openWeatherAPIKey string) *GeocodeService {
+func NewGeocodeService(client *Client, storage Storage, logger *zap.Logger) *GeocodeService {
 	return &GeocodeService{
-		storage:           &Cache{redisClient: redisClient, storageKey: "geocoded_locations"},
-		logger:            logger,
-		openWeatherAPIKey: openWeatherAPIKey,
+		client:  client,
+		storage: storage,
+		logger:  logger,
 	}
 }
 
+// maxCandidates is the most matches OpenWeather returns for a query
+const maxCandidates = 5
+
 type geocodedResponseItem struct {
 	Name      string      `json:"name"`
 	Latitude  json.Number `json:"lat"`
 	Longitude json.Number `json:"lon"`
 	Country   string      `json:"country"`
+	State     string      `json:"state"`
+}
+
+func (i *geocodedResponseItem) location() *weather.GeocodedLocation {
+	return &weather.GeocodedLocation{
+		Name:      i.Name,
+		Latitude:  string(i.Latitude),
+		Longitude: string(i.Longitude),
+		Country:   i.Country,
+		State:     i.State,
+	}
 }
 
 // GeocodeLocation attempts to geocode a given location string
-func (gs *GeocodeService) GeocodeLocation(ctx context.Context, location string) (*weather.GeocodedLocation, error) {
-	if result, err := gs.storage.Get(ctx, location); err != nil {
-		if !errors.Is(err, ErrNotFound) {
-			gs.logger.Error("error whilst attempting to get location for storage", zap.Error(err))
-		}
-	} else {
+func (gs *GeocodeService) GeocodeLocation(ctx context.Context, location string) (_ *weather.GeocodedLocation, err error) {
+	ctx, span := tracer.Start(ctx, "GeocodeService.GeocodeLocation")
+	span.SetAttributes(attribute.String("location.query", location))
+	defer endSpan(span, &err)
+
+	result, err := gs.storage.Get(ctx, location)
+	switch {
+	case err == nil:
 		return result, nil
+	case errors.Is(err, ErrLocationNotFound):
+		// looked up recently without any matches, asking again won't help
+		return nil, fmt.Errorf("%w: %s", ErrLocationNotFound, location)
+	case !errors.Is(err, ErrNotFound):
+		gs.logger.Error("error whilst attempting to get location for storage", zap.Error(err))
 	}
 
-	url := fmt.Sprintf(
-		"http://api.openweathermap.org/geo/1.0/direct?q=%s&limit=1&appid=%s",
-		location,
-		gs.openWeatherAPIKey,
-	)
-
-	resp, err := http.Get(url)
+	locations, err := gs.requestLocations(ctx, location, 1)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var locations []*geocodedResponseItem
-
-	if err := json.NewDecoder(resp.Body).Decode(&locations); err != nil {
-		return nil, err
-	}
 
 	if len(locations) == 0 {
-		return nil, fmt.Errorf("no geocoded locations could be found for %s", location)
-	}
+		if err := gs.storage.SetNotFound(ctx, location); err != nil {
+			gs.logger.Error("error whilst attempting to save missing location for storage", zap.Error(err))
+		}
 
-	geocodedLocation := &weather.GeocodedLocation{
-		Name:      locations[0].Name,
-		Latitude:  string(locations[0].Latitude),
-		Longitude: string(locations[0].Longitude),
-		Country:   locations[0].Country,
+		return nil, fmt.Errorf("%w: %s", ErrLocationNotFound, location)
 	}
 
+	geocodedLocation := locations[0].location()
+
 	if err := gs.storage.Set(ctx, location, geocodedLocation); err != nil {
 		gs.logger.Error("error whilst attempting to save location for storage", zap.Error(err))
 	}
@@ -79,45 +87,62 @@ func (gs *GeocodeService) GeocodeLocation(ctx context.Context, location string)
 	return geocodedLocation, nil
 }
 
-type Storage interface {
-	Get(ctx context.Context, key string) (*weather.GeocodedLocation, error)
-	Set(ctx context.Context, key string, value *weather.GeocodedLocation) error
-}
+// Candidates returns up to limit places matching the query, best match first, so the user can pick the one they meant.
+// As they're requested whilst the user types, every match is cached for a short time whatever the limit
+func (gs *GeocodeService) Candidates(ctx context.Context, query string, limit int) (_ []*weather.GeocodedLocation, err error) {
+	ctx, span := tracer.Start(ctx, "GeocodeService.Candidates")
+	span.SetAttributes(attribute.String("location.query", query))
+	defer endSpan(span, &err)
 
-var ErrNotFound = errors.New("no results found")
+	if limit < 1 || limit > maxCandidates {
+		limit = maxCandidates
+	}
 
-// todo - use a LRU with a capacity instead
-type Cache struct {
-	redisClient *redis.Client
-	storageKey  string
-}
+	candidates, err := gs.storage.GetCandidates(ctx, query)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			gs.logger.Error("error whilst attempting to get location candidates from storage", zap.Error(err))
+		}
 
-func (c *Cache) Get(ctx context.Context, key string) (*weather.GeocodedLocation, error) {
-	val, err := c.redisClient.HGet(ctx, c.storageKey, key).Result()
+		items, err := gs.requestLocations(ctx, query, maxCandidates)
+		if err != nil {
+			return nil, err
+		}
 
-	if err == redis.Nil {
-		return nil, ErrNotFound
-	} else if err != nil {
-		return nil, err
+		candidates = make([]*weather.GeocodedLocation, len(items))
+		for i, item := range items {
+			candidates[i] = item.location()
+		}
+
+		if err := gs.storage.SetCandidates(ctx, query, candidates); err != nil {
+			gs.logger.Error("error whilst attempting to save location candidates for storage", zap.Error(err))
+		}
 	}
 
-	var result *weather.GeocodedLocation
-	if err := json.Unmarshal([]byte(val), &result); err != nil {
-		return nil, err
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
 	}
 
-	return result, err
+	return candidates, nil
 }
 
-func (c *Cache) Set(ctx context.Context, key string, value *weather.GeocodedLocation) error {
-	jsonVal, err := json.Marshal(value)
+func (gs *GeocodeService) requestLocations(ctx context.Context, location string, limit int) ([]*geocodedResponseItem, error) {
+	body, err := gs.client.get(ctx, "geocode", "/geo/1.0/direct", url.Values{
+		"q":     {location},
+		"limit": {strconv.Itoa(limit)},
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := c.redisClient.HSet(ctx, c.storageKey, key, string(jsonVal)).Err(); err != nil {
-		return err
+	var locations []*geocodedResponseItem
+
+	if err := json.Unmarshal(body, &locations); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return locations, nil
 }
+
+// ErrLocationNotFound is returned when OpenWeather has no matches for a location
+var ErrLocationNotFound = errors.New("no geocoded locations could be found")
@@ -0,0 +1,80 @@
+package openweather
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru holds up to capacity entries, evicting the least recently used once it's full. Expired entries are never returned
+type lru struct {
+	capacity int
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	// order has the most recently used entry at the front
+	order *list.List
+}
+
+type lruItem struct {
+	key       string
+	entry     *geocodeEntry
+	expiresAt time.Time
+}
+
+func newLRU(capacity int) *lru {
+	return &lru{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (l *lru) get(key string) (*geocodeEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	item := elem.Value.(*lruItem)
+	if time.Now().After(item.expiresAt) {
+		l.remove(elem)
+		return nil, false
+	}
+
+	l.order.MoveToFront(elem)
+
+	return item.entry, true
+}
+
+func (l *lru) set(key string, entry *geocodeEntry, expiresAt time.Time) {
+	if l.capacity < 1 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[key]; ok {
+		item := elem.Value.(*lruItem)
+		item.entry, item.expiresAt = entry, expiresAt
+		l.order.MoveToFront(elem)
+		return
+	}
+
+	l.items[key] = l.order.PushFront(&lruItem{key: key, entry: entry, expiresAt: expiresAt})
+
+	if l.order.Len() > l.capacity {
+		l.remove(l.order.Back())
+		geocodeCacheEvictions.Inc()
+	}
+}
+
+// remove drops the entry, it must be called with the lock held
+func (l *lru) remove(elem *list.Element) {
+	l.order.Remove(elem)
+	delete(l.items, elem.Value.(*lruItem).key)
+}
@@ -0,0 +1,73 @@
+package openweather
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "openweather",
+		Name:      "requests_total",
+		Help:      "Number of calls made to the OpenWeather API, partitioned by endpoint and outcome.",
+	}, []string{"endpoint", "outcome"})
+
+	fetchWeatherDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "weather",
+		Subsystem: "openweather",
+		Name:      "fetch_weather_duration_seconds",
+		Help:      "Time taken to fetch the forecast for a location from the OpenWeather one call API.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	breakerState = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "weather",
+		Subsystem: "openweather",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the OpenWeather circuit breaker, 0 when closed, 1 when half-open and 2 when open.",
+	})
+
+	breakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "openweather",
+		Name:      "circuit_breaker_transitions_total",
+		Help:      "Number of times the OpenWeather circuit breaker changed state, partitioned by the state it moved to.",
+	}, []string{"state"})
+
+	breakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "openweather",
+		Name:      "circuit_breaker_rejections_total",
+		Help:      "Number of calls refused without reaching OpenWeather because the circuit breaker was open, partitioned by endpoint.",
+	}, []string{"endpoint"})
+
+	geocodeCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "geocode_cache",
+		Name:      "lookups_total",
+		Help:      "Number of geocode cache lookups, partitioned by tier (local or redis) and result (hit, negative_hit, miss or error).",
+	}, []string{"tier", "result"})
+
+	candidatesCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "geocode_cache",
+		Name:      "candidate_lookups_total",
+		Help:      "Number of lookups of the places cached for a location search, partitioned by result (hit, miss or error).",
+	}, []string{"result"})
+
+	geocodeCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "geocode_cache",
+		Name:      "evictions_total",
+		Help:      "Number of geocoded locations evicted from the in-process cache to make room for others.",
+	})
+)
+
+func outcomeLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "success"
+}
@@ -1,136 +1,317 @@
 package openweather
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
+// refreshInterval is roughly how often OpenWeather issues a new forecast, cached responses are kept until then
+const refreshInterval = 30 * time.Minute
+
+// WeatherService forecasts the weather with the OpenWeather one call API, which covers the next 7 days
 type WeatherService struct {
-	logger            *zap.Logger
-	openWeatherAPIKey string
+	client *Client
+	cache  weather.ForecastCache
+	logger *zap.Logger
 }
 
-func NewWeatherService(logger *zap.Logger, openWeatherAPIKey string) *WeatherService {
+func NewWeatherService(client *Client, cache weather.ForecastCache, logger *zap.Logger) *WeatherService {
 	return &WeatherService{
-		logger:            logger,
-		openWeatherAPIKey: openWeatherAPIKey,
+		client: client,
+		cache:  cache,
+		logger: logger,
 	}
 }
 
-func (ws *WeatherService) FetchWeather(
+var _ weather.Provider = (*WeatherService)(nil)
+
+func (ws *WeatherService) Name() string {
+	return "openweather"
+}
+
+func (ws *WeatherService) Forecast(
+	ctx context.Context,
 	location *weather.GeocodedLocation,
-	timeToCheckFor time.Time,
-) (*weather.WeatherSummary, error) {
-	checkForTimeTruncatedToHour := time.Date(
-		timeToCheckFor.Year(), timeToCheckFor.Month(), timeToCheckFor.Day(),
-		timeToCheckFor.Hour(), 0, 0, 0, timeToCheckFor.Location(),
+	startsAt, endsAt time.Time,
+) (_ *weather.WeatherSummary, err error) {
+	ctx, span := tracer.Start(ctx, "WeatherService.Forecast")
+	span.SetAttributes(
+		attribute.String("location.lat", location.Latitude),
+		attribute.String("location.lon", location.Longitude),
 	)
+	defer endSpan(span, &err)
+
+	from, to := weather.Span(startsAt, endsAt)
 
-	hoursUntilTime := time.Until(checkForTimeTruncatedToHour).Hours()
+	hoursUntilTime := time.Until(from).Hours()
 
 	switch {
 	case hoursUntilTime < 48:
-		return ws.fetchWeatherFromHourlyForecast(location, timeToCheckFor)
+		return ws.fetchWeatherFromHourlyForecast(ctx, location, from, to)
 
 	case hoursUntilTime < 168:
-		return ws.fetchWeatherFromDailyForecast(location, timeToCheckFor)
+		return ws.fetchWeatherFromDailyForecast(ctx, location, startsAt, from, to)
 	}
 
-	return nil, fmt.Errorf("event too far in the future")
-
+	return nil, fmt.Errorf("%w: openweather forecasts up to 7 days ahead", weather.ErrOutOfRange)
 }
 
+// fetchWeatherFromHourlyForecast summarises the hours between from and to, the hourly forecast covers the next 48 hours
+// so the end of a longer event is left out
 func (ws *WeatherService) fetchWeatherFromHourlyForecast(
+	ctx context.Context,
 	location *weather.GeocodedLocation,
-	timeToCheckFor time.Time,
+	from, to time.Time,
 ) (*weather.WeatherSummary, error) {
-	result, err := ws.fetchWeatherForLocation(location)
+	result, err := ws.fetchWeatherForLocation(ctx, location)
 	if err != nil {
 		return nil, err
 	}
 
-	// now find the weather for the relevant hour
+	hours := make([]weather.HourlyWeather, 0)
 	for _, item := range result.Hourly {
-		if int64(item.Dt) >= timeToCheckFor.Unix() {
-			weatherInfo := item.Weather[0]
-
-			return &weather.WeatherSummary{
-				Type:        weatherInfo.Main,
-				Description: weatherInfo.Description,
-				Temp:        fmt.Sprintf("%f", item.Temp),
-			}, nil
+		at := time.Unix(int64(item.Dt), 0).UTC()
+		if at.Before(from) || !at.Before(to) || len(item.Weather) == 0 {
+			continue
 		}
+
+		hours = append(hours, weather.HourlyWeather{
+			Time:                     at,
+			Type:                     item.Weather[0].Main,
+			Description:              item.Weather[0].Description,
+			Condition:                conditionFor(item.Weather[0].ID),
+			Temp:                     item.Temp,
+			FeelsLike:                item.FeelsLike,
+			PrecipitationProbability: item.Pop,
+			PrecipitationVolume:      item.Rain.OneHour + item.Snow.OneHour,
+			WindSpeed:                item.WindSpeed,
+			WindGust:                 item.WindGust,
+			UVIndex:                  item.UVI,
+			Humidity:                 item.Humidity,
+		})
+	}
+
+	if len(hours) == 0 {
+		return nil, fmt.Errorf("%w: weather for time after %d was not found", weather.ErrOutOfRange, from.Unix())
 	}
 
-	return nil, fmt.Errorf("weather for time after %d was not found", timeToCheckFor.Unix())
+	summary := weather.SummariseHours(hours)
+	result.describe(summary, from, to)
+
+	return summary, nil
 }
 
+// fetchWeatherFromDailyForecast summarises the days between from and to, the temperatures when the event starts are
+// taken from the part of the day it starts in
 func (ws *WeatherService) fetchWeatherFromDailyForecast(
+	ctx context.Context,
 	location *weather.GeocodedLocation,
-	timeToCheckFor time.Time,
+	startsAt, from, to time.Time,
 ) (*weather.WeatherSummary, error) {
 
-	result, err := ws.fetchWeatherForLocation(location)
+	result, err := ws.fetchWeatherForLocation(ctx, location)
 	if err != nil {
 		return nil, err
 	}
 
-	// now find the weather for the relevant day
-	for _, item := range result.Daily {
-		if int64(item.Dt) >= timeToCheckFor.Unix() {
-			weatherInfo := item.Weather[0]
-
-			var temp float64
-
-			if timeToCheckFor.Hour() < 10 {
-				temp = item.Temp.Morn
-			} else if timeToCheckFor.Hour() < 17 {
-				temp = item.Temp.Day
-			} else if timeToCheckFor.Hour() < 20 {
-				temp = item.Temp.Eve
-			} else {
-				temp = item.Temp.Night
-			}
-
-			return &weather.WeatherSummary{
-				Type:        weatherInfo.Main,
-				Description: weatherInfo.Description,
-				Temp:        fmt.Sprintf("%f", temp),
-			}, nil
+	var first *dailyForecast
+
+	days := make([]weather.DailyWeather, 0)
+	for i, item := range result.Daily {
+		if !item.overlaps(from, to) || len(item.Weather) == 0 {
+			continue
+		}
+
+		if first == nil {
+			first = &result.Daily[i]
 		}
+
+		days = append(days, weather.DailyWeather{
+			Date:                     item.date(),
+			Type:                     item.Weather[0].Main,
+			Description:              item.Weather[0].Description,
+			Condition:                conditionFor(item.Weather[0].ID),
+			MinTemp:                  item.Temp.Min,
+			MaxTemp:                  item.Temp.Max,
+			PrecipitationProbability: item.Pop,
+			PrecipitationVolume:      item.Rain + item.Snow,
+			WindSpeed:                item.WindSpeed,
+			WindGust:                 item.WindGust,
+			UVIndex:                  item.UVI,
+			Humidity:                 item.Humidity,
+		})
+	}
+
+	if len(days) == 0 {
+		return nil, fmt.Errorf("%w: weather for time after %d was not found", weather.ErrOutOfRange, from.Unix())
 	}
 
-	return nil, fmt.Errorf("weather for time after %d was not found", timeToCheckFor.Unix())
+	summary := weather.SummariseDays(days)
+	summary.Temp = first.Temp.at(startsAt)
+	summary.FeelsLike = first.FeelsLike.at(startsAt)
+	result.describe(summary, from, to)
+
+	return summary, nil
 }
 
 func (ws *WeatherService) fetchWeatherForLocation(
+	ctx context.Context,
 	location *weather.GeocodedLocation,
-) (*weather.WeatherResponse, error) {
-	url := fmt.Sprintf(
-		"https://api.openweathermap.org/data/2.5/onecall?lat=%s&lon=%s&exclude=current,minutely,alerts&units=metric&appid=%s",
-		location.Latitude,
-		location.Longitude,
-		ws.openWeatherAPIKey,
-	)
-
-	ws.logger.Debug("requesting weather information", zap.String("url", url))
-
-	resp, err := http.Get(url)
+) (*oneCallResponse, error) {
+	body, err := ws.cache.Fetch(ctx, ws.Name(), location, refreshInterval, func(ctx context.Context) ([]byte, error) {
+		return ws.requestOneCall(ctx, location)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var response *weather.WeatherResponse
+	var response *oneCallResponse
 
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, err
 	}
 
 	return response, nil
 }
+
+// requestOneCall returns the body of the one call API's response
+func (ws *WeatherService) requestOneCall(ctx context.Context, location *weather.GeocodedLocation) ([]byte, error) {
+	timer := prometheus.NewTimer(fetchWeatherDuration)
+	defer timer.ObserveDuration()
+
+	return ws.client.get(ctx, "onecall", "/data/2.5/onecall", url.Values{
+		"lat":     {location.Latitude},
+		"lon":     {location.Longitude},
+		"exclude": {"current,minutely"},
+		// forecasts are stored in metric and English, the api converts them to what clients prefer
+		"units": {"metric"},
+	})
+}
+
+type oneCallResponse struct {
+	Hourly []hourlyForecast `json:"hourly"`
+	Daily  []dailyForecast  `json:"daily"`
+	Alerts []alert          `json:"alerts"`
+}
+
+// describe adds what the summaries of hours and days can't work out, the day's sunrise and sunset and the alerts
+// in force between from and to
+func (r *oneCallResponse) describe(summary *weather.WeatherSummary, from, to time.Time) {
+	for _, day := range r.Daily {
+		if day.date().Equal(from.UTC().Truncate(24 * time.Hour)) {
+			sunrise, sunset := time.Unix(day.Sunrise, 0).UTC(), time.Unix(day.Sunset, 0).UTC()
+			summary.Sunrise, summary.Sunset = &sunrise, &sunset
+			break
+		}
+	}
+
+	for _, item := range r.Alerts {
+		alert := weather.Alert{
+			Event:       item.Event,
+			Sender:      item.SenderName,
+			Description: item.Description,
+			Start:       time.Unix(item.Start, 0).UTC(),
+			End:         time.Unix(item.End, 0).UTC(),
+			Tags:        item.Tags,
+		}
+
+		if alert.Overlaps(from, to) {
+			summary.Alerts = append(summary.Alerts, alert)
+		}
+	}
+}
+
+type hourlyForecast struct {
+	Dt        int           `json:"dt"`
+	Temp      float64       `json:"temp"`
+	FeelsLike float64       `json:"feels_like"`
+	Humidity  int           `json:"humidity"`
+	UVI       float64       `json:"uvi"`
+	WindSpeed float64       `json:"wind_speed"`
+	WindGust  float64       `json:"wind_gust"`
+	Pop       float64       `json:"pop"`
+	Rain      volume        `json:"rain"`
+	Snow      volume        `json:"snow"`
+	Weather   []information `json:"weather"`
+}
+
+// volume is the precipitation in the last hour, it's left out of the response when there's none
+type volume struct {
+	OneHour float64 `json:"1h"`
+}
+
+type dailyForecast struct {
+	Dt      int   `json:"dt"`
+	Sunrise int64 `json:"sunrise"`
+	Sunset  int64 `json:"sunset"`
+	Temp    struct {
+		partsOfDay
+		Min float64 `json:"min"`
+		Max float64 `json:"max"`
+	} `json:"temp"`
+	FeelsLike partsOfDay    `json:"feels_like"`
+	Humidity  int           `json:"humidity"`
+	UVI       float64       `json:"uvi"`
+	WindSpeed float64       `json:"wind_speed"`
+	WindGust  float64       `json:"wind_gust"`
+	Pop       float64       `json:"pop"`
+	Rain      float64       `json:"rain"`
+	Snow      float64       `json:"snow"`
+	Weather   []information `json:"weather"`
+}
+
+// date is the day in UTC, each day is given at around midday
+func (d dailyForecast) date() time.Time {
+	return time.Unix(int64(d.Dt), 0).UTC().Truncate(24 * time.Hour)
+}
+
+// overlaps reports whether any of the day falls between from and to
+func (d dailyForecast) overlaps(from, to time.Time) bool {
+	date := d.date()
+	return date.Add(24*time.Hour).After(from) && date.Before(to)
+}
+
+type partsOfDay struct {
+	Day   float64 `json:"day"`
+	Night float64 `json:"night"`
+	Eve   float64 `json:"eve"`
+	Morn  float64 `json:"morn"`
+}
+
+// at returns the temperature for the part of the day the time falls in
+func (p partsOfDay) at(t time.Time) float64 {
+	switch {
+	case t.Hour() < 10:
+		return p.Morn
+	case t.Hour() < 17:
+		return p.Day
+	case t.Hour() < 20:
+		return p.Eve
+	}
+
+	return p.Night
+}
+
+type information struct {
+	ID          int    `json:"id"`
+	Main        string `json:"main"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+}
+
+type alert struct {
+	SenderName  string   `json:"sender_name"`
+	Event       string   `json:"event"`
+	Start       int64    `json:"start"`
+	End         int64    `json:"end"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
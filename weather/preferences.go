@@ -0,0 +1,125 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Units is a system of units forecasts can be served in, the same ones OpenWeather offers. Precipitation is in
+// millimetres in all of them
+type Units string
+
+const (
+	// UnitsMetric is degrees Celsius and metres a second, forecasts are stored in it
+	UnitsMetric Units = "metric"
+	// UnitsImperial is degrees Fahrenheit and miles an hour
+	UnitsImperial Units = "imperial"
+	// UnitsStandard is kelvin and metres a second
+	UnitsStandard Units = "standard"
+)
+
+// DefaultLanguage is the language forecasts are stored in
+const DefaultLanguage = "en"
+
+// ErrUnsupportedUnits is returned for units other than metric, imperial and standard
+var ErrUnsupportedUnits = errors.New("unsupported units")
+
+// ParseUnits reads a units system by name, metric when there's no name
+func ParseUnits(name string) (Units, error) {
+	switch units := Units(strings.ToLower(strings.TrimSpace(name))); units {
+	case "":
+		return UnitsMetric, nil
+	case UnitsMetric, UnitsImperial, UnitsStandard:
+		return units, nil
+	}
+
+	return "", fmt.Errorf("%w: %s, use metric, imperial or standard", ErrUnsupportedUnits, name)
+}
+
+// Language returns the first supported language in the lists, such as a lang parameter followed by an
+// Accept-Language header, or DefaultLanguage when none are supported. Regions are ignored, en-GB is English
+func Language(lists ...string) string {
+	for _, list := range lists {
+		for _, tag := range strings.Split(list, ",") {
+			// weights aren't needed, browsers list languages in order of preference
+			tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+			tag = strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+
+			if _, ok := descriptions[tag]; ok || tag == DefaultLanguage {
+				return tag
+			}
+		}
+	}
+
+	return DefaultLanguage
+}
+
+// Preferences are how a client wants forecasts served
+type Preferences struct {
+	Units    Units
+	Language string
+}
+
+// Present returns a copy of the summary in the preferred units and language, leaving the stored summary as it is.
+// Descriptions without a known condition, such as those stored before conditions were, stay in English
+func (s *WeatherSummary) Present(preferences Preferences) *WeatherSummary {
+	summary := *s
+
+	summary.Temp = temperature(s.Temp, preferences.Units)
+	summary.FeelsLike = temperature(s.FeelsLike, preferences.Units)
+	summary.MinTemp = temperature(s.MinTemp, preferences.Units)
+	summary.MaxTemp = temperature(s.MaxTemp, preferences.Units)
+	summary.WindSpeed = speed(s.WindSpeed, preferences.Units)
+	summary.WindGust = speed(s.WindGust, preferences.Units)
+	summary.Description = describe(s.Condition, s.Description, preferences.Language)
+
+	if s.Hourly != nil {
+		summary.Hourly = make([]HourlyWeather, len(s.Hourly))
+		for i, hour := range s.Hourly {
+			hour.Temp = temperature(hour.Temp, preferences.Units)
+			hour.FeelsLike = temperature(hour.FeelsLike, preferences.Units)
+			hour.WindSpeed = speed(hour.WindSpeed, preferences.Units)
+			hour.WindGust = speed(hour.WindGust, preferences.Units)
+			hour.Description = describe(hour.Condition, hour.Description, preferences.Language)
+			summary.Hourly[i] = hour
+		}
+	}
+
+	return &summary
+}
+
+// temperature converts from degrees Celsius
+func temperature(celsius float64, units Units) float64 {
+	switch units {
+	case UnitsImperial:
+		return round(celsius*9/5 + 32)
+	case UnitsStandard:
+		return round(celsius + 273.15)
+	}
+
+	return celsius
+}
+
+// speed converts from metres a second
+func speed(metresPerSecond float64, units Units) float64 {
+	if units == UnitsImperial {
+		return round(metresPerSecond * 2.236936)
+	}
+
+	return metresPerSecond
+}
+
+// round drops the noise converting leaves, to two decimal places
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func describe(condition, description, language string) string {
+	if translated, ok := descriptions[language][condition]; ok {
+		return translated
+	}
+
+	return description
+}
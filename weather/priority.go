@@ -0,0 +1,54 @@
+package weather
+
+import (
+	"context"
+	"time"
+)
+
+// Priority decides who gets a rate limited API's remaining budget, imminent events win over far-future ones
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	}
+
+	return "low"
+}
+
+// PriorityFor ranks an event by how soon it starts, events within two days are high priority and those over a week away low
+func PriorityFor(startsAt time.Time) Priority {
+	switch until := time.Until(startsAt); {
+	case until < 48*time.Hour:
+		return PriorityHigh
+	case until < 7*24*time.Hour:
+		return PriorityNormal
+	}
+
+	return PriorityLow
+}
+
+type priorityKey struct{}
+
+// WithPriority sets the priority of the API calls made with the context
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFrom returns the priority set with WithPriority, or normal when none was set
+func PriorityFrom(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+
+	return PriorityNormal
+}
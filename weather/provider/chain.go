@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	forecasts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "provider",
+		Name:      "forecasts_total",
+		Help:      "Number of forecasts requested from each provider, partitioned by provider and outcome.",
+	}, []string{"provider", "outcome"})
+
+	failovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "provider",
+		Name:      "failovers_total",
+		Help:      "Number of times a provider failed and the next in the chain was tried, partitioned by the provider that failed.",
+	}, []string{"provider"})
+)
+
+// Chain asks each provider in turn until one of them returns a forecast
+type Chain struct {
+	providers []weather.Provider
+	logger    *zap.Logger
+}
+
+var _ weather.Provider = (*Chain)(nil)
+
+func NewChain(logger *zap.Logger, providers ...weather.Provider) *Chain {
+	return &Chain{
+		providers: providers,
+		logger:    logger,
+	}
+}
+
+func (c *Chain) Name() string {
+	names := make([]string, len(c.providers))
+	for i, provider := range c.providers {
+		names[i] = provider.Name()
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Forecast returns the first forecast a provider manages. When they all fail ErrOutOfRange is returned if every
+// provider was asked about a time too far ahead, otherwise ErrUnavailable
+func (c *Chain) Forecast(ctx context.Context, location *weather.GeocodedLocation, startsAt, endsAt time.Time) (*weather.WeatherSummary, error) {
+	failures := make([]string, 0, len(c.providers))
+	outOfRange := true
+
+	for i, provider := range c.providers {
+		summary, err := provider.Forecast(ctx, location, startsAt, endsAt)
+		if err == nil {
+			forecasts.WithLabelValues(provider.Name(), "success").Inc()
+			return summary, nil
+		}
+
+		forecasts.WithLabelValues(provider.Name(), "error").Inc()
+
+		if ctx.Err() != nil {
+			// the next provider won't have any more time than this one did
+			return nil, err
+		}
+
+		failures = append(failures, fmt.Sprintf("%s: %v", provider.Name(), err))
+		outOfRange = outOfRange && errors.Is(err, weather.ErrOutOfRange)
+
+		if i < len(c.providers)-1 {
+			failovers.WithLabelValues(provider.Name()).Inc()
+			c.logger.Warn(
+				"weather provider failed, trying the next",
+				zap.String("provider", provider.Name()),
+				zap.String("next", c.providers[i+1].Name()),
+				zap.Error(err),
+			)
+		}
+	}
+
+	cause := weather.ErrUnavailable
+	if outOfRange {
+		cause = weather.ErrOutOfRange
+	}
+
+	return nil, fmt.Errorf("%w: every provider failed (%s)", cause, strings.Join(failures, "; "))
+}
@@ -0,0 +1,99 @@
+// Package provider builds the chain of weather providers a service forecasts with, and routes past and far-off
+// events to the providers describing them
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/alexdunne/not-so-smart-cal/weather/fixture"
+	"github.com/alexdunne/not-so-smart-cal/weather/openmeteo"
+	"github.com/alexdunne/not-so-smart-cal/weather/openweather"
+	"go.uber.org/zap"
+)
+
+// Config picks the providers to forecast with
+type Config struct {
+	Providers   string `yaml:"providers" env:"WEATHER_PROVIDERS" default:"openweather,open-meteo" usage:"comma separated providers to try in order, from openweather, open-meteo and fixture"`
+	FixturePath string `yaml:"fixturePath" env:"WEATHER_FIXTURE_PATH" usage:"JSON file of forecasts served by the fixture provider, a clear sky everywhere when empty"`
+	History     string `yaml:"history" env:"WEATHER_HISTORY_PROVIDER" default:"open-meteo" usage:"provider of the weather observed during past events, from open-meteo, fixture or none"`
+	Climate     string `yaml:"climate" env:"WEATHER_CLIMATE_PROVIDER" default:"open-meteo" usage:"provider of estimates for events too far ahead to forecast, from open-meteo, fixture or none"`
+}
+
+// New routes events to the configured providers. Forecasts come from a chain of the providers in the order they're
+// listed, the OpenWeather client is shared with anything else calling OpenWeather
+func New(
+	cfg Config,
+	openWeather *openweather.Client,
+	cache weather.ForecastCache,
+	logger *zap.Logger,
+) (*Router, error) {
+	var providers []weather.Provider
+
+	for _, name := range strings.Split(cfg.Providers, ",") {
+		switch strings.TrimSpace(name) {
+		case "openweather":
+			providers = append(providers, openweather.NewWeatherService(openWeather, cache, logger))
+
+		case "open-meteo":
+			providers = append(providers, openmeteo.NewWeatherService(cache, logger))
+
+		case "fixture":
+			provider, err := newFixture(cfg)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, provider)
+
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown weather provider %q, expected openweather, open-meteo or fixture", name)
+		}
+	}
+
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no weather providers configured")
+	}
+
+	var history, climate weather.Provider
+
+	switch strings.TrimSpace(cfg.History) {
+	case "open-meteo":
+		history = openmeteo.NewHistoryService(logger)
+	case "fixture":
+		provider, err := newFixture(cfg)
+		if err != nil {
+			return nil, err
+		}
+		history = provider
+	case "none", "":
+	default:
+		return nil, fmt.Errorf("unknown history provider %q, expected open-meteo, fixture or none", cfg.History)
+	}
+
+	switch strings.TrimSpace(cfg.Climate) {
+	case "open-meteo":
+		climate = openmeteo.NewClimateService(cache, logger)
+	case "fixture":
+		provider, err := newFixture(cfg)
+		if err != nil {
+			return nil, err
+		}
+		climate = provider
+	case "none", "":
+	default:
+		return nil, fmt.Errorf("unknown climate provider %q, expected open-meteo, fixture or none", cfg.Climate)
+	}
+
+	return NewRouter(logger, NewChain(logger, providers...), history, climate), nil
+}
+
+// newFixture serves the configured fixtures, or a clear sky everywhere
+func newFixture(cfg Config) (*fixture.WeatherService, error) {
+	if cfg.FixturePath == "" {
+		return fixture.NewWeatherService(), nil
+	}
+
+	return fixture.LoadWeatherService(cfg.FixturePath)
+}
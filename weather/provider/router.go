@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"go.uber.org/zap"
+)
+
+// Router describes an event's weather according to when it is. Events that have finished get the weather observed
+// during them, the rest are forecast by the chain, and events too far ahead for any forecast are estimated from
+// previous years until they come into range
+type Router struct {
+	forecasts *Chain
+	// history and climate are nil when they're turned off
+	history weather.Provider
+	climate weather.Provider
+	logger  *zap.Logger
+}
+
+var _ weather.Provider = (*Router)(nil)
+
+func NewRouter(logger *zap.Logger, forecasts *Chain, history, climate weather.Provider) *Router {
+	return &Router{
+		forecasts: forecasts,
+		history:   history,
+		climate:   climate,
+		logger:    logger,
+	}
+}
+
+func (r *Router) Name() string {
+	return r.forecasts.Name()
+}
+
+// Forecast returns the summary for the event with its source and confidence set. ErrOutOfRange is returned for
+// finished events without a history provider and far-off events without a climate provider
+func (r *Router) Forecast(
+	ctx context.Context,
+	location *weather.GeocodedLocation,
+	startsAt, endsAt time.Time,
+) (*weather.WeatherSummary, error) {
+	if _, to := weather.Span(startsAt, endsAt); !to.After(time.Now()) {
+		if r.history == nil {
+			return nil, fmt.Errorf("%w: the event has finished and there's no history provider", weather.ErrOutOfRange)
+		}
+
+		summary, err := r.summarise(ctx, r.history, location, startsAt, endsAt)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.Source, summary.Confidence = weather.SourceHistorical, weather.ConfidenceHigh
+		return summary, nil
+	}
+
+	summary, err := r.forecasts.Forecast(ctx, location, startsAt, endsAt)
+	if err == nil {
+		summary.Source, summary.Confidence = weather.SourceForecast, weather.ForecastConfidence(startsAt)
+		return summary, nil
+	}
+
+	if !errors.Is(err, weather.ErrOutOfRange) || r.climate == nil {
+		return nil, err
+	}
+
+	r.logger.Info("event is too far ahead to forecast, estimating its weather", zap.String("provider", r.climate.Name()))
+
+	summary, err = r.summarise(ctx, r.climate, location, startsAt, endsAt)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Source, summary.Confidence = weather.SourceClimate, weather.ConfidenceLow
+	return summary, nil
+}
+
+// summarise asks a provider outside of the chain, counting its outcome alongside the chain's
+func (r *Router) summarise(
+	ctx context.Context,
+	provider weather.Provider,
+	location *weather.GeocodedLocation,
+	startsAt, endsAt time.Time,
+) (*weather.WeatherSummary, error) {
+	summary, err := provider.Forecast(ctx, location, startsAt, endsAt)
+	if err != nil {
+		forecasts.WithLabelValues(provider.Name(), "error").Inc()
+		return nil, err
+	}
+
+	forecasts.WithLabelValues(provider.Name(), "success").Inc()
+	return summary, nil
+}
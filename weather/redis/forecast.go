@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// forecastFetchTimeout bounds a shared fetch, it isn't tied to any one caller so it can't be cancelled by them
+const forecastFetchTimeout = 30 * time.Second
+
+var forecastCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "weather",
+	Subsystem: "forecast_cache",
+	Name:      "lookups_total",
+	Help:      "Number of forecast cache lookups, partitioned by provider and result (hit, miss, shared or error).",
+}, []string{"provider", "result"})
+
+// ForecastCache caches provider responses in redis so every replica and the background refresh share them.
+// Entries are keyed by the location rounded to about a kilometre and the provider's current issue of the forecast,
+// they expire when the provider is due to issue the next one
+type ForecastCache struct {
+	redisClient *redis.Client
+	group       singleflight.Group
+}
+
+var _ weather.ForecastCache = (*ForecastCache)(nil)
+
+func NewForecastCache(client *redis.Client) *ForecastCache {
+	return &ForecastCache{redisClient: client}
+}
+
+// Fetch returns the cached response, on a miss concurrent callers for the same key wait on a single call to fetch
+func (c *ForecastCache) Fetch(
+	ctx context.Context,
+	provider string,
+	location *weather.GeocodedLocation,
+	refresh time.Duration,
+	fetch func(ctx context.Context) ([]byte, error),
+) ([]byte, error) {
+	now := time.Now().UTC()
+	issuedAt := now.Truncate(refresh)
+	ttl := issuedAt.Add(refresh).Sub(now)
+
+	key := fmt.Sprintf(
+		"forecast:%s:%s:%s:%d",
+		provider,
+		roundCoordinate(location.Latitude),
+		roundCoordinate(location.Longitude),
+		issuedAt.Unix(),
+	)
+
+	result := c.group.DoChan(key, func() (interface{}, error) {
+		// keep the trace of whoever started the fetch but not their cancellation, others may still be waiting on it
+		ctx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), forecastFetchTimeout)
+		defer cancel()
+
+		cached, err := c.redisClient.Get(ctx, key).Bytes()
+		switch {
+		case err == nil:
+			forecastCacheLookups.WithLabelValues(provider, "hit").Inc()
+			return cached, nil
+		case err == redis.Nil:
+			forecastCacheLookups.WithLabelValues(provider, "miss").Inc()
+		default:
+			// fall back to the provider, the cache being down shouldn't stop forecasts
+			forecastCacheLookups.WithLabelValues(provider, "error").Inc()
+		}
+
+		body, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.redisClient.Set(ctx, key, body, ttl).Err(); err != nil {
+			forecastCacheLookups.WithLabelValues(provider, "error").Inc()
+		}
+
+		return body, nil
+	})
+
+	select {
+	case res := <-result:
+		if res.Shared {
+			forecastCacheLookups.WithLabelValues(provider, "shared").Inc()
+		}
+
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// roundCoordinate rounds to two decimal places, about a kilometre, so nearby locations share a forecast
+func roundCoordinate(coordinate string) string {
+	value, err := strconv.ParseFloat(coordinate, 64)
+	if err != nil {
+		return coordinate
+	}
+
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
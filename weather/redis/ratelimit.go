@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/alexdunne/not-so-smart-cal/weather"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrQuotaExhausted is returned once the day's quota has been used up for the call's priority.
+// It wraps weather.ErrUnavailable so the next provider is tried instead
+var ErrQuotaExhausted = fmt.Errorf("%w: daily quota exhausted", weather.ErrUnavailable)
+
+// ErrRateLimited is returned when the minute's calls are used up and the next window opens after the context's deadline.
+// It wraps weather.ErrUnavailable so the next provider is tried, or the event deferred, rather than timing out
+var ErrRateLimited = fmt.Errorf("%w: rate limit reached", weather.ErrUnavailable)
+
+var (
+	rateLimitWaits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "rate_limit",
+		Name:      "waits_total",
+		Help:      "Number of times a call waited for the next window of the rate limit, partitioned by api and priority.",
+	}, []string{"api", "priority"})
+
+	quotaExhausted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "rate_limit",
+		Name:      "quota_exhausted_total",
+		Help:      "Number of calls refused because the daily quota was used up, partitioned by api and priority.",
+	}, []string{"api", "priority"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "weather",
+		Subsystem: "rate_limit",
+		Name:      "deadline_refusals_total",
+		Help:      "Number of calls refused because the next window of the rate limit opens after their deadline, partitioned by api and priority.",
+	}, []string{"api", "priority"})
+)
+
+// priorityShares are how much of each limit a priority may use, the rest is kept back for more urgent calls
+var priorityShares = map[weather.Priority]float64{
+	weather.PriorityHigh:   1,
+	weather.PriorityNormal: 0.8,
+	weather.PriorityLow:    0.5,
+}
+
+// acquireScript counts a call against both the minute window and the day, unless either is already at its limit.
+// It returns 0 when the call may go ahead, 1 when the minute is full and 2 when the day is
+var acquireScript = redis.NewScript(`
+local minute = tonumber(redis.call('GET', KEYS[1]) or '0')
+local day = tonumber(redis.call('GET', KEYS[2]) or '0')
+
+if day >= tonumber(ARGV[2]) then
+	return 2
+end
+
+if minute >= tonumber(ARGV[1]) then
+	return 1
+end
+
+redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('INCR', KEYS[2])
+redis.call('EXPIRE', KEYS[2], ARGV[4])
+
+return 0
+`)
+
+// RateLimiter shares an API's per-minute rate limit and daily quota between every replica.
+// Calls are counted in fixed windows, a minute and a UTC day, and lower priority calls may only use part of each
+type RateLimiter struct {
+	redisClient *redis.Client
+	api         string
+	perMinute   int
+	perDay      int
+}
+
+func NewRateLimiter(client *redis.Client, api string, perMinute, perDay int) *RateLimiter {
+	return &RateLimiter{
+		redisClient: client,
+		api:         api,
+		perMinute:   perMinute,
+		perDay:      perDay,
+	}
+}
+
+// Wait blocks until the call may be made, using the priority from the context.
+// It returns ErrQuotaExhausted straight away once the day's quota is used up rather than waiting for tomorrow,
+// and ErrRateLimited when the context's deadline would pass before the next window opens
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	priority := weather.PriorityFrom(ctx)
+	share := priorityShares[priority]
+
+	perMinute := int(math.Ceil(float64(l.perMinute) * share))
+	perDay := int(math.Ceil(float64(l.perDay) * share))
+
+	for {
+		now := time.Now().UTC()
+		minute := now.Truncate(time.Minute)
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+		result, err := acquireScript.Run(
+			ctx,
+			l.redisClient,
+			[]string{
+				fmt.Sprintf("ratelimit:%s:minute:%d", l.api, minute.Unix()),
+				fmt.Sprintf("ratelimit:%s:day:%s", l.api, day.Format("2006-01-02")),
+			},
+			perMinute,
+			perDay,
+			// keep the counters a little longer than their window so clock skew between replicas can't reset them early
+			int((2 * time.Minute).Seconds()),
+			int((25 * time.Hour).Seconds()),
+		).Int()
+		if err != nil {
+			return fmt.Errorf("%w: error checking the rate limit: %v", weather.ErrUnavailable, err)
+		}
+
+		switch result {
+		case 0:
+			return nil
+		case 2:
+			quotaExhausted.WithLabelValues(l.api, priority.String()).Inc()
+			return ErrQuotaExhausted
+		}
+
+		// spread the replicas out a little so they don't all try again at the same moment
+		delay := time.Until(minute.Add(time.Minute)) + time.Duration(rand.Int63n(int64(250*time.Millisecond)))
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			rateLimited.WithLabelValues(l.api, priority.String()).Inc()
+			return fmt.Errorf("%w, the next window opens in %s", ErrRateLimited, delay.Round(time.Millisecond))
+		}
+
+		rateLimitWaits.WithLabelValues(l.api, priority.String()).Inc()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
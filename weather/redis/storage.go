@@ -14,6 +14,30 @@ import (
 
 var ErrNotFound = errors.New("no results found")
 
+// ErrUnknownVersion is returned for events stored in a format newer than this build understands
+var ErrUnknownVersion = errors.New("event stored in an unknown format")
+
+// storageVersion is the format events are stored in, bump it whenever weather.Event changes in a way that older
+// events can't be read as they are and upgrade them in convertJsonToEvent
+const storageVersion = 2
+
+// storedEvent is how events are stored from version 2, the events stored before that are bare
+type storedEvent struct {
+	Version int             `json:"version"`
+	Event   json.RawMessage `json:"event"`
+}
+
+// eventV1 is the first format events were stored in, where the temperature was a string
+type eventV1 struct {
+	weather.Event
+	WeatherSummary *summaryV1 `json:"weatherSummary"`
+}
+
+type summaryV1 struct {
+	weather.WeatherSummary
+	Temp string `json:"temp"`
+}
+
 type Storage struct {
 	redisClient            *redis.Client
 	storageKey             string
@@ -51,11 +75,27 @@ func (s *Storage) Set(ctx context.Context, key string, value *weather.Event) err
 		if err := s.storeAsFutureEvent(ctx, value); err != nil {
 			return err
 		}
+	} else {
+		// it may have been moved into the past, it shouldn't be refreshed any more
+		if err := s.redisClient.ZRem(ctx, s.futureEventsStorageKey, value.ID).Err(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Delete removes the event's weather and stops it being refreshed, deleting an event that isn't stored isn't an error
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.storageKey, key)
+		pipe.ZRem(ctx, s.futureEventsStorageKey, key)
+		return nil
+	})
+
+	return err
+}
+
 func (s *Storage) GetFutureEvents(ctx context.Context, max time.Time) ([]*weather.Event, error) {
 	eventIds, err := s.redisClient.ZRangeByScore(ctx, s.futureEventsStorageKey, &redis.ZRangeBy{
 		Min: s.now(),
@@ -102,7 +142,12 @@ func (s *Storage) RemoveExpiredFutureEvents(ctx context.Context) error {
 }
 
 func (s *Storage) storeById(ctx context.Context, key string, value *weather.Event) error {
-	jsonVal, err := json.Marshal(value)
+	event, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	jsonVal, err := json.Marshal(&storedEvent{Version: storageVersion, Event: event})
 	if err != nil {
 		return err
 	}
@@ -123,14 +168,69 @@ func (s *Storage) storeAsFutureEvent(ctx context.Context, value *weather.Event)
 }
 
 func (s *Storage) convertJsonToEvent(jsonVal string) (*weather.Event, error) {
+	var stored storedEvent
+	if err := json.Unmarshal([]byte(jsonVal), &stored); err != nil {
+		return nil, err
+	}
+
+	switch stored.Version {
+	case 0:
+		return s.upgradeEventV1(jsonVal)
+	case storageVersion:
+	default:
+		return nil, fmt.Errorf("%w: version %d", ErrUnknownVersion, stored.Version)
+	}
+
 	var result *weather.Event
-	if err := json.Unmarshal([]byte(jsonVal), &result); err != nil {
+	if err := json.Unmarshal(stored.Event, &result); err != nil {
 		return nil, err
 	}
 
+	withSource(result)
+
 	return result, nil
 }
 
+// withSource marks summaries stored before they had a source as the forecasts they were
+func withSource(event *weather.Event) {
+	if event.WeatherSummary != nil && event.WeatherSummary.Source == "" {
+		event.WeatherSummary.Source = weather.SourceForecast
+		event.WeatherSummary.Confidence = weather.ConfidenceMedium
+	}
+}
+
+// upgradeEventV1 reads an event stored before formats were versioned. The summary only had the temperature when
+// the event started, it's used for the rest until the event is next refreshed
+func (s *Storage) upgradeEventV1(jsonVal string) (*weather.Event, error) {
+	var stored eventV1
+	if err := json.Unmarshal([]byte(jsonVal), &stored); err != nil {
+		return nil, err
+	}
+
+	result := stored.Event
+	result.WeatherSummary = nil
+
+	if stored.WeatherSummary != nil {
+		summary := stored.WeatherSummary.WeatherSummary
+
+		temp, err := strconv.ParseFloat(stored.WeatherSummary.Temp, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the temperature of event %s: %w", result.ID, err)
+		}
+
+		summary.Temp, summary.FeelsLike = temp, temp
+		if summary.MinTemp == 0 && summary.MaxTemp == 0 {
+			summary.MinTemp, summary.MaxTemp = temp, temp
+		}
+
+		result.WeatherSummary = &summary
+	}
+
+	withSource(&result)
+
+	return &result, nil
+}
+
 func (s *Storage) now() string {
 	return strconv.FormatInt(time.Now().Unix(), 10)
 }
@@ -0,0 +1,151 @@
+package weather
+
+import (
+	"math"
+	"time"
+)
+
+// maxHourlyBreakdown caps the hours kept in a summary so multi-day events don't store hundreds of them,
+// the summary's totals still cover every hour
+const maxHourlyBreakdown = 72
+
+// HourlyWeather is the forecast for one hour of an event, in the units of WeatherSummary
+type HourlyWeather struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Condition   string    `json:"condition"`
+	Temp        float64   `json:"temp"`
+	FeelsLike   float64   `json:"feelsLike"`
+	// PrecipitationProbability is from 0 to 1
+	PrecipitationProbability float64 `json:"precipitationProbability"`
+	PrecipitationVolume      float64 `json:"precipitationVolume"`
+	WindSpeed                float64 `json:"windSpeed"`
+	WindGust                 float64 `json:"windGust"`
+	UVIndex                  float64 `json:"uvIndex"`
+	Humidity                 int     `json:"humidity"`
+}
+
+// DailyWeather is the forecast for a day, used for events too far ahead for hourly forecasts
+type DailyWeather struct {
+	Date                     time.Time
+	Type                     string
+	Description              string
+	Condition                string
+	MinTemp                  float64
+	MaxTemp                  float64
+	PrecipitationProbability float64
+	PrecipitationVolume      float64
+	WindSpeed                float64
+	WindGust                 float64
+	UVIndex                  float64
+	Humidity                 int
+}
+
+// Span returns the window an event's forecast covers, from the start of the hour it starts in until it ends.
+// Events without a valid end are treated as lasting an hour
+func Span(startsAt, endsAt time.Time) (time.Time, time.Time) {
+	if !endsAt.After(startsAt) {
+		endsAt = startsAt.Add(time.Hour)
+	}
+
+	return startsAt.Truncate(time.Hour), endsAt
+}
+
+// SummariseHours combines the forecasts for each hour of an event, which must be in order and not empty
+func SummariseHours(hours []HourlyWeather) *WeatherSummary {
+	summary := &WeatherSummary{
+		Temp:      hours[0].Temp,
+		FeelsLike: hours[0].FeelsLike,
+		MinTemp:   hours[0].Temp,
+		MaxTemp:   hours[0].Temp,
+	}
+
+	conditions := make([]condition, len(hours))
+	humidity := 0
+	for i, hour := range hours {
+		summary.MinTemp = math.Min(summary.MinTemp, hour.Temp)
+		summary.MaxTemp = math.Max(summary.MaxTemp, hour.Temp)
+		summary.PrecipitationProbability = math.Max(summary.PrecipitationProbability, hour.PrecipitationProbability)
+		summary.PrecipitationVolume += hour.PrecipitationVolume
+		summary.WindSpeed = math.Max(summary.WindSpeed, hour.WindSpeed)
+		summary.WindGust = math.Max(summary.WindGust, hour.WindGust)
+		summary.UVIndex = math.Max(summary.UVIndex, hour.UVIndex)
+		humidity += hour.Humidity
+		conditions[i] = condition{hour.Type, hour.Description, hour.Condition}
+	}
+
+	summary.Humidity = humidity / len(hours)
+
+	dominant := dominantCondition(conditions)
+	summary.Type, summary.Description, summary.Condition = dominant.kind, dominant.description, dominant.code
+
+	if len(hours) > maxHourlyBreakdown {
+		hours = hours[:maxHourlyBreakdown]
+	}
+	summary.Hourly = hours
+
+	return summary
+}
+
+// SummariseDays combines the forecasts for each day of an event, which must be in order and not empty.
+// There's no hourly breakdown, the temperatures are the first day's midpoint until the provider sets them
+func SummariseDays(days []DailyWeather) *WeatherSummary {
+	midpoint := (days[0].MinTemp + days[0].MaxTemp) / 2
+
+	summary := &WeatherSummary{
+		Temp:      midpoint,
+		FeelsLike: midpoint,
+		MinTemp:   days[0].MinTemp,
+		MaxTemp:   days[0].MaxTemp,
+	}
+
+	conditions := make([]condition, len(days))
+	humidity := 0
+	for i, day := range days {
+		summary.MinTemp = math.Min(summary.MinTemp, day.MinTemp)
+		summary.MaxTemp = math.Max(summary.MaxTemp, day.MaxTemp)
+		summary.PrecipitationProbability = math.Max(summary.PrecipitationProbability, day.PrecipitationProbability)
+		summary.PrecipitationVolume += day.PrecipitationVolume
+		summary.WindSpeed = math.Max(summary.WindSpeed, day.WindSpeed)
+		summary.WindGust = math.Max(summary.WindGust, day.WindGust)
+		summary.UVIndex = math.Max(summary.UVIndex, day.UVIndex)
+		humidity += day.Humidity
+		conditions[i] = condition{day.Type, day.Description, day.Condition}
+	}
+
+	summary.Humidity = humidity / len(days)
+
+	dominant := dominantCondition(conditions)
+	summary.Type, summary.Description, summary.Condition = dominant.kind, dominant.description, dominant.code
+
+	return summary
+}
+
+type condition struct {
+	kind        string
+	description string
+	code        string
+}
+
+// dominantCondition returns the condition forecast for the most hours, or days, ties going to the earliest.
+// Its description is the most common one given alongside it
+func dominantCondition(conditions []condition) condition {
+	counts := make(map[string]int)
+	descriptions := make(map[condition]int)
+
+	for _, c := range conditions {
+		counts[c.kind]++
+		descriptions[c]++
+	}
+
+	dominant := conditions[0]
+	for _, c := range conditions {
+		if counts[c.kind] > counts[dominant.kind] ||
+			(c.kind == dominant.kind && descriptions[c] > descriptions[dominant]) {
+			dominant = c
+		}
+	}
+
+	return dominant
+}